@@ -1,7 +1,7 @@
 // example implements a simple gRPC server for authorizing /cookie requests.
 //
-// This implementation is a Proof of Concept -- it doesn't provide any
-// additional security compared to a regular `ssh(1)` session.
+// This implementation is a Proof of Concept -- unless allowed_sans is set it
+// doesn't provide any additional security compared to a regular `ssh(1)` session.
 package main
 
 import (
@@ -11,11 +11,18 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"slices"
+	"sync/atomic"
 
 	"github.com/golang/glog"
+	"github.com/hazaelsan/ssh-relay/reload"
 	"github.com/hazaelsan/ssh-relay/tls"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/protoadapt"
 
 	"github.com/hazaelsan/ssh-relay/cookie-server/backend/example/proto/v1/configpb"
 	"github.com/hazaelsan/ssh-relay/cookie-server/proto/v1/servicepb"
@@ -52,19 +59,36 @@ func main() {
 	if err != nil {
 		glog.Exit(err)
 	}
-	s := &Server{cfg: cfg}
+	s := new(Server)
+	s.cfg.Store(cfg)
+	defer reload.OnSIGHUP(func() error {
+		cfg, err := loadConfig(*cfgFile)
+		if err != nil {
+			return err
+		}
+		s.Reload(cfg)
+		return nil
+	})()
 	glog.Exit(s.Run())
 }
 
 // A Server is a non-authenticating server for Cookie Server gRPC requests.
 type Server struct {
-	cfg *configpb.Config
+	cfg atomic.Pointer[configpb.Config]
+}
+
+// Reload replaces the config, new requests use it right away.
+// Changes to grpc_options need a restart.
+func (s *Server) Reload(cfg *configpb.Config) {
+	old := s.cfg.Swap(cfg)
+	reload.LogRestartFields(old, cfg, "grpc_options")
 }
 
 // Run starts the Server.
 func (s *Server) Run() error {
-	addr := net.JoinHostPort(s.cfg.GetGrpcOptions().GetAddr(), s.cfg.GetGrpcOptions().GetPort())
-	creds, err := tls.TransportCreds(s.cfg.GetGrpcOptions().GetTlsConfig())
+	opts := s.cfg.Load().GetGrpcOptions()
+	addr := net.JoinHostPort(opts.GetAddr(), opts.GetPort())
+	creds, err := tls.TransportCreds(opts.GetTlsConfig())
 	if err != nil {
 		return err
 	}
@@ -78,10 +102,56 @@ func (s *Server) Run() error {
 	return srv.Serve(l)
 }
 
-// Authorize responds to a /cookie authorization request, it always succeeds.
+// reject returns a status error with a user-facing message, and help_url if set.
+// msg is only logged by the Cookie Server, userMsg is shown to clients.
+func (s *Server) reject(cfg *configpb.Config, c codes.Code, msg, userMsg string) error {
+	st := status.New(c, msg)
+	details := []protoadapt.MessageV1{&errdetails.LocalizedMessage{Locale: "en-US", Message: userMsg}}
+	if u := cfg.GetHelpUrl(); u != "" {
+		details = append(details, &errdetails.Help{
+			Links: []*errdetails.Help_Link{{Description: "Requesting access", Url: u}},
+		})
+	}
+	if ds, err := st.WithDetails(details...); err == nil {
+		st = ds
+	}
+	return st.Err()
+}
+
+// identity returns the first SAN in the client's leaf certificate that is in allowed_sans.
+// If allowed_sans is empty all clients are allowed, with an empty identity.
+func (s *Server) identity(cfg *configpb.Config, ci *servicepb.ClientInfo) (string, error) {
+	if len(cfg.GetAllowedSans()) == 0 {
+		return "", nil
+	}
+	certs := ci.GetPeerCertificates()
+	if len(certs) == 0 {
+		return "", s.reject(cfg, codes.Unauthenticated, "no verified client certificate", "A client certificate is required")
+	}
+	leaf := certs[0]
+	for _, sans := range [][]string{leaf.GetEmailAddresses(), leaf.GetUris(), leaf.GetDnsNames()} {
+		for _, san := range sans {
+			if slices.Contains(cfg.GetAllowedSans(), san) {
+				return san, nil
+			}
+		}
+	}
+	return "", s.reject(cfg, codes.PermissionDenied, fmt.Sprintf("%v is not allowed", leaf.GetSubject()), "Your client certificate is not allowed")
+}
+
+// Authorize responds to a /cookie authorization request.
+// Requests are allowed if the client certificate matches allowed_sans, or if allowed_sans is empty.
 func (s *Server) Authorize(ctx context.Context, req *servicepb.AuthorizeRequest) (*servicepb.AuthorizeResponse, error) {
+	cfg := s.cfg.Load()
+	id, err := s.identity(cfg, req.GetClientInfo())
+	if err != nil {
+		glog.Warningf("Rejected request from %v: %v", req.GetClientInfo().GetRemoteAddr(), err)
+		return nil, err
+	}
+	glog.V(1).Infof("Authorized %q from %v", id, req.GetClientInfo().GetRemoteAddr())
 	return &servicepb.AuthorizeResponse{
-		Redirect: &servicepb.AuthorizeResponse_Endpoint{Endpoint: s.cfg.GetSshRelayAddr()},
+		Redirect: &servicepb.AuthorizeResponse_Endpoint{Endpoint: cfg.GetSshRelayAddr()},
 		Method:   req.GetRequest().GetMethod(),
+		Identity: id,
 	}, nil
 }
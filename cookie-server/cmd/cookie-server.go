@@ -9,6 +9,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/hazaelsan/ssh-relay/cookie-server/runner"
+	"github.com/hazaelsan/ssh-relay/reload"
 	"google.golang.org/protobuf/encoding/prototext"
 
 	"github.com/hazaelsan/ssh-relay/cookie-server/proto/v1/configpb"
@@ -36,6 +37,9 @@ func loadConfig(s string) (*configpb.Config, error) {
 	if cfg.OriginCookie.Name == "" {
 		return nil, errors.New("origin_cookie.name must be set")
 	}
+	if cfg.AuthToken != nil && cfg.AuthToken.GetCookie().GetName() == "" {
+		return nil, errors.New("auth_token.cookie.name must be set")
+	}
 	return cfg, nil
 }
 
@@ -52,5 +56,12 @@ func main() {
 	if err != nil {
 		glog.Exit(err)
 	}
+	defer reload.OnSIGHUP(func() error {
+		cfg, err := loadConfig(*cfgFile)
+		if err != nil {
+			return err
+		}
+		return r.Reload(cfg)
+	})()
 	glog.Exit(r.Run())
 }
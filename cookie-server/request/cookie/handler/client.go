@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"crypto/x509"
+	"net/http"
+
+	"github.com/hazaelsan/ssh-relay/cookie-server/proto/v1/servicepb"
+)
+
+// certificate converts an X.509 certificate to a proto message.
+func certificate(c *x509.Certificate) *servicepb.ClientInfo_Certificate {
+	pb := &servicepb.ClientInfo_Certificate{
+		Der:            c.Raw,
+		Subject:        c.Subject.String(),
+		DnsNames:       c.DNSNames,
+		EmailAddresses: c.EmailAddresses,
+	}
+	for _, u := range c.URIs {
+		pb.Uris = append(pb.Uris, u.String())
+	}
+	for _, ip := range c.IPAddresses {
+		pb.IpAddresses = append(pb.IpAddresses, ip.String())
+	}
+	return pb
+}
+
+// clientInfo collects details about the client's HTTP request for the gRPC backend.
+// Only verified client certificates and allowlisted headers/cookies are included.
+func (h *Handler) clientInfo() *servicepb.ClientInfo {
+	ci := &servicepb.ClientInfo{
+		RemoteAddr: h.r.RemoteAddr,
+	}
+	if h.r.TLS != nil && len(h.r.TLS.VerifiedChains) > 0 {
+		for _, c := range h.r.TLS.VerifiedChains[0] {
+			ci.PeerCertificates = append(ci.PeerCertificates, certificate(c))
+		}
+	}
+	for _, name := range h.cfg.GetForwardedHeaders() {
+		name = http.CanonicalHeaderKey(name)
+		if vals := h.r.Header.Values(name); len(vals) > 0 {
+			ci.Headers = append(ci.Headers, &servicepb.ClientInfo_Header{Name: name, Values: vals})
+		}
+	}
+	for _, name := range h.cfg.GetForwardedCookies() {
+		for _, c := range h.r.CookiesNamed(name) {
+			ci.Cookies = append(ci.Cookies, &servicepb.ClientInfo_Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+	return ci
+}
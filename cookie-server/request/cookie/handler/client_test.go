@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+
+	"github.com/hazaelsan/ssh-relay/cookie-server/proto/v1/configpb"
+	"github.com/hazaelsan/ssh-relay/cookie-server/proto/v1/servicepb"
+)
+
+func TestClientInfo(t *testing.T) {
+	leaf := &x509.Certificate{
+		Raw:            []byte{0x01},
+		Subject:        pkix.Name{CommonName: "foo"},
+		DNSNames:       []string{"foo.example.org"},
+		EmailAddresses: []string{"foo@example.org"},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/foo"}},
+		IPAddresses:    []net.IP{net.ParseIP("192.0.2.1")},
+	}
+	ca := &x509.Certificate{
+		Raw:     []byte{0x02},
+		Subject: pkix.Name{CommonName: "ca"},
+	}
+	testdata := []struct {
+		name string
+		cfg  *configpb.Config
+		tls  *tls.ConnectionState
+		want *servicepb.ClientInfo
+	}{
+		{
+			name: "no tls",
+			cfg:  &configpb.Config{},
+			want: &servicepb.ClientInfo{RemoteAddr: "192.0.2.1:1234"},
+		},
+		{
+			name: "unverified cert",
+			cfg:  &configpb.Config{},
+			tls:  &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}},
+			want: &servicepb.ClientInfo{RemoteAddr: "192.0.2.1:1234"},
+		},
+		{
+			name: "verified chain",
+			cfg:  &configpb.Config{},
+			tls: &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{leaf},
+				VerifiedChains:   [][]*x509.Certificate{{leaf, ca}},
+			},
+			want: &servicepb.ClientInfo{
+				RemoteAddr: "192.0.2.1:1234",
+				PeerCertificates: []*servicepb.ClientInfo_Certificate{
+					{
+						Der:            []byte{0x01},
+						Subject:        "CN=foo",
+						DnsNames:       []string{"foo.example.org"},
+						EmailAddresses: []string{"foo@example.org"},
+						Uris:           []string{"spiffe://example.org/foo"},
+						IpAddresses:    []string{"192.0.2.1"},
+					},
+					{
+						Der:     []byte{0x02},
+						Subject: "CN=ca",
+					},
+				},
+			},
+		},
+		{
+			name: "forwarded headers and cookies",
+			cfg: &configpb.Config{
+				ForwardedHeaders: []string{"x-forwarded-user", "X-Missing"},
+				ForwardedCookies: []string{"session"},
+			},
+			want: &servicepb.ClientInfo{
+				RemoteAddr: "192.0.2.1:1234",
+				Headers: []*servicepb.ClientInfo_Header{
+					{Name: "X-Forwarded-User", Values: []string{"foo"}},
+				},
+				Cookies: []*servicepb.ClientInfo_Cookie{
+					{Name: "session", Value: "bar"},
+				},
+			},
+		},
+	}
+	for _, tt := range testdata {
+		r := httptest.NewRequest("GET", "/cookie", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.TLS = tt.tls
+		r.Header.Set("X-Forwarded-User", "foo")
+		r.Header.Set("X-Other", "baz")
+		r.AddCookie(&http.Cookie{Name: "session", Value: "bar"})
+		r.AddCookie(&http.Cookie{Name: "other", Value: "baz"})
+		h := &Handler{cfg: tt.cfg, r: r}
+		if diff := pretty.Compare(h.clientInfo(), tt.want); diff != "" {
+			t.Errorf("clientInfo(%v) diff (-got +want):\n%v", tt.name, diff)
+		}
+	}
+}
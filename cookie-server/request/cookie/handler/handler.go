@@ -12,6 +12,8 @@ import (
 	"github.com/golang/glog"
 	"github.com/hazaelsan/ssh-relay/duration"
 	"github.com/hazaelsan/ssh-relay/response"
+	"github.com/hazaelsan/ssh-relay/token"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
 	"github.com/hazaelsan/ssh-relay/cookie-server/proto/v1/configpb"
@@ -39,13 +41,15 @@ var (
 )
 
 // New creates a *Handler for an HTTP request.
-func New(c servicepb.CookieServerClient, cfg *configpb.Config, req *requestpb.Request, w http.ResponseWriter, r *http.Request) (*Handler, error) {
+// If signer is not nil a signed auth token is set alongside the origin cookie.
+func New(c servicepb.CookieServerClient, signer *token.Signer, cfg *configpb.Config, req *requestpb.Request, w http.ResponseWriter, r *http.Request) (*Handler, error) {
 	h := &Handler{
-		c:   c,
-		cfg: cfg,
-		req: req,
-		w:   w,
-		r:   r,
+		c:      c,
+		signer: signer,
+		cfg:    cfg,
+		req:    req,
+		w:      w,
+		r:      r,
 	}
 	if err := duration.FromProto(&h.maxAge, h.cfg.OriginCookie.MaxAge); err != nil {
 		return nil, err
@@ -56,8 +60,10 @@ func New(c servicepb.CookieServerClient, cfg *configpb.Config, req *requestpb.Re
 // A Handler is an HTTP handler for /cookie requests.
 type Handler struct {
 	c      servicepb.CookieServerClient
+	signer *token.Signer
 	cfg    *configpb.Config
 	req    *requestpb.Request
+	resp   *servicepb.AuthorizeResponse
 	maxAge time.Duration
 	w      http.ResponseWriter
 	r      *http.Request
@@ -65,22 +71,37 @@ type Handler struct {
 
 // Handle processes the /cookie HTTP request, redirecting clients according to the configured method.
 func (h *Handler) Handle(ctx context.Context) error {
-	req := &servicepb.AuthorizeRequest{Request: h.req}
+	req := &servicepb.AuthorizeRequest{
+		Request:    h.req,
+		ClientInfo: h.clientInfo(),
+	}
+	start := time.Now()
 	resp, err := h.c.Authorize(ctx, req)
 	if err != nil {
+		h.observeAuthorize(start, status.Code(err))
+		h.fail(err)
 		return fmt.Errorf("Authorize(%v) error: %w", req, err)
 	}
 	if err := status.ErrorProto(resp.GetStatus()); err != nil {
+		h.observeAuthorize(start, status.Code(err))
+		h.fail(err)
 		return fmt.Errorf("Authorize(%v) error: %w", req, err)
 	}
+	h.observeAuthorize(start, codes.OK)
+	h.resp = resp
 	switch resp.GetRedirect().(type) {
 	case *servicepb.AuthorizeResponse_NextUri:
-		return h.redirectURI(resp.GetNextUri(), resp.GetMethod())
+		err = h.redirectURI(resp.GetNextUri(), resp.GetMethod())
 	case *servicepb.AuthorizeResponse_Endpoint:
-		return h.redirectEndpoint(resp.GetEndpoint(), resp.GetMethod())
+		err = h.redirectEndpoint(resp.GetEndpoint(), resp.GetMethod())
 	default:
-		return errNoRedirect
+		err = errNoRedirect
+	}
+	if errors.Is(err, errNoRedirect) || errors.Is(err, errBadMethod) {
+		// The backend response is unusable, nothing has been sent to the client yet.
+		h.err(http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
+	return err
 }
 
 // writeResponse sends a JSON response as a base64-encoded URI fragment as a JavaScript redirect.
@@ -101,7 +122,14 @@ func (h *Handler) writeResponse(r *response.Response) error {
 // err writes an error to the client, note that code is not used in JSON responses.
 func (h *Handler) err(msg string, code int) {
 	if h.req.GetVersion() == 2 {
-		if err := h.writeResponse(response.FromError(msg)); err == nil {
+		r := response.FromError(msg)
+		if h.req.GetMethod() == requestpb.RedirectionMethod_DIRECT {
+			if b, err := r.MarshalXSSI(); err == nil {
+				h.w.Header().Set("Content-Type", "application/json")
+				h.w.Write(b)
+				return
+			}
+		} else if err := h.writeResponse(r); err == nil {
 			return
 		}
 	}
@@ -110,26 +138,43 @@ func (h *Handler) err(msg string, code int) {
 }
 
 // cookie creates a cookie to send to a client.
-func (h *Handler) cookie(c *cookiepb.Cookie, val string) *http.Cookie {
+func (h *Handler) cookie(c *cookiepb.Cookie, val string, maxAge time.Duration) *http.Cookie {
 	return &http.Cookie{
 		Name:     c.Name,
 		Value:    val,
 		Path:     c.Path,
 		Domain:   c.Domain,
-		MaxAge:   int(h.maxAge.Seconds()),
+		MaxAge:   int(maxAge.Seconds()),
 		Secure:   true,
 		HttpOnly: true,
 		SameSite: http.SameSiteNoneMode,
 	}
 }
 
+// token mints a signed auth token for the client.
+func (h *Handler) token(origin string) (string, error) {
+	c := token.Claims{
+		Identity: h.resp.GetIdentity(),
+		Origin:   origin,
+	}
+	for _, d := range h.resp.GetAllowedDestinations() {
+		c.Destinations = append(c.Destinations, token.DestinationFromProto(d))
+	}
+	return h.signer.Sign(c)
+}
+
 // setCookies sets all requisite cookies for redirection to work.
-func (h *Handler) setCookies() {
-	for c, val := range map[*cookiepb.Cookie]string{
-		h.cfg.OriginCookie: extPrefix + h.req.GetExt(),
-	} {
-		http.SetCookie(h.w, h.cookie(c, val))
+func (h *Handler) setCookies() error {
+	origin := extPrefix + h.req.GetExt()
+	if h.signer != nil {
+		tok, err := h.token(origin)
+		if err != nil {
+			return fmt.Errorf("token() error: %w", err)
+		}
+		http.SetCookie(h.w, h.cookie(h.cfg.GetAuthToken().GetCookie(), tok, h.signer.MaxAge()))
 	}
+	http.SetCookie(h.w, h.cookie(h.cfg.OriginCookie, origin, h.maxAge))
+	return nil
 }
 
 // redirectURI redirects clients to a URI.
@@ -148,7 +193,10 @@ func (h *Handler) redirectURI(uri string, method requestpb.RedirectionMethod) er
 // redirectHTTP redirects clients by sending an HTTP redirect.
 func (h *Handler) redirectHTTP(uri string) error {
 	glog.V(4).Infof("Redirecting %v to %v", h.r.RemoteAddr, uri)
-	h.setCookies()
+	if err := h.setCookies(); err != nil {
+		h.err(http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return err
+	}
 	http.Redirect(h.w, h.r, uri, http.StatusSeeOther)
 	return nil
 }
@@ -169,7 +217,10 @@ func (h *Handler) redirectEndpoint(endpoint string, method requestpb.Redirection
 
 // redirectJS redirects clients via a JavaScript redirect with a base64-encoded JSON response embedded in the URI fragment.
 func (h *Handler) redirectJS(r *response.Response) error {
-	h.setCookies()
+	if err := h.setCookies(); err != nil {
+		h.err(http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return err
+	}
 	return h.writeResponse(r)
 }
 
@@ -180,7 +231,10 @@ func (h *Handler) redirectXSSI(r *response.Response) error {
 		h.err(http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return err
 	}
-	h.setCookies()
+	if err := h.setCookies(); err != nil {
+		h.err(http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return err
+	}
 	h.w.Header().Set("Content-Type", "application/json")
 	_, err = h.w.Write(b)
 	return err
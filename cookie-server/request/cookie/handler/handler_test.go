@@ -8,10 +8,14 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/hazaelsan/ssh-relay/response"
+	"github.com/hazaelsan/ssh-relay/token"
 	"github.com/kylelemons/godebug/pretty"
 	"google.golang.org/grpc"
 
@@ -19,6 +23,8 @@ import (
 	"github.com/hazaelsan/ssh-relay/cookie-server/proto/v1/requestpb"
 	"github.com/hazaelsan/ssh-relay/cookie-server/proto/v1/servicepb"
 	"github.com/hazaelsan/ssh-relay/proto/v1/cookiepb"
+	"github.com/hazaelsan/ssh-relay/proto/v1/destinationpb"
+	"github.com/hazaelsan/ssh-relay/proto/v1/tokenpb"
 	statuspb "google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/protobuf/types/known/durationpb"
 )
@@ -100,7 +106,7 @@ func TestNew(t *testing.T) {
 		},
 	}
 	for _, tt := range testdata {
-		h, err := New(nil, tt.cfg, nil, nil, nil)
+		h, err := New(nil, nil, tt.cfg, nil, nil, nil)
 		if err != nil {
 			if tt.ok {
 				t.Errorf("New(%v) error = %v", tt.name, err)
@@ -168,7 +174,7 @@ func TestWriteResponse(t *testing.T) {
 	}
 	for _, tt := range testdata {
 		cfg := &configpb.Config{OriginCookie: new(cookiepb.Cookie)}
-		h, err := New(nil, cfg, tt.req, tt.w, httptest.NewRequest("GET", "/foo", nil))
+		h, err := New(nil, nil, cfg, tt.req, tt.w, httptest.NewRequest("GET", "/foo", nil))
 		if err != nil {
 			t.Errorf("New(%v) error = %v", tt.name, err)
 			continue
@@ -245,7 +251,7 @@ func TestErr(t *testing.T) {
 			Version: tt.version,
 		}
 		cfg := &configpb.Config{OriginCookie: new(cookiepb.Cookie)}
-		h, err := New(nil, cfg, req, tt.w, httptest.NewRequest("GET", "/foo", nil))
+		h, err := New(nil, nil, cfg, req, tt.w, httptest.NewRequest("GET", "/foo", nil))
 		if err != nil {
 			t.Errorf("New(%v) error = %v", tt.name, err)
 			continue
@@ -290,16 +296,96 @@ func TestSetCookies(t *testing.T) {
 		},
 	}
 	req := &requestpb.Request{Ext: "foo"}
-	h, err := New(nil, cfg, req, w, httptest.NewRequest("GET", "/foo", nil))
+	h, err := New(nil, nil, cfg, req, w, httptest.NewRequest("GET", "/foo", nil))
 	if err != nil {
 		t.Fatalf("New() error = %v", err)
 	}
-	h.setCookies()
+	if err := h.setCookies(); err != nil {
+		t.Fatalf("setCookies() error = %v", err)
+	}
 	if diff := pretty.Compare(w.Result().Cookies(), want); diff != "" {
 		t.Errorf("setCookies() diff (-got +want):\n%v", diff)
 	}
 }
 
+func TestSetCookies_AuthToken(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte(strings.Repeat("s", 32)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	opts := &cookiepb.Cookie{
+		Name:   "token",
+		Path:   "/",
+		MaxAge: &durationpb.Duration{Seconds: 60},
+	}
+	tokenOpts := &tokenpb.TokenOptions{
+		Cookie: opts,
+		Keys: []*tokenpb.TokenOptions_Key{
+			{
+				Id:  "key",
+				Key: &tokenpb.TokenOptions_Key_HmacSecretFile{HmacSecretFile: secret},
+			},
+		},
+		SigningKeyId: "key",
+	}
+	signer, err := token.NewSigner(tokenOpts)
+	if err != nil {
+		t.Fatalf("token.NewSigner() error = %v", err)
+	}
+	verifier, err := token.NewVerifier(tokenOpts)
+	if err != nil {
+		t.Fatalf("token.NewVerifier() error = %v", err)
+	}
+	w := httptest.NewRecorder()
+	cfg := &configpb.Config{
+		OriginCookie: &cookiepb.Cookie{
+			Name:   "cookie",
+			Path:   "/",
+			MaxAge: &durationpb.Duration{Seconds: 3},
+		},
+		AuthToken: tokenOpts,
+	}
+	req := &requestpb.Request{Ext: "foo"}
+	h, err := New(nil, signer, cfg, req, w, httptest.NewRequest("GET", "/foo", nil))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	h.resp = &servicepb.AuthorizeResponse{
+		Identity: "foo@example.org",
+		AllowedDestinations: []*destinationpb.DestinationMatcher{
+			{Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "*.example.org"}},
+		},
+	}
+	if err := h.setCookies(); err != nil {
+		t.Fatalf("setCookies() error = %v", err)
+	}
+	var tok *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "token" {
+			tok = c
+		}
+	}
+	if tok == nil {
+		t.Fatal("setCookies() did not set the token cookie")
+	}
+	if tok.MaxAge != 60 {
+		t.Errorf("setCookies() token MaxAge = %v, want 60", tok.MaxAge)
+	}
+	got, err := verifier.Verify(tok.Value)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	want := &token.Claims{
+		Identity:     "foo@example.org",
+		Origin:       "chrome-extension://foo",
+		Expiry:       got.Expiry,
+		Destinations: []token.Destination{{HostGlob: "*.example.org"}},
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("setCookies() token claims diff (-got +want):\n%v", diff)
+	}
+}
+
 func TestRedirectHTTP(t *testing.T) {
 	uri := "chrome-extension://foo/bar#anonymous@relay.example.org:8022"
 	wantLocation := []string{"chrome-extension://foo/bar#anonymous@relay.example.org:8022"}
@@ -330,7 +416,7 @@ func TestRedirectHTTP(t *testing.T) {
 		Ext:  "foo",
 		Path: "bar",
 	}
-	h, err := New(nil, cfg, req, w, httptest.NewRequest("GET", "/foo", nil))
+	h, err := New(nil, nil, cfg, req, w, httptest.NewRequest("GET", "/foo", nil))
 	if err != nil {
 		t.Fatalf("New() error = %v", err)
 	}
@@ -377,7 +463,7 @@ func TestRedirectJS(t *testing.T) {
 		Ext:  "foo",
 		Path: "path",
 	}
-	h, err := New(nil, cfg, req, w, httptest.NewRequest("GET", "/foo", nil))
+	h, err := New(nil, nil, cfg, req, w, httptest.NewRequest("GET", "/foo", nil))
 	if err != nil {
 		t.Fatalf("New() error = %v", err)
 	}
@@ -426,7 +512,7 @@ func TestRedirectXSSI(t *testing.T) {
 		Ext:  "foo",
 		Path: "path",
 	}
-	h, err := New(nil, cfg, req, w, httptest.NewRequest("GET", "/foo", nil))
+	h, err := New(nil, nil, cfg, req, w, httptest.NewRequest("GET", "/foo", nil))
 	if err != nil {
 		t.Fatalf("New() error = %v", err)
 	}
@@ -521,7 +607,7 @@ func TestHandle(t *testing.T) {
 			Path:   "path",
 			Method: tt.method,
 		}
-		h, err := New(tt.s, cfg, req, w, httptest.NewRequest("GET", "/foo", nil))
+		h, err := New(tt.s, nil, cfg, req, w, httptest.NewRequest("GET", "/foo", nil))
 		if err != nil {
 			t.Errorf("New(%v) error = %v", tt.name, err)
 			continue
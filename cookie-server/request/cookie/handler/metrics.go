@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/hazaelsan/ssh-relay/metrics"
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	authorizeDurations = metrics.NewHistogramVec("cookie_server_authorize_duration_seconds", "Latency of Authorize calls to the gRPC backend.", metrics.DefBuckets, "method", "version")
+	authorizeResults   = metrics.NewCounterVec("cookie_server_authorize_requests_total", "Authorize results by gRPC status code.", "method", "version", "code")
+)
+
+// observeAuthorize records the latency and result of an Authorize call.
+func (h *Handler) observeAuthorize(start time.Time, c codes.Code) {
+	// Version 1 requests don't set the version.
+	v := max(h.req.GetVersion(), 1)
+	method := h.req.GetMethod().String()
+	version := strconv.Itoa(int(v))
+	authorizeDurations.With(method, version).Observe(time.Since(start).Seconds())
+	authorizeResults.With(method, version, c.String()).Inc()
+}
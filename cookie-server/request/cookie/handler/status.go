@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusClientClosedRequest is the non-standard HTTP status code for requests canceled by clients.
+const statusClientClosedRequest = 499
+
+// httpStatus maps a gRPC status code to an HTTP status code.
+func httpStatus(c codes.Code) int {
+	switch c {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return statusClientClosedRequest
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// userMessage returns the client-visible message for a gRPC status.
+// Backends set it via a google.rpc.LocalizedMessage detail, and may attach a google.rpc.Help detail with a help URL.
+// The status message itself is never sent to clients as it may contain internal details.
+func userMessage(s *status.Status, code int) string {
+	msg := http.StatusText(code)
+	if code == statusClientClosedRequest {
+		msg = "Client Closed Request"
+	}
+	var url string
+	for _, d := range s.Details() {
+		switch d := d.(type) {
+		case *errdetails.LocalizedMessage:
+			if d.GetMessage() != "" {
+				msg = d.GetMessage()
+			}
+		case *errdetails.Help:
+			if links := d.GetLinks(); len(links) > 0 && url == "" {
+				url = links[0].GetUrl()
+			}
+		}
+	}
+	if url != "" {
+		return fmt.Sprintf("%v (see %v)", msg, url)
+	}
+	return msg
+}
+
+// fail sends an error from the gRPC backend to the client.
+func (h *Handler) fail(err error) {
+	s := status.Convert(err)
+	code := httpStatus(s.Code())
+	h.err(userMessage(s, code), code)
+}
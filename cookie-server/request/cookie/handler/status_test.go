@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/hazaelsan/ssh-relay/cookie-server/proto/v1/configpb"
+	"github.com/hazaelsan/ssh-relay/cookie-server/proto/v1/requestpb"
+	"github.com/hazaelsan/ssh-relay/proto/v1/cookiepb"
+)
+
+func withDetails(t *testing.T, s *status.Status, details ...*errdetails.LocalizedMessage) *status.Status {
+	t.Helper()
+	for _, d := range details {
+		var err error
+		if s, err = s.WithDetails(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestHTTPStatus(t *testing.T) {
+	testdata := map[codes.Code]int{
+		codes.OK:                http.StatusOK,
+		codes.Canceled:          statusClientClosedRequest,
+		codes.Unknown:           http.StatusInternalServerError,
+		codes.InvalidArgument:   http.StatusBadRequest,
+		codes.DeadlineExceeded:  http.StatusGatewayTimeout,
+		codes.NotFound:          http.StatusNotFound,
+		codes.AlreadyExists:     http.StatusConflict,
+		codes.PermissionDenied:  http.StatusForbidden,
+		codes.ResourceExhausted: http.StatusTooManyRequests,
+		codes.Unauthenticated:   http.StatusUnauthorized,
+		codes.Unimplemented:     http.StatusNotImplemented,
+		codes.Internal:          http.StatusInternalServerError,
+		codes.Unavailable:       http.StatusServiceUnavailable,
+		codes.DataLoss:          http.StatusInternalServerError,
+	}
+	for c, want := range testdata {
+		if got := httpStatus(c); got != want {
+			t.Errorf("httpStatus(%v) = %v, want %v", c, got, want)
+		}
+	}
+}
+
+func TestUserMessage(t *testing.T) {
+	help, err := status.New(codes.PermissionDenied, "internal details").WithDetails(
+		&errdetails.LocalizedMessage{Locale: "en-US", Message: "Access denied"},
+		&errdetails.Help{Links: []*errdetails.Help_Link{{Url: "https://example.org/help"}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testdata := []struct {
+		name string
+		s    *status.Status
+		want string
+	}{
+		{
+			name: "no details",
+			s:    status.New(codes.PermissionDenied, "internal details"),
+			want: "Forbidden",
+		},
+		{
+			name: "localized message",
+			s:    withDetails(t, status.New(codes.PermissionDenied, "internal details"), &errdetails.LocalizedMessage{Message: "Access denied"}),
+			want: "Access denied",
+		},
+		{
+			name: "help url",
+			s:    help,
+			want: "Access denied (see https://example.org/help)",
+		},
+	}
+	for _, tt := range testdata {
+		if got := userMessage(tt.s, httpStatus(tt.s.Code())); got != tt.want {
+			t.Errorf("userMessage(%v) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHandle_Errors(t *testing.T) {
+	denied := withDetails(t, status.New(codes.PermissionDenied, "internal details"), &errdetails.LocalizedMessage{Message: "Access denied"})
+	testdata := []struct {
+		name     string
+		s        *authServer
+		req      *requestpb.Request
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "v1 permission denied",
+			s:        &authServer{err: denied.Err()},
+			req:      &requestpb.Request{Ext: "foo", Path: "path", Method: requestpb.RedirectionMethod_HTTP_REDIRECT},
+			wantCode: http.StatusForbidden,
+			wantBody: "Access denied\n",
+		},
+		{
+			name:     "v1 unavailable",
+			s:        &authServer{err: status.Error(codes.Unavailable, "backend down")},
+			req:      &requestpb.Request{Ext: "foo", Path: "path", Method: requestpb.RedirectionMethod_HTTP_REDIRECT},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: "Service Unavailable\n",
+		},
+		{
+			name:     "v1 status unauthenticated",
+			s:        &authServer{status: status.New(codes.Unauthenticated, "no cert").Proto()},
+			req:      &requestpb.Request{Ext: "foo", Path: "path", Method: requestpb.RedirectionMethod_HTTP_REDIRECT},
+			wantCode: http.StatusUnauthorized,
+			wantBody: "Unauthorized\n",
+		},
+		{
+			name:     "v1 non-grpc error",
+			s:        &authServer{err: errors.New("auth error")},
+			req:      &requestpb.Request{Ext: "foo", Path: "path", Method: requestpb.RedirectionMethod_HTTP_REDIRECT},
+			wantCode: http.StatusInternalServerError,
+			wantBody: "Internal Server Error\n",
+		},
+		{
+			name:     "v1 no redirect",
+			s:        new(authServer),
+			req:      &requestpb.Request{Ext: "foo", Path: "path", Method: requestpb.RedirectionMethod_HTTP_REDIRECT},
+			wantCode: http.StatusInternalServerError,
+			wantBody: "Internal Server Error\n",
+		},
+		{
+			name:     "v2 direct",
+			s:        &authServer{err: denied.Err()},
+			req:      &requestpb.Request{Ext: "foo", Path: "path", Version: 2, Method: requestpb.RedirectionMethod_DIRECT},
+			wantCode: http.StatusOK,
+			wantBody: ")]}'\n" + `{"endpoint":"","error":"Access denied"}`,
+		},
+		{
+			name:     "v2 js redirect",
+			s:        &authServer{err: denied.Err()},
+			req:      &requestpb.Request{Ext: "foo", Path: "path", Version: 2, Method: requestpb.RedirectionMethod_JS_REDIRECT},
+			wantCode: http.StatusOK,
+			wantBody: jsRedir(`{"endpoint":"","error":"Access denied"}`),
+		},
+	}
+	for _, tt := range testdata {
+		w := httptest.NewRecorder()
+		cfg := &configpb.Config{OriginCookie: new(cookiepb.Cookie)}
+		h, err := New(tt.s, nil, cfg, tt.req, w, httptest.NewRequest("GET", "/foo", nil))
+		if err != nil {
+			t.Errorf("New(%v) error = %v", tt.name, err)
+			continue
+		}
+		if err := h.Handle(context.Background()); err == nil {
+			t.Errorf("Handle(%v) error = nil", tt.name)
+		}
+		resp := w.Result()
+		if resp.StatusCode != tt.wantCode {
+			t.Errorf("Handle(%v) code = %v, want %v", tt.name, resp.StatusCode, tt.wantCode)
+		}
+		if len(resp.Cookies()) > 0 {
+			t.Errorf("Handle(%v) set cookies on error: %v", tt.name, resp.Cookies())
+		}
+		got, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("io.ReadAll(%v) error = %v", tt.name, err)
+			continue
+		}
+		if diff := pretty.Compare(string(got), tt.wantBody); diff != "" {
+			t.Errorf("Handle(%v) body diff (-got +want):\n%v", tt.name, diff)
+		}
+	}
+}
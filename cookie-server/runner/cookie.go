@@ -16,7 +16,8 @@ func (r *Runner) handleCookie(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	h, err := handler.New(r.c, r.cfg, cr, w, req)
+	st := r.current()
+	h, err := handler.New(r.c, st.signer, st.cfg, cr, w, req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -0,0 +1,50 @@
+package runner
+
+import (
+	"fmt"
+
+	"github.com/hazaelsan/ssh-relay/reload"
+	"github.com/hazaelsan/ssh-relay/token"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/hazaelsan/ssh-relay/cookie-server/proto/v1/configpb"
+)
+
+// restartFields are the config fields that only take effect after a restart.
+var restartFields = []protoreflect.Name{"server_options", "grpc_options", "metrics_options"}
+
+// settings is the reloadable state of a Runner, derived from its config.
+type settings struct {
+	cfg    *configpb.Config
+	signer *token.Signer
+}
+
+// newSettings validates a config and creates the state derived from it.
+func newSettings(cfg *configpb.Config) (*settings, error) {
+	s := &settings{cfg: cfg}
+	if cfg.GetAuthToken() != nil {
+		var err error
+		if s.signer, err = token.NewSigner(cfg.GetAuthToken()); err != nil {
+			return nil, fmt.Errorf("token.NewSigner() error: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// current returns the current settings.
+func (r *Runner) current() *settings {
+	return r.settings.Load()
+}
+
+// Reload validates a new config and applies it atomically, new requests use it right away.
+// Changes to the listener and gRPC backend settings need a restart.
+// The current config is kept on error.
+func (r *Runner) Reload(cfg *configpb.Config) error {
+	s, err := newSettings(cfg)
+	if err != nil {
+		return err
+	}
+	old := r.settings.Swap(s)
+	reload.LogRestartFields(old.cfg, cfg, restartFields...)
+	return nil
+}
@@ -3,9 +3,11 @@ package runner
 import (
 	"fmt"
 	"net"
+	"sync/atomic"
 
 	"github.com/golang/glog"
 	"github.com/hazaelsan/ssh-relay/http"
+	"github.com/hazaelsan/ssh-relay/metrics"
 	"github.com/hazaelsan/ssh-relay/tls"
 	"google.golang.org/grpc"
 
@@ -19,25 +21,37 @@ func New(cfg *configpb.Config) (*Runner, error) {
 	if err != nil {
 		return nil, err
 	}
+	st, err := newSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
 	r := &Runner{
-		cfg:    cfg,
 		server: s,
 	}
+	r.settings.Store(st)
+	if cfg.GetMetricsOptions() != nil {
+		if r.metrics, err = http.NewServer(cfg.GetMetricsOptions()); err != nil {
+			return nil, fmt.Errorf("http.NewServer() error: %w", err)
+		}
+		r.metrics.HandleFunc("/metrics", metrics.DefaultRegistry.ServeHTTP)
+	}
 	s.HandleFunc("/cookie", r.handleCookie)
 	return r, nil
 }
 
 // Runner is the main runner loop.
 type Runner struct {
-	cfg    *configpb.Config
-	server *http.Server
-	c      servicepb.CookieServerClient
+	settings atomic.Pointer[settings]
+	server   *http.Server
+	metrics  *http.Server
+	c        servicepb.CookieServerClient
 }
 
 // Run executes the main runner loop.
 func (r *Runner) Run() error {
-	addr := net.JoinHostPort(r.cfg.GetGrpcOptions().GetAddr(), r.cfg.GetGrpcOptions().GetPort())
-	creds, err := tls.TransportCreds(r.cfg.GetGrpcOptions().GetTlsConfig())
+	opts := r.current().cfg.GetGrpcOptions()
+	addr := net.JoinHostPort(opts.GetAddr(), opts.GetPort())
+	creds, err := tls.TransportCreds(opts.GetTlsConfig())
 	if err != nil {
 		return err
 	}
@@ -48,5 +62,15 @@ func (r *Runner) Run() error {
 	}
 	defer conn.Close()
 	r.c = servicepb.NewCookieServerClient(conn)
-	return r.server.Run()
+	if r.metrics == nil {
+		return r.server.Run()
+	}
+	errc := make(chan error, 2)
+	go func() {
+		errc <- r.metrics.Run()
+	}()
+	go func() {
+		errc <- r.server.Run()
+	}()
+	return <-errc
 }
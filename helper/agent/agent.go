@@ -6,48 +6,96 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
+	"github.com/hazaelsan/ssh-relay/duration"
 	"github.com/hazaelsan/ssh-relay/helper/session"
 	"github.com/hazaelsan/ssh-relay/helper/session/cookie"
 	"github.com/hazaelsan/ssh-relay/helper/session/corprelay"
 	"github.com/hazaelsan/ssh-relay/helper/session/corprelayv4"
 	rhttp "github.com/hazaelsan/ssh-relay/http"
+	rsession "github.com/hazaelsan/ssh-relay/session"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	"github.com/hazaelsan/ssh-relay/helper/proto/v1/configpb"
 	"github.com/hazaelsan/ssh-relay/proto/v1/protocolversionpb"
 )
 
+// reconnectOptions builds session.ReconnectOptions from a proto config message.
+func reconnectOptions(cfg *configpb.Config_ReconnectOptions) (session.ReconnectOptions, error) {
+	opts := session.ReconnectOptions{
+		Multiplier: cfg.GetBackoffMultiplier(),
+	}
+	if opts.Multiplier != 0 && opts.Multiplier < 1 {
+		return opts, fmt.Errorf("backoff_multiplier %v < 1", opts.Multiplier)
+	}
+	for dst, src := range map[*time.Duration]*durationpb.Duration{
+		&opts.MaxOutage:      cfg.GetMaxOutage(),
+		&opts.InitialBackoff: cfg.GetInitialBackoff(),
+		&opts.MaxBackoff:     cfg.GetMaxBackoff(),
+	} {
+		if err := duration.FromProto(dst, src); err != nil {
+			return opts, fmt.Errorf("duration.FromProto(%v, %v) error: %w", dst, src, err)
+		}
+	}
+	return opts, nil
+}
+
 // New creates an *Agent.
 func New(cfg *configpb.Config) (*Agent, error) {
 	c, err := rhttp.NewClient(cfg.CookieServerTransport)
 	if err != nil {
 		return nil, fmt.Errorf("rhttp.NewClient() error: %w", err)
 	}
+	ro, err := reconnectOptions(cfg.GetReconnectOptions())
+	if err != nil {
+		return nil, fmt.Errorf("reconnectOptions() error: %w", err)
+	}
+	ka, err := rsession.NewKeepalive(cfg.GetWebsocketOptions())
+	if err != nil {
+		return nil, fmt.Errorf("rsession.NewKeepalive() error: %w", err)
+	}
 	return &Agent{
-		cfg:    cfg,
-		client: c,
+		cfg:       cfg,
+		client:    c,
+		reconnect: ro,
+		keepalive: ka,
 	}, nil
 }
 
 // An Agent authenticates against the Cookie Server and sets up an SSH-over-WebSocket session.
 type Agent struct {
-	cfg    *configpb.Config
-	client *http.Client
+	cfg       *configpb.Config
+	client    *http.Client
+	reconnect session.ReconnectOptions
+	keepalive rsession.Keepalive
+}
+
+// authenticate authenticates against the Cookie Server, returns the relay address and cookies to use.
+func (a *Agent) authenticate() (string, []*http.Cookie, error) {
+	relay, cookies, err := cookie.Authenticate(a.cfg.CookieServerAddress, a.client)
+	if err != nil {
+		return "", nil, fmt.Errorf("cookie.Authenticate(%v) error: %w", a.cfg.CookieServerAddress, err)
+	}
+	return relay, cookies, nil
 }
 
 // Run authenticates against the Cookie Server and starts the SSH-over-WebSocket session.
 func (a *Agent) Run() error {
-	relay, cookies, err := cookie.Authenticate(a.cfg.CookieServerAddress, a.client)
+	relay, cookies, err := a.authenticate()
 	if err != nil {
-		return fmt.Errorf("cookie.Authenticate(%v) error: %w", a.cfg.CookieServerAddress, err)
+		return err
 	}
 	opts := session.Options{
-		Relay:     relay,
-		Host:      a.cfg.Host,
-		Port:      a.cfg.Port,
-		Origin:    fmt.Sprintf("chrome-extension://%v", session.ExtID),
-		Cookies:   cookies,
-		Transport: a.cfg.SshRelayTransport,
+		Relay:        relay,
+		Host:         a.cfg.Host,
+		Port:         a.cfg.Port,
+		Origin:       fmt.Sprintf("chrome-extension://%v", session.ExtID),
+		Cookies:      cookies,
+		Transport:    a.cfg.SshRelayTransport,
+		Authenticate: a.authenticate,
+		Reconnect:    a.reconnect,
+		Keepalive:    a.keepalive,
 	}
 	var s session.Session
 	switch a.cfg.GetProtocolVersion() {
@@ -31,9 +31,11 @@ import (
 func New(opts hsession.Options) *Session {
 	ssh := hsession.NewWrapper(os.Stdin, os.Stdout)
 	insecure := opts.Transport.GetTlsConfig().GetTlsMode() == tlspb.TlsConfig_TLS_MODE_DISABLED
+	cs := corprelay.New(ssh)
+	cs.SetKeepalive(opts.Keepalive)
 	return &Session{
 		opts:     opts,
-		s:        corprelay.New(ssh),
+		s:        cs,
 		insecure: insecure,
 	}
 }
@@ -144,11 +146,14 @@ func (s *Session) dial(proxyURL string) error {
 	}
 	connectURL := s.connectURL()
 	glog.V(2).Infof("Copying I/O via %v", connectURL)
-	tlsCfg, err := tls.Config(s.opts.Transport.TlsConfig)
+	r, err := tls.NewReloader(s.opts.Transport.TlsConfig)
 	if err != nil {
-		return fmt.Errorf("tls.Config() error: %w", err)
+		return fmt.Errorf("tls.NewReloader() error: %w", err)
+	}
+	d := new(websocket.Dialer)
+	if r != nil {
+		d.TLSClientConfig = r.ClientConfig()
 	}
-	d := &websocket.Dialer{TLSClientConfig: tlsCfg}
 	s.ws, _, err = d.Dial(connectURL, s.connectHeader())
 	if err != nil {
 		return fmt.Errorf("Dial(%v) error: %w", connectURL, err)
@@ -1,15 +1,20 @@
 // Package corprelayv4 implements a corp-relay-v4@google.com SSH-over-WebSocket Relay client session.
 //
-// NOTE: Reconnections are not implemented.
+// Sessions are resumed via /v4/reconnect if the connection to the relay is lost,
+// see hsession.ReconnectOptions.
 package corprelayv4
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	hsession "github.com/hazaelsan/ssh-relay/helper/session"
 	"github.com/hazaelsan/ssh-relay/session"
@@ -23,26 +28,105 @@ import (
 // Communication to ssh(1) is done via stdin/stdout.
 func New(opts hsession.Options) *Session {
 	ssh := hsession.NewWrapper(os.Stdin, os.Stdout)
+	cs := corprelayv4.New(ssh, session.Client)
+	cs.SetKeepalive(opts.Keepalive)
 	return &Session{
 		opts: opts,
-		s:    corprelayv4.New(ssh, session.Client),
+		s:    cs,
 	}
 }
 
 // A Session is a corp-relay-v4@google.com SSH-over-WebSocket Relay client session.
 type Session struct {
-	opts hsession.Options
-	s    session.Session
-	ws   *websocket.Conn
+	opts   hsession.Options
+	s      *corprelayv4.Session
+	ws     *websocket.Conn
+	dialer *websocket.Dialer
 }
 
 // Run copies I/O to an SSH host through a WebSocket Relay via /v4/connect.
+// The session is resumed via /v4/reconnect if the WebSocket is disconnected.
 func (s *Session) Run() error {
 	u := s.connectURL()
-	if err := s.dial(u); err != nil {
+	if _, err := s.dial(u); err != nil {
 		return fmt.Errorf("dial(%v) error: %w", u, err)
 	}
-	return s.s.Run(s.ws)
+	err := s.s.Run(s.ws)
+	for errors.Is(err, session.ErrDisconnected) && s.opts.Reconnect.Enabled() {
+		glog.Warningf("%v: %v, reconnecting", s.s, err)
+		s.ws.Close()
+		if rErr := s.reconnect(); rErr != nil {
+			s.s.Close()
+			return fmt.Errorf("reconnect() error: %w", rErr)
+		}
+		glog.V(1).Infof("%v: Session resumed", s.s)
+		err = s.s.Resume(s.ws, 0)
+	}
+	return err
+}
+
+// reconnect dials /v4/reconnect until it succeeds or the maximum outage window elapses.
+// If the relay rejects the client as unauthorized, cookies are refreshed once via opts.Authenticate;
+// other client errors (e.g., the relay no longer has the session) are final, while timeouts,
+// rate limiting and server errors (e.g., while the relay is draining) are retried.
+func (s *Session) reconnect() error {
+	if s.s.SID() == uuid.Nil {
+		return errors.New("session was never established")
+	}
+	deadline := time.Now().Add(s.opts.Reconnect.MaxOutage)
+	authenticated := false
+	for n := 0; ; n++ {
+		d := s.opts.Reconnect.Backoff(n)
+		if time.Now().Add(d).After(deadline) {
+			return fmt.Errorf("gave up after %v attempts", n)
+		}
+		select {
+		case <-time.After(d):
+		case <-s.s.Done():
+			return errors.New("session closed")
+		}
+		u := s.reconnectURL()
+		resp, err := s.dial(u)
+		if err == nil {
+			return nil
+		}
+		glog.Warningf("%v: dial(%v) error: %v", s.s, u, err)
+		if resp == nil {
+			// Transient network error, retry.
+			continue
+		}
+		switch code := resp.StatusCode; {
+		case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		case code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500:
+			// Transient relay error, retry.
+			continue
+		default:
+			return fmt.Errorf("relay rejected reconnection: %v", resp.Status)
+		}
+		// The relay rejected the client, cookies may have expired.
+		if authenticated || s.opts.Authenticate == nil {
+			return fmt.Errorf("relay rejected reconnection: %v", resp.Status)
+		}
+		if err := s.authenticate(); err != nil {
+			glog.Warningf("%v: %v", s.s, err)
+			continue
+		}
+		authenticated = true
+	}
+}
+
+// authenticate refreshes the cookies to present to the relay.
+func (s *Session) authenticate() error {
+	relay, cookies, err := s.opts.Authenticate()
+	if err != nil {
+		return fmt.Errorf("Authenticate() error: %w", err)
+	}
+	if relay != s.opts.Relay {
+		// Session state lives in the original relay.
+		glog.Warningf("%v: Ignoring new relay %v, session is bound to %v", s.s, relay, s.opts.Relay)
+	}
+	s.opts.Cookies = cookies
+	return nil
 }
 
 // Done notifies when the Session has terminated.
@@ -60,35 +144,56 @@ func (s *Session) connectHeader() http.Header {
 	return h
 }
 
-// connectURL builds the correct URL for /v4/connect requests.
-func (s *Session) connectURL() string {
+// relayURL builds a URL for requests to the relay.
+func (s *Session) relayURL(path string, q url.Values) string {
 	scheme := "wss"
 	if s.opts.Transport.GetTlsConfig().GetTlsMode() == tlspb.TlsConfig_TLS_MODE_DISABLED {
 		scheme = "ws"
 	}
 	u := url.URL{
-		Scheme: scheme,
-		Host:   s.opts.Relay,
-		Path:   "/v4/connect",
+		Scheme:   scheme,
+		Host:     s.opts.Relay,
+		Path:     path,
+		RawQuery: q.Encode(),
 	}
-	q := u.Query()
+	return u.String()
+}
+
+// connectURL builds the correct URL for /v4/connect requests.
+func (s *Session) connectURL() string {
+	q := url.Values{}
 	q.Set("host", s.opts.Host)
 	q.Set("port", s.opts.Port)
-	u.RawQuery = q.Encode()
-	return u.String()
+	return s.relayURL("/v4/connect", q)
+}
+
+// reconnectURL builds the correct URL for /v4/reconnect requests.
+func (s *Session) reconnectURL() string {
+	q := url.Values{}
+	q.Set("sid", s.s.SID().String())
+	q.Set("ack", strconv.FormatUint(s.s.Ack(), 10))
+	return s.relayURL("/v4/reconnect", q)
 }
 
 // dial initiates the SSH session and sets up the WebSocket for I/O.
-func (s *Session) dial(u string) error {
+// The returned *http.Response is non-nil if the relay rejected the WebSocket handshake.
+func (s *Session) dial(u string) (*http.Response, error) {
 	glog.V(2).Infof("Copying I/O via %v", u)
-	tlsCfg, err := tls.Config(s.opts.Transport.GetTlsConfig())
-	if err != nil {
-		return fmt.Errorf("tls.Config() error: %w", err)
+	if s.dialer == nil {
+		// Reconnections share the dialer so certificates and root CAs are reloaded as they change.
+		r, err := tls.NewReloader(s.opts.Transport.GetTlsConfig())
+		if err != nil {
+			return nil, fmt.Errorf("tls.NewReloader() error: %w", err)
+		}
+		s.dialer = new(websocket.Dialer)
+		if r != nil {
+			s.dialer.TLSClientConfig = r.ClientConfig()
+		}
 	}
-	d := &websocket.Dialer{TLSClientConfig: tlsCfg}
-	s.ws, _, err = d.Dial(u, s.connectHeader())
+	ws, resp, err := s.dialer.Dial(u, s.connectHeader())
 	if err != nil {
-		return fmt.Errorf("Dial(%v) error: %w", u, err)
+		return resp, fmt.Errorf("Dial(%v) error: %w", u, err)
 	}
-	return nil
+	s.ws = ws
+	return nil, nil
 }
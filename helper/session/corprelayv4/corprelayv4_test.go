@@ -0,0 +1,160 @@
+package corprelayv4
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	hsession "github.com/hazaelsan/ssh-relay/helper/session"
+	"github.com/hazaelsan/ssh-relay/session"
+	"github.com/hazaelsan/ssh-relay/session/corprelayv4"
+	"github.com/hazaelsan/ssh-relay/session/corprelayv4/command"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/httppb"
+	"github.com/hazaelsan/ssh-relay/proto/v1/tlspb"
+)
+
+// fakeRelay is a relay that establishes sessions, then answers /v4/reconnect with the given status codes.
+// Reconnections are accepted once they run out.
+type fakeRelay struct {
+	mu       sync.Mutex
+	statuses []int
+	attempts int
+}
+
+func (f *fakeRelay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v4/reconnect" {
+		f.mu.Lock()
+		f.attempts++
+		var code int
+		if len(f.statuses) > 0 {
+			code, f.statuses = f.statuses[0], f.statuses[1:]
+		}
+		f.mu.Unlock()
+		if code != 0 {
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+	}
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+	if r.URL.Path != "/v4/connect" {
+		return
+	}
+	// Establish the session, then drop the connection.
+	cs, err := command.NewConnectSuccess([]byte(uuid.New().String()))
+	if err != nil {
+		return
+	}
+	wr, err := ws.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return
+	}
+	cs.Write(wr)
+	wr.Close()
+}
+
+func TestReconnect(t *testing.T) {
+	testdata := []struct {
+		name         string
+		statuses     []int
+		authenticate bool
+		attempts     int
+		ok           bool
+	}{
+		{
+			name:     "draining",
+			statuses: []int{http.StatusServiceUnavailable},
+			attempts: 2,
+			ok:       true,
+		},
+		{
+			name:     "rate limited",
+			statuses: []int{http.StatusTooManyRequests, http.StatusRequestTimeout},
+			attempts: 3,
+			ok:       true,
+		},
+		{
+			name:     "no such session",
+			statuses: []int{http.StatusBadRequest},
+			attempts: 1,
+		},
+		{
+			name:         "not found",
+			statuses:     []int{http.StatusNotFound},
+			authenticate: true,
+			attempts:     1,
+		},
+		{
+			name:     "unauthorized",
+			statuses: []int{http.StatusUnauthorized},
+			attempts: 1,
+		},
+		{
+			name:         "re-authenticated",
+			statuses:     []int{http.StatusForbidden},
+			authenticate: true,
+			attempts:     2,
+			ok:           true,
+		},
+		{
+			name:         "still unauthorized after re-authenticating",
+			statuses:     []int{http.StatusForbidden, http.StatusForbidden},
+			authenticate: true,
+			attempts:     2,
+		},
+	}
+	for _, tt := range testdata {
+		relay := &fakeRelay{statuses: tt.statuses}
+		srv := httptest.NewServer(relay)
+		opts := hsession.Options{
+			Relay: strings.TrimPrefix(srv.URL, "http://"),
+			Transport: &httppb.HttpTransport{
+				TlsConfig: &tlspb.TlsConfig{TlsMode: tlspb.TlsConfig_TLS_MODE_DISABLED},
+			},
+			Reconnect: hsession.ReconnectOptions{
+				MaxOutage:      time.Second,
+				InitialBackoff: time.Millisecond,
+				MaxBackoff:     time.Millisecond,
+			},
+		}
+		if tt.authenticate {
+			opts.Authenticate = func() (string, []*http.Cookie, error) {
+				return opts.Relay, nil, nil
+			}
+		}
+		ssh, _ := net.Pipe()
+		s := &Session{opts: opts, s: corprelayv4.New(ssh, session.Client)}
+		if _, err := s.dial(s.connectURL()); err != nil {
+			t.Fatalf("dial(%v) error = %v", tt.name, err)
+		}
+		if err := s.s.Run(s.ws); !errors.Is(err, session.ErrDisconnected) {
+			t.Fatalf("Run(%v) error = %v, want %v", tt.name, err, session.ErrDisconnected)
+		}
+		err := s.reconnect()
+		if err != nil && tt.ok {
+			t.Errorf("reconnect(%v) error = %v", tt.name, err)
+		}
+		if err == nil && !tt.ok {
+			t.Errorf("reconnect(%v) error = nil", tt.name)
+		}
+		if relay.attempts != tt.attempts {
+			t.Errorf("reconnect(%v) attempts = %v, want %v", tt.name, relay.attempts, tt.attempts)
+		}
+		s.s.Close()
+		srv.Close()
+	}
+}
@@ -0,0 +1,59 @@
+package session
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// DefaultInitialBackoff is the default delay before the first reconnection attempt.
+	DefaultInitialBackoff = time.Second
+
+	// DefaultMaxBackoff is the default maximum delay between reconnection attempts.
+	DefaultMaxBackoff = 30 * time.Second
+
+	// DefaultBackoffMultiplier is the default factor by which the delay grows after each failed attempt.
+	DefaultBackoffMultiplier = 2
+)
+
+// ReconnectOptions specifies how to resume a Session after losing the connection to the relay.
+type ReconnectOptions struct {
+	// MaxOutage is the maximum amount of time to spend trying to resume a Session.
+	// Sessions are not resumed if MaxOutage <= 0.
+	MaxOutage time.Duration
+
+	// InitialBackoff is the delay before the first reconnection attempt.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the maximum delay between reconnection attempts.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor by which the delay grows after each failed attempt.
+	Multiplier float64
+}
+
+// Enabled returns whether Sessions should be resumed.
+func (o ReconnectOptions) Enabled() bool {
+	return o.MaxOutage > 0
+}
+
+// Backoff returns the delay before the nth reconnection attempt, starting at 0.
+func (o ReconnectOptions) Backoff(n int) time.Duration {
+	d := o.InitialBackoff
+	if d <= 0 {
+		d = DefaultInitialBackoff
+	}
+	maxBackoff := o.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+	m := o.Multiplier
+	if m < 1 {
+		m = DefaultBackoffMultiplier
+	}
+	b := float64(d) * math.Pow(m, float64(n))
+	if b >= float64(maxBackoff) {
+		return maxBackoff
+	}
+	return time.Duration(b)
+}
@@ -0,0 +1,58 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	testdata := []struct {
+		opts ReconnectOptions
+		n    int
+		want time.Duration
+	}{
+		{
+			n:    0,
+			want: DefaultInitialBackoff,
+		},
+		{
+			n:    2,
+			want: 4 * DefaultInitialBackoff,
+		},
+		{
+			n:    100,
+			want: DefaultMaxBackoff,
+		},
+		{
+			opts: ReconnectOptions{
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     time.Second,
+				Multiplier:     1.5,
+			},
+			n:    2,
+			want: 225 * time.Millisecond,
+		},
+		{
+			opts: ReconnectOptions{
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     time.Second,
+				Multiplier:     3,
+			},
+			n:    3,
+			want: time.Second,
+		},
+		{
+			opts: ReconnectOptions{
+				InitialBackoff: 100 * time.Millisecond,
+				Multiplier:     1,
+			},
+			n:    10,
+			want: 100 * time.Millisecond,
+		},
+	}
+	for _, tt := range testdata {
+		if got := tt.opts.Backoff(tt.n); got != tt.want {
+			t.Errorf("Backoff(%+v, %v) = %v, want %v", tt.opts, tt.n, got, tt.want)
+		}
+	}
+}
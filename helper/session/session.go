@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"net/url"
 
+	rsession "github.com/hazaelsan/ssh-relay/session"
+
 	"github.com/hazaelsan/ssh-relay/proto/v1/httppb"
 )
 
@@ -50,6 +52,17 @@ type Options struct {
 
 	// Transport specifies settings for creating HTTP/WebSocket connections.
 	Transport *httppb.HttpTransport
+
+	// Authenticate re-authenticates against the Cookie Server,
+	// returning the relay address and the cookies to use for subsequent requests.
+	// Used to refresh Cookies when resuming a Session, may be nil.
+	Authenticate func() (string, []*http.Cookie, error)
+
+	// Reconnect specifies how to resume a Session after losing the connection to the relay.
+	Reconnect ReconnectOptions
+
+	// Keepalive specifies how to detect a relay that stops responding over the WebSocket.
+	Keepalive rsession.Keepalive
 }
 
 // A Session is an SSH-over-WebSocket Relay client session.
@@ -31,11 +31,13 @@ func NewClient(cfg *httppb.HttpTransport) (*http.Client, error) {
 	}
 
 	if cfg.GetTlsConfig().GetTlsMode() != tlspb.TlsConfig_TLS_MODE_DISABLED {
-		tlsCfg, err := tls.CertConfig(cfg.TlsConfig)
+		r, err := tls.NewReloader(cfg.TlsConfig)
 		if err != nil {
-			return nil, fmt.Errorf("tls.CertConfig() error: %w", err)
+			return nil, fmt.Errorf("tls.NewReloader() error: %w", err)
 		}
-		t.TLSClientConfig = tlsCfg
+		t.TLSClientConfig = r.ClientConfig()
+		// A custom TLSClientConfig disables HTTP/2 unless explicitly requested.
+		t.ForceAttemptHTTP2 = true
 	}
 	return &http.Client{Transport: t}, nil
 }
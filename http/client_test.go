@@ -37,9 +37,11 @@ func TestNewClient(t *testing.T) {
 			want: &http.Client{
 				Transport: &http.Transport{
 					TLSClientConfig: &tls.Config{
-						ClientAuth: tls.RequestClientCert,
-						MinVersion: tls.VersionTLS12,
+						ClientAuth:         tls.RequestClientCert,
+						MinVersion:         tls.VersionTLS12,
+						InsecureSkipVerify: true,
 					},
+					ForceAttemptHTTP2:      true,
 					MaxResponseHeaderBytes: 5,
 					ResponseHeaderTimeout:  3 * time.Second,
 				},
@@ -107,20 +109,28 @@ func TestNewClient(t *testing.T) {
 		}
 		transport.TLSClientConfig.RootCAs = nil
 
+		tlsCert, err := transport.TLSClientConfig.GetClientCertificate(nil)
+		if err != nil {
+			t.Errorf("GetClientCertificate(%v) error = %v", tt.name, err)
+			continue
+		}
 		var subjects []string
-		for j, tlsCert := range transport.TLSClientConfig.Certificates {
-			for k, tc := range tlsCert.Certificate {
-				cert, err := x509.ParseCertificate(tc)
-				if err != nil {
-					t.Errorf("ParseCertificate(%v, %v, %v) error = %v", tt.name, j, k, err)
-				}
-				subjects = append(subjects, cert.Subject.String())
+		for k, tc := range tlsCert.Certificate {
+			cert, err := x509.ParseCertificate(tc)
+			if err != nil {
+				t.Errorf("ParseCertificate(%v, %v) error = %v", tt.name, k, err)
 			}
+			subjects = append(subjects, cert.Subject.String())
 		}
 		if diff := pretty.Compare(subjects, tt.rootCNs); diff != "" {
 			t.Errorf("subjects(%v) diff (-got +want):\n%v", tt.name, diff)
 		}
-		transport.TLSClientConfig.Certificates = nil
+		if transport.TLSClientConfig.VerifyConnection == nil {
+			t.Errorf("NewClient(%v) VerifyConnection = nil", tt.name)
+		}
+		transport.TLSClientConfig.GetCertificate = nil
+		transport.TLSClientConfig.GetClientCertificate = nil
+		transport.TLSClientConfig.VerifyConnection = nil
 
 		if diff := pretty.Compare(got, tt.want); diff != "" {
 			t.Errorf("NewClient(%v) diff (-got +want):\n%v", tt.name, diff)
@@ -0,0 +1,68 @@
+package http
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/httppb"
+)
+
+// cidrs is a set of network prefixes.
+type cidrs []netip.Prefix
+
+// parseCIDRs parses a list of CIDRs, e.g., "10.0.0.0/8".
+func parseCIDRs(ss []string) (cidrs, error) {
+	var c cidrs
+	for _, s := range ss {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadCIDR, err)
+		}
+		c = append(c, p.Masked())
+	}
+	return c, nil
+}
+
+// contains returns whether an address is in any of the prefixes.
+func (c cidrs) contains(a netip.Addr) bool {
+	a = a.Unmap()
+	for _, p := range c {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// newClientFilter creates a *clientFilter from a proto message, nil if unset.
+func newClientFilter(pb *httppb.HttpServerOptions_ClientFilter) (*clientFilter, error) {
+	if pb == nil {
+		return nil, nil
+	}
+	allow, err := parseCIDRs(pb.GetAllowCidrs())
+	if err != nil {
+		return nil, fmt.Errorf("allow_cidrs: %w", err)
+	}
+	deny, err := parseCIDRs(pb.GetDenyCidrs())
+	if err != nil {
+		return nil, fmt.Errorf("deny_cidrs: %w", err)
+	}
+	return &clientFilter{allow: allow, deny: deny}, nil
+}
+
+// clientFilter decides which client addresses may send requests.
+type clientFilter struct {
+	allow cidrs
+	deny  cidrs
+}
+
+// allowed returns whether a client address may send requests, unknown addresses are only allowed without allow_cidrs.
+func (f *clientFilter) allowed(a netip.Addr) bool {
+	if !a.IsValid() {
+		return len(f.allow) == 0
+	}
+	if f.deny.contains(a) {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow.contains(a)
+}
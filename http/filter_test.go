@@ -0,0 +1,50 @@
+package http
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/httppb"
+)
+
+func TestClientFilter(t *testing.T) {
+	f, err := newClientFilter(&httppb.HttpServerOptions_ClientFilter{
+		AllowCidrs: []string{"10.0.0.0/8", "2001:db8::/32"},
+		DenyCidrs:  []string{"10.1.0.0/16"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testdata := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "10.0.0.1", want: true},
+		{addr: "::ffff:10.0.0.1", want: true},
+		{addr: "2001:db8::1", want: true},
+		{addr: "10.1.0.1"},
+		{addr: "192.0.2.1"},
+		{},
+	}
+	for _, tt := range testdata {
+		a, _ := netip.ParseAddr(tt.addr)
+		if got := f.allowed(a); got != tt.want {
+			t.Errorf("allowed(%v) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestNewClientFilter(t *testing.T) {
+	if f, err := newClientFilter(nil); f != nil || err != nil {
+		t.Errorf("newClientFilter(nil) = %v, %v, want nil, nil", f, err)
+	}
+	for _, pb := range []*httppb.HttpServerOptions_ClientFilter{
+		{AllowCidrs: []string{"10.0.0.0"}},
+		{DenyCidrs: []string{"10.0.0.0/33"}},
+	} {
+		if _, err := newClientFilter(pb); !errors.Is(err, ErrBadCIDR) {
+			t.Errorf("newClientFilter(%v) error = %v, want %v", pb, err, ErrBadCIDR)
+		}
+	}
+}
@@ -13,4 +13,13 @@ var (
 
 	// ErrNoTLSConfig is returned if tls_config is not specified.
 	ErrNoTLSConfig = errors.New("tls_config must be specified")
+
+	// ErrBadCIDR is returned when a CIDR is invalid.
+	ErrBadCIDR = errors.New("bad CIDR")
+
+	// ErrNoTrustedProxies is returned if trusted_proxies is set without any CIDRs.
+	ErrNoTrustedProxies = errors.New("trusted_proxies.cidrs must be specified")
+
+	// ErrForbidden is returned to clients rejected by the client filter.
+	ErrForbidden = errors.New("forbidden")
 )
@@ -0,0 +1,202 @@
+package http
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hazaelsan/ssh-relay/proxyproto"
+)
+
+// defaultProxyHeaderTimeout is the timeout for reading PROXY protocol headers if no read_header_timeout is set.
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+// newProxyListener creates a *proxyListener accepting connections from l.
+func newProxyListener(l net.Listener, trusted cidrs, timeout time.Duration) *proxyListener {
+	p := &proxyListener{
+		Listener: l,
+		trusted:  trusted,
+		timeout:  timeout,
+		conns:    make(chan net.Conn),
+		errc:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// proxyListener is a net.Listener reading PROXY protocol headers from connections made by trusted proxies.
+// Headers are read asynchronously, a slow proxy doesn't hold up other connections.
+type proxyListener struct {
+	net.Listener
+	trusted cidrs
+	timeout time.Duration
+	conns   chan net.Conn
+	errc    chan error
+	done    chan struct{}
+	once    sync.Once
+}
+
+// run accepts connections until the listener is closed.
+func (p *proxyListener) run() {
+	for {
+		conn, err := p.Listener.Accept()
+		if err != nil {
+			select {
+			case p.errc <- err:
+			case <-p.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go p.handshake(conn)
+	}
+}
+
+// handshake reads the PROXY protocol header from a connection if it comes from a trusted proxy.
+func (p *proxyListener) handshake(conn net.Conn) {
+	if ap, err := netip.ParseAddrPort(conn.RemoteAddr().String()); err == nil && p.trusted.contains(ap.Addr()) {
+		conn.SetReadDeadline(time.Now().Add(p.timeout))
+		h, err := proxyproto.Read(conn)
+		if err != nil {
+			glog.Warningf("Rejected connection from %v: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+		conn = newProxyConn(conn, h)
+	}
+	select {
+	case p.conns <- conn:
+	case <-p.done:
+		conn.Close()
+	}
+}
+
+// Accept returns the next connection, after its PROXY protocol header (if any) has been read.
+func (p *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-p.conns:
+		return conn, nil
+	case err := <-p.errc:
+		return nil, err
+	case <-p.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener.
+func (p *proxyListener) Close() error {
+	var err error
+	p.once.Do(func() {
+		close(p.done)
+		err = p.Listener.Close()
+	})
+	return err
+}
+
+// newProxyConn wraps a connection with the addresses from a PROXY protocol header.
+func newProxyConn(conn net.Conn, h *proxyproto.Header) net.Conn {
+	if !h.Source.IsValid() {
+		// LOCAL or unknown connection, keep the proxy's addresses.
+		return conn
+	}
+	return &proxyConn{
+		Conn:   conn,
+		remote: net.TCPAddrFromAddrPort(h.Source),
+		local:  net.TCPAddrFromAddrPort(h.Destination),
+	}
+}
+
+// proxyConn is a net.Conn reporting the addresses from a PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// forwardedFor returns the client addresses in a request's Forwarded or X-Forwarded-For headers,
+// from the farthest to the nearest hop; unparseable addresses are returned as invalid.
+func forwardedFor(req *http.Request) []netip.AddrPort {
+	var hops []netip.AddrPort
+	if fwd := req.Header.Values("Forwarded"); len(fwd) > 0 {
+		for _, elem := range strings.Split(strings.Join(fwd, ","), ",") {
+			var hop netip.AddrPort
+			for _, pair := range strings.Split(elem, ";") {
+				k, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(k, "for") {
+					hop = parseHop(strings.Trim(v, `"`))
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+	for _, xff := range req.Header.Values("X-Forwarded-For") {
+		for _, s := range strings.Split(xff, ",") {
+			hops = append(hops, parseHop(strings.TrimSpace(s)))
+		}
+	}
+	return hops
+}
+
+// parseHop parses an address as found in forwarding headers, e.g., "192.0.2.1", "[2001:db8::1]:4711".
+func parseHop(s string) netip.AddrPort {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap
+	}
+	a, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	return netip.AddrPortFrom(a, 0)
+}
+
+// clientAddr returns the client address for a request, walking the forwarding headers
+// from the nearest hop as long as the current hop is a trusted proxy.
+func (s *Server) clientAddr(req *http.Request) netip.AddrPort {
+	addr, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil || !s.forwardedHeaders {
+		return addr
+	}
+	hops := forwardedFor(req)
+	for i := len(hops) - 1; i >= 0 && s.trusted.contains(addr.Addr()); i-- {
+		if !hops[i].IsValid() {
+			// Obfuscated or unknown hop, the client can't be identified past the last trusted proxy.
+			break
+		}
+		addr = hops[i]
+	}
+	return addr
+}
+
+// serveHTTP rewrites the client address and enforces the client filter before calling the handlers.
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	addr := s.clientAddr(req)
+	if addr.IsValid() && addr.String() != req.RemoteAddr {
+		glog.V(4).Infof("Client address for %v is %v", req.RemoteAddr, addr)
+		req.RemoteAddr = addr.String()
+	}
+	if s.filter != nil && !s.filter.allowed(addr.Addr()) {
+		glog.Warningf("Rejected request from %v: client address not allowed", req.RemoteAddr)
+		http.Error(w, ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	s.mux.ServeHTTP(w, req)
+}
@@ -0,0 +1,186 @@
+package http
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/ssh-relay/proxyproto"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/httppb"
+	"github.com/hazaelsan/ssh-relay/proto/v1/tlspb"
+)
+
+func newTrustedServer(t *testing.T, filter *httppb.HttpServerOptions_ClientFilter) *Server {
+	t.Helper()
+	s, err := NewServer(&httppb.HttpServerOptions{
+		Port:      "0",
+		TlsConfig: &tlspb.TlsConfig{TlsMode: tlspb.TlsConfig_TLS_MODE_DISABLED},
+		TrustedProxies: &httppb.HttpServerOptions_TrustedProxies{
+			Cidrs:            []string{"10.0.0.0/8", "2001:db8:ffff::/48"},
+			ForwardedHeaders: true,
+		},
+		ClientFilter: filter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestClientAddr(t *testing.T) {
+	testdata := []struct {
+		name   string
+		remote string
+		xff    string
+		fwd    string
+		want   string
+	}{
+		{
+			name:   "direct",
+			remote: "192.0.2.1:40000",
+			want:   "192.0.2.1:40000",
+		},
+		{
+			name:   "untrusted peer",
+			remote: "192.0.2.1:40000",
+			xff:    "198.51.100.1",
+			want:   "192.0.2.1:40000",
+		},
+		{
+			name:   "x-forwarded-for",
+			remote: "10.0.0.1:40000",
+			xff:    "192.0.2.1",
+			want:   "192.0.2.1:0",
+		},
+		{
+			name:   "proxy chain",
+			remote: "10.0.0.1:40000",
+			xff:    "192.0.2.1, 10.1.1.1",
+			want:   "192.0.2.1:0",
+		},
+		{
+			name:   "spoofed hop",
+			remote: "10.0.0.1:40000",
+			xff:    "203.0.113.1, 192.0.2.1",
+			want:   "192.0.2.1:0",
+		},
+		{
+			name:   "forwarded",
+			remote: "[2001:db8:ffff::1]:40000",
+			fwd:    `for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`,
+			xff:    "203.0.113.1",
+			want:   "[2001:db8::1]:4711",
+		},
+		{
+			name:   "obfuscated hop",
+			remote: "10.0.0.1:40000",
+			fwd:    "for=_hidden, for=10.0.0.2",
+			want:   "10.0.0.2:0",
+		},
+	}
+	s := newTrustedServer(t, nil)
+	for _, tt := range testdata {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.fwd != "" {
+			req.Header.Set("Forwarded", tt.fwd)
+		}
+		if got := s.clientAddr(req).String(); got != tt.want {
+			t.Errorf("clientAddr(%v) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	s := newTrustedServer(t, &httppb.HttpServerOptions_ClientFilter{DenyCidrs: []string{"192.0.2.0/24"}})
+	var got string
+	s.HandleFunc("/", func(_ http.ResponseWriter, req *http.Request) {
+		got = req.RemoteAddr
+	})
+	testdata := []struct {
+		xff      string
+		want     string
+		wantCode int
+	}{
+		{
+			xff:      "198.51.100.1",
+			want:     "198.51.100.1:0",
+			wantCode: http.StatusOK,
+		},
+		{
+			xff:      "192.0.2.1",
+			wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range testdata {
+		got = ""
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:40000"
+		req.Header.Set("X-Forwarded-For", tt.xff)
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, req)
+		if code := w.Result().StatusCode; code != tt.wantCode {
+			t.Errorf("ServeHTTP(%v) status code = %v, want %v", tt.xff, code, tt.wantCode)
+		}
+		if got != tt.want {
+			t.Errorf("ServeHTTP(%v) client address = %q, want %q", tt.xff, got, tt.want)
+		}
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted, err := parseCIDRs([]string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newProxyListener(l, trusted, time.Second)
+	defer p.Close()
+	h, err := (&proxyproto.Header{
+		Source:      netip.MustParseAddrPort("192.0.2.1:40000"),
+		Destination: netip.MustParseAddrPort("198.51.100.1:443"),
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(b []byte) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write(b)
+		conn.Close()
+	}
+	// Connections with a bad header are dropped.
+	send([]byte("GET / HTTP/1.1\r\n\r\n"))
+	send(append(h, "hello"...))
+	conn, err := p.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer conn.Close()
+	if got, want := conn.RemoteAddr().String(), "192.0.2.1:40000"; got != want {
+		t.Errorf("RemoteAddr() = %v, want %v", got, want)
+	}
+	if got, want := conn.LocalAddr().String(), "198.51.100.1:443"; got != want {
+		t.Errorf("LocalAddr() = %v, want %v", got, want)
+	}
+	if b, err := io.ReadAll(conn); err != nil || string(b) != "hello" {
+		t.Errorf("ReadAll() = %q, %v, want %q", b, err, "hello")
+	}
+	p.Close()
+	if _, err := p.Accept(); err == nil {
+		t.Errorf("Accept() after Close() error = nil")
+	}
+}
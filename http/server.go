@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -46,9 +47,13 @@ func NewServer(cfg *httppb.HttpServerOptions) (*Server, error) {
 	if cfg.TlsConfig == nil {
 		return nil, ErrNoTLSConfig
 	}
-	tlsConfig, err := tls.Config(cfg.TlsConfig)
+	tlsConfig, err := tls.CertConfig(cfg.TlsConfig)
 	if err != nil {
-		return nil, fmt.Errorf("tls.Config() error: %w", err)
+		return nil, fmt.Errorf("tls.CertConfig() error: %w", err)
+	}
+	if tlsConfig != nil && len(tlsConfig.NextProtos) == 0 {
+		// Per-connection configs are derived from tlsConfig, set the protocols ServeTLS would otherwise set on its own copy.
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
 	}
 	mux := http.NewServeMux()
 	server := &http.Server{
@@ -75,6 +80,22 @@ func NewServer(cfg *httppb.HttpServerOptions) (*Server, error) {
 	if err := duration.FromProto(&s.hstsMaxAge, cfg.HstsMaxAge); err != nil {
 		return nil, err
 	}
+	if tp := cfg.GetTrustedProxies(); tp != nil {
+		if len(tp.GetCidrs()) == 0 {
+			return nil, ErrNoTrustedProxies
+		}
+		if s.trusted, err = parseCIDRs(tp.GetCidrs()); err != nil {
+			return nil, fmt.Errorf("trusted_proxies: %w", err)
+		}
+		s.proxyProtocol = tp.GetProxyProtocol()
+		s.forwardedHeaders = tp.GetForwardedHeaders()
+	}
+	if s.filter, err = newClientFilter(cfg.GetClientFilter()); err != nil {
+		return nil, fmt.Errorf("client_filter: %w", err)
+	}
+	if s.forwardedHeaders || s.filter != nil {
+		server.Handler = http.HandlerFunc(s.serveHTTP)
+	}
 	return s, nil
 }
 
@@ -84,6 +105,11 @@ type Server struct {
 	server     *http.Server
 	mux        *http.ServeMux
 	hstsMaxAge time.Duration
+
+	trusted          cidrs
+	proxyProtocol    bool
+	forwardedHeaders bool
+	filter           *clientFilter
 }
 
 // HandleFunc registers the handler function for the given pattern:
@@ -98,12 +124,30 @@ func (s *Server) HandleFunc(pattern string, handler HandlerFunc) {
 	glog.V(1).Infof("Registered handler for %v", pattern)
 }
 
-// Run starts the HTTP(S) server.
+// Shutdown gracefully stops the HTTP(S) server, see https://pkg.go.dev/net/http#Server.Shutdown.
+// Hijacked connections (e.g., WebSockets) are not affected.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Run starts the HTTP(S) server, returns http.ErrServerClosed after Shutdown.
 func (s *Server) Run() error {
+	l, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	if s.proxyProtocol {
+		timeout := s.server.ReadHeaderTimeout
+		if timeout <= 0 {
+			timeout = defaultProxyHeaderTimeout
+		}
+		l = newProxyListener(l, s.trusted, timeout)
+	}
 	if s.cfg.TlsConfig.GetTlsMode() == tlspb.TlsConfig_TLS_MODE_DISABLED {
 		glog.V(1).Infof("HTTP server listening on %v", s.server.Addr)
-		return s.server.ListenAndServe()
+		return s.server.Serve(l)
 	}
 	glog.V(1).Infof("HTTPS server listening on %v", s.server.Addr)
-	return s.server.ListenAndServeTLS(s.cfg.TlsConfig.CertFile, s.cfg.TlsConfig.KeyFile)
+	// The certificate is loaded (and reloaded) via TLSConfig.GetCertificate.
+	return s.server.ServeTLS(l, "", "")
 }
@@ -106,6 +106,7 @@ func TestNewServer(t *testing.T) {
 				TLSConfig: &tls.Config{
 					ClientAuth: tls.RequestClientCert,
 					MinVersion: tls.VersionTLS12,
+					NextProtos: []string{"h2", "http/1.1"},
 				},
 			},
 			hstsMaxAge: 3 * time.Second,
@@ -125,6 +126,7 @@ func TestNewServer(t *testing.T) {
 				TLSConfig: &tls.Config{
 					ClientAuth: tls.RequireAndVerifyClientCert,
 					MinVersion: tls.VersionTLS12,
+					NextProtos: []string{"h2", "http/1.1"},
 				},
 				Handler: http.NewServeMux(),
 			},
@@ -187,6 +189,33 @@ func TestNewServer(t *testing.T) {
 				ReadHeaderTimeout: -1 * time.Second,
 			},
 		},
+		{
+			name: "trusted proxies without cidrs",
+			cfg: &httppb.HttpServerOptions{
+				Port:           "8022",
+				TlsConfig:      &tlspb.TlsConfig{TlsMode: tlspb.TlsConfig_TLS_MODE_DISABLED},
+				TrustedProxies: &httppb.HttpServerOptions_TrustedProxies{ProxyProtocol: true},
+			},
+		},
+		{
+			name: "bad trusted proxy cidr",
+			cfg: &httppb.HttpServerOptions{
+				Port:      "8022",
+				TlsConfig: &tlspb.TlsConfig{TlsMode: tlspb.TlsConfig_TLS_MODE_DISABLED},
+				TrustedProxies: &httppb.HttpServerOptions_TrustedProxies{
+					Cidrs:         []string{"invalid"},
+					ProxyProtocol: true,
+				},
+			},
+		},
+		{
+			name: "bad client filter",
+			cfg: &httppb.HttpServerOptions{
+				Port:         "8022",
+				TlsConfig:    &tlspb.TlsConfig{TlsMode: tlspb.TlsConfig_TLS_MODE_DISABLED},
+				ClientFilter: &httppb.HttpServerOptions_ClientFilter{AllowCidrs: []string{"invalid"}},
+			},
+		},
 		{
 			name: "bad hstsMaxAge",
 			cfg: &httppb.HttpServerOptions{
@@ -217,6 +246,13 @@ func TestNewServer(t *testing.T) {
 			t.Errorf("NewServer(%v) error = nil", tt.name)
 		}
 
+		if c := got.server.TLSConfig; c != nil {
+			if c.GetCertificate == nil {
+				t.Errorf("NewServer(%v) GetCertificate = nil", tt.name)
+			}
+			c.GetCertificate = nil
+			c.GetClientCertificate = nil
+		}
 		if got.hstsMaxAge != tt.hstsMaxAge {
 			t.Errorf("hstsMaxAge(%v) = %v, want %v", tt.name, got.hstsMaxAge, tt.hstsMaxAge)
 		}
@@ -0,0 +1,40 @@
+// Package metrics defines Prometheus metrics via client_golang, exported by a Registry.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// DefaultRegistry holds the metrics created via the package-level constructors, along with Go runtime and
+	// process metrics.
+	DefaultRegistry = NewRegistry(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	// DefBuckets are the default histogram buckets, in seconds, suitable for request latencies.
+	DefBuckets = prometheus.DefBuckets
+)
+
+// NewRegistry instantiates a *Registry with the given collectors.
+func NewRegistry(cs ...prometheus.Collector) *Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(cs...)
+	return &Registry{
+		reg:     reg,
+		handler: promhttp.HandlerFor(reg, promhttp.HandlerOpts{}),
+	}
+}
+
+// A Registry is a set of metrics exported together.
+type Registry struct {
+	reg     *prometheus.Registry
+	handler http.Handler
+}
+
+// ServeHTTP exports all metrics, typically under /metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.handler.ServeHTTP(w, req)
+}
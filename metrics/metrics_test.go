@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_requests_total", "Total requests.", "code")
+	c.With("200").Add(3)
+	c.With("500").Inc()
+	g := r.NewGaugeVec("test_sessions", "Active sessions,\nby protocol.", "protocol")
+	g.With(`a"b\c`).Inc()
+	g.With(`a"b\c`).Inc()
+	g.With(`a"b\c`).Dec()
+	h := r.NewHistogramVec("test_latency_seconds", "Request latency.", []float64{1, 0.1})
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		h.With().Observe(v)
+	}
+	want := strings.Join([]string{
+		"# HELP test_latency_seconds Request latency.",
+		"# TYPE test_latency_seconds histogram",
+		`test_latency_seconds_bucket{le="0.1"} 2`,
+		`test_latency_seconds_bucket{le="1"} 3`,
+		`test_latency_seconds_bucket{le="+Inf"} 4`,
+		"test_latency_seconds_sum 2.65",
+		"test_latency_seconds_count 4",
+		"# HELP test_requests_total Total requests.",
+		"# TYPE test_requests_total counter",
+		`test_requests_total{code="200"} 3`,
+		`test_requests_total{code="500"} 1`,
+		"# HELP test_sessions Active sessions,\\nby protocol.",
+		"# TYPE test_sessions gauge",
+		`test_sessions{protocol="a\"b\\c"} 1`,
+		"",
+	}, "\n")
+	if err := testutil.GatherAndCompare(r.reg, strings.NewReader(want)); err != nil {
+		t.Errorf("GatherAndCompare() error = %v", err)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeVec("test_gauge", "A gauge.").With().Set(math.Inf(1))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if got := w.Result().Header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("ServeHTTP() Content-Type = %v, want text/plain", got)
+	}
+	if got, want := w.Body.String(), "# HELP test_gauge A gauge.\n# TYPE test_gauge gauge\ntest_gauge +Inf\n"; got != want {
+		t.Errorf("ServeHTTP() = %q, want %q", got, want)
+	}
+}
+
+func TestDefaultRegistry(t *testing.T) {
+	// Go runtime and process metrics are exported along with the relay's.
+	n, err := testutil.GatherAndCount(DefaultRegistry.reg, "go_goroutines")
+	if err != nil || n != 1 {
+		t.Errorf("GatherAndCount(go_goroutines) = %v, %v, want 1", n, err)
+	}
+}
+
+func TestRegister_Duplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "A counter.")
+	defer func() {
+		if recover() == nil {
+			t.Error("NewCounterVec() did not panic on a duplicate name")
+		}
+	}()
+	r.NewCounterVec("test_total", "A counter.")
+}
+
+func TestWith_BadLabels(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "A counter.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("With() did not panic on a bad number of label values")
+		}
+	}()
+	c.With("a")
+}
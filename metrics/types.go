@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type (
+	// A Counter is a monotonically increasing value.
+	Counter = prometheus.Counter
+
+	// A Gauge is a value that can go up and down.
+	Gauge = prometheus.Gauge
+
+	// An Observer adds observations to a Histogram.
+	Observer = prometheus.Observer
+)
+
+// A CounterVec is a set of Counters partitioned by label values.
+type CounterVec struct {
+	v *prometheus.CounterVec
+}
+
+// NewCounterVec creates a *CounterVec in r, registering the same name twice panics.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{promauto.With(r.reg).NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)}
+}
+
+// NewCounterVec creates a *CounterVec in the DefaultRegistry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return DefaultRegistry.NewCounterVec(name, help, labels...)
+}
+
+// With returns the Counter for the given label values, in the same order as the labels.
+func (v *CounterVec) With(values ...string) Counter {
+	return v.v.WithLabelValues(values...)
+}
+
+// A GaugeVec is a set of Gauges partitioned by label values.
+type GaugeVec struct {
+	v *prometheus.GaugeVec
+}
+
+// NewGaugeVec creates a *GaugeVec in r, registering the same name twice panics.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{promauto.With(r.reg).NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels)}
+}
+
+// NewGaugeVec creates a *GaugeVec in the DefaultRegistry.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return DefaultRegistry.NewGaugeVec(name, help, labels...)
+}
+
+// With returns the Gauge for the given label values, in the same order as the labels.
+func (v *GaugeVec) With(values ...string) Gauge {
+	return v.v.WithLabelValues(values...)
+}
+
+// A HistogramVec is a set of Histograms partitioned by label values.
+type HistogramVec struct {
+	v *prometheus.HistogramVec
+}
+
+// NewHistogramVec creates a *HistogramVec in r, buckets are the (sorted) upper bounds, +Inf is implicit.
+// Registering the same name twice panics.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	opts := prometheus.HistogramOpts{Name: name, Help: help, Buckets: slices.Sorted(slices.Values(buckets))}
+	return &HistogramVec{promauto.With(r.reg).NewHistogramVec(opts, labels)}
+}
+
+// NewHistogramVec creates a *HistogramVec in the DefaultRegistry.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return DefaultRegistry.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// With returns the Histogram for the given label values, in the same order as the labels.
+func (v *HistogramVec) With(values ...string) Observer {
+	return v.v.WithLabelValues(values...)
+}
@@ -0,0 +1,232 @@
+// Package proxyproto implements the HAProxy PROXY protocol,
+// see https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt.
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// TLV types, see section 2.2 of the specification.
+const (
+	// TypeUniqueID is a unique identifier for the connection, up to 128 bytes.
+	TypeUniqueID = 0x05
+
+	// TypeIdentity carries the authenticated client identity.
+	// Types 0xE0 to 0xEF are reserved for custom use.
+	TypeIdentity = 0xE0
+)
+
+const (
+	// maxUniqueIDLength is the maximum length of a TypeUniqueID value.
+	maxUniqueIDLength = 128
+
+	// maxV1Length is the maximum length of a version 1 header, including the CRLF.
+	maxV1Length = 107
+
+	versionCommandProxy = 0x21
+	familyUnspec        = 0x00
+	familyTCP4          = 0x11
+	familyTCP6          = 0x21
+	familyUDP4          = 0x12
+	familyUDP6          = 0x22
+)
+
+// signature is the PROXY protocol v2 header signature.
+var signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1Prefix is the PROXY protocol v1 header prefix.
+var v1Prefix = []byte("PROXY ")
+
+var (
+	// ErrTooLong is returned when a header doesn't fit in the PROXY protocol length limits.
+	ErrTooLong = errors.New("PROXY header too long")
+
+	// ErrBadHeader is returned when a PROXY protocol header is malformed.
+	ErrBadHeader = errors.New("bad PROXY header")
+)
+
+// A TLV is a Type-Length-Value vector carrying additional information about a connection.
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// A Header is a PROXY protocol header.
+// If either address is invalid the addresses are left unspecified (AF_UNSPEC),
+// this is also the case for LOCAL connections (e.g., health checks) read from a proxy.
+type Header struct {
+	// Source is the original client address.
+	Source netip.AddrPort
+
+	// Destination is the address the client originally connected to.
+	Destination netip.AddrPort
+
+	// TLVs are additional vectors sent along with the addresses.
+	TLVs []TLV
+}
+
+// Marshal encodes a Header in the PROXY protocol v2 binary format.
+func (h *Header) Marshal() ([]byte, error) {
+	src, dst := h.Source, h.Destination
+	family := byte(familyUnspec)
+	var addrs []byte
+	if src.IsValid() && dst.IsValid() {
+		src = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+		dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+		if src.Addr().Is4() && dst.Addr().Is4() {
+			family = familyTCP4
+		} else {
+			// Mixed address families are sent as IPv6.
+			family = familyTCP6
+			src = netip.AddrPortFrom(netip.AddrFrom16(src.Addr().As16()), src.Port())
+			dst = netip.AddrPortFrom(netip.AddrFrom16(dst.Addr().As16()), dst.Port())
+		}
+		addrs = append(addrs, src.Addr().AsSlice()...)
+		addrs = append(addrs, dst.Addr().AsSlice()...)
+		addrs = binary.BigEndian.AppendUint16(addrs, src.Port())
+		addrs = binary.BigEndian.AppendUint16(addrs, dst.Port())
+	}
+	for _, t := range h.TLVs {
+		if len(t.Value) > math.MaxUint16 || (t.Type == TypeUniqueID && len(t.Value) > maxUniqueIDLength) {
+			return nil, fmt.Errorf("%w: TLV %#x is %v bytes", ErrTooLong, t.Type, len(t.Value))
+		}
+		addrs = append(addrs, t.Type)
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(len(t.Value)))
+		addrs = append(addrs, t.Value...)
+	}
+	if len(addrs) > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: %v bytes", ErrTooLong, len(addrs))
+	}
+	b := append([]byte{}, signature...)
+	b = append(b, versionCommandProxy, family)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(addrs)))
+	return append(b, addrs...), nil
+}
+
+// WriteTo writes the encoded Header to w.
+func (h *Header) WriteTo(w io.Writer) (int64, error) {
+	b, err := h.Marshal()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// Read reads a version 1 or 2 PROXY protocol header from r.
+// No data past the header is consumed, r can be used as-is afterwards.
+func Read(r io.Reader) (*Header, error) {
+	b := make([]byte, len(v1Prefix))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadHeader, err)
+	}
+	switch {
+	case bytes.Equal(b, v1Prefix):
+		return readV1(r)
+	case bytes.Equal(b, signature[:len(v1Prefix)]):
+		return readV2(r)
+	default:
+		return nil, fmt.Errorf("%w: unknown signature %q", ErrBadHeader, b)
+	}
+}
+
+// readV1 reads the rest of a version 1 (text) header, e.g., "TCP4 192.0.2.1 198.51.100.1 40000 443\r\n".
+func readV1(r io.Reader) (*Header, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line)+len(v1Prefix) >= maxV1Length {
+			return nil, fmt.Errorf("%w: v1 header too long", ErrBadHeader)
+		}
+		// Read byte by byte, not to consume any data past the header.
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadHeader, err)
+		}
+		line = append(line, b[0])
+	}
+	f := strings.Fields(string(line))
+	if len(f) > 0 && f[0] == "UNKNOWN" {
+		return new(Header), nil
+	}
+	if len(f) != 5 || (f[0] != "TCP4" && f[0] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrBadHeader, line)
+	}
+	h := new(Header)
+	for i, dst := range []*netip.AddrPort{&h.Source, &h.Destination} {
+		a, err := netip.ParseAddr(f[1+i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadHeader, err)
+		}
+		p, err := strconv.ParseUint(f[3+i], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadHeader, err)
+		}
+		*dst = netip.AddrPortFrom(a, uint16(p))
+	}
+	return h, nil
+}
+
+// readV2 reads the rest of a version 2 (binary) header.
+func readV2(r io.Reader) (*Header, error) {
+	// Rest of the signature, version/command, family and length.
+	b := make([]byte, len(signature)-len(v1Prefix)+4)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadHeader, err)
+	}
+	rest := len(signature) - len(v1Prefix)
+	if !bytes.Equal(b[:rest], signature[len(v1Prefix):]) {
+		return nil, fmt.Errorf("%w: bad v2 signature", ErrBadHeader)
+	}
+	verCmd, family := b[rest], b[rest+1]
+	if verCmd>>4 != 2 || verCmd&0x0f > 1 {
+		return nil, fmt.Errorf("%w: bad version/command %#x", ErrBadHeader, verCmd)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(b[rest+2:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadHeader, err)
+	}
+	h := new(Header)
+	var n int
+	switch family {
+	case familyTCP4, familyUDP4:
+		n = 4
+	case familyTCP6, familyUDP6:
+		n = 16
+	}
+	if n > 0 {
+		if len(body) < 2*n+4 {
+			return nil, fmt.Errorf("%w: short address block", ErrBadHeader)
+		}
+		// LOCAL connections carry addresses that must be ignored.
+		if verCmd&0x0f == 1 {
+			src, _ := netip.AddrFromSlice(body[:n])
+			dst, _ := netip.AddrFromSlice(body[n : 2*n])
+			h.Source = netip.AddrPortFrom(src, binary.BigEndian.Uint16(body[2*n:]))
+			h.Destination = netip.AddrPortFrom(dst, binary.BigEndian.Uint16(body[2*n+2:]))
+		}
+		body = body[2*n+4:]
+	} else {
+		// Other families (e.g., UNIX sockets) carry addresses of no use here, TLVs can't be located either.
+		body = nil
+	}
+	for len(body) > 0 {
+		if len(body) < 3 {
+			return nil, fmt.Errorf("%w: short TLV", ErrBadHeader)
+		}
+		l := int(binary.BigEndian.Uint16(body[1:]))
+		if len(body) < 3+l {
+			return nil, fmt.Errorf("%w: short TLV", ErrBadHeader)
+		}
+		h.TLVs = append(h.TLVs, TLV{Type: body[0], Value: body[3 : 3+l]})
+		body = body[3+l:]
+	}
+	return h, nil
+}
@@ -0,0 +1,179 @@
+package proxyproto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestMarshal(t *testing.T) {
+	sig := "\r\n\r\n\x00\r\nQUIT\n"
+	testdata := []struct {
+		name string
+		h    Header
+		want string
+	}{
+		{
+			name: "tcp4",
+			h: Header{
+				Source:      netip.MustParseAddrPort("192.0.2.1:40000"),
+				Destination: netip.MustParseAddrPort("198.51.100.1:443"),
+			},
+			want: sig + "\x21\x11\x00\x0c" +
+				"\xc0\x00\x02\x01" + "\xc6\x33\x64\x01" + "\x9c\x40" + "\x01\xbb",
+		},
+		{
+			name: "tcp4 mapped",
+			h: Header{
+				Source:      netip.MustParseAddrPort("[::ffff:192.0.2.1]:40000"),
+				Destination: netip.MustParseAddrPort("198.51.100.1:443"),
+			},
+			want: sig + "\x21\x11\x00\x0c" +
+				"\xc0\x00\x02\x01" + "\xc6\x33\x64\x01" + "\x9c\x40" + "\x01\xbb",
+		},
+		{
+			name: "mixed families",
+			h: Header{
+				Source:      netip.MustParseAddrPort("[2001:db8::1]:40000"),
+				Destination: netip.MustParseAddrPort("198.51.100.1:443"),
+			},
+			want: sig + "\x21\x21\x00\x24" +
+				"\x20\x01\x0d\xb8" + strings.Repeat("\x00", 11) + "\x01" +
+				strings.Repeat("\x00", 10) + "\xff\xff\xc6\x33\x64\x01" +
+				"\x9c\x40" + "\x01\xbb",
+		},
+		{
+			name: "unspec with TLVs",
+			h: Header{
+				Destination: netip.MustParseAddrPort("198.51.100.1:443"),
+				TLVs: []TLV{
+					{Type: TypeUniqueID, Value: []byte("sid")},
+					{Type: TypeIdentity, Value: []byte("foo@example.org")},
+				},
+			},
+			want: sig + "\x21\x00\x00\x18" +
+				"\x05\x00\x03sid" + "\xe0\x00\x0ffoo@example.org",
+		},
+	}
+	for _, tt := range testdata {
+		got, err := tt.h.Marshal()
+		if err != nil {
+			t.Errorf("Marshal(%v) error = %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, []byte(tt.want)) {
+			t.Errorf("Marshal(%v) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMarshal_TooLong(t *testing.T) {
+	h := Header{
+		TLVs: []TLV{{Type: TypeUniqueID, Value: make([]byte, maxUniqueIDLength+1)}},
+	}
+	if _, err := h.Marshal(); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Marshal() error = %v, want %v", err, ErrTooLong)
+	}
+}
+
+func TestRead(t *testing.T) {
+	v2, err := (&Header{
+		Source:      netip.MustParseAddrPort("[2001:db8::1]:40000"),
+		Destination: netip.MustParseAddrPort("[2001:db8::2]:443"),
+		TLVs:        []TLV{{Type: TypeUniqueID, Value: []byte("sid")}},
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := "\r\n\r\n\x00\r\nQUIT\n"
+	testdata := []struct {
+		name string
+		in   string
+		want *Header
+		ok   bool
+	}{
+		{
+			name: "v1 tcp4",
+			in:   "PROXY TCP4 192.0.2.1 198.51.100.1 40000 443\r\n",
+			want: &Header{
+				Source:      netip.MustParseAddrPort("192.0.2.1:40000"),
+				Destination: netip.MustParseAddrPort("198.51.100.1:443"),
+			},
+			ok: true,
+		},
+		{
+			name: "v1 unknown",
+			in:   "PROXY UNKNOWN\r\n",
+			want: &Header{},
+			ok:   true,
+		},
+		{
+			name: "v2 tcp6",
+			in:   string(v2),
+			want: &Header{
+				Source:      netip.MustParseAddrPort("[2001:db8::1]:40000"),
+				Destination: netip.MustParseAddrPort("[2001:db8::2]:443"),
+				TLVs:        []TLV{{Type: TypeUniqueID, Value: []byte("sid")}},
+			},
+			ok: true,
+		},
+		{
+			name: "v2 local",
+			in: sig + "\x20\x11\x00\x0c" +
+				"\xc0\x00\x02\x01" + "\xc6\x33\x64\x01" + "\x9c\x40" + "\x01\xbb",
+			want: &Header{},
+			ok:   true,
+		},
+		{
+			name: "not a PROXY header",
+			in:   "GET / HTTP/1.1\r\n\r\n",
+		},
+		{
+			name: "v1 bad address",
+			in:   "PROXY TCP4 192.0.2.x 198.51.100.1 40000 443\r\n",
+		},
+		{
+			name: "v1 too long",
+			in:   "PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n",
+		},
+		{
+			name: "v2 bad version",
+			in:   sig + "\x31\x11\x00\x00",
+		},
+		{
+			name: "v2 short address block",
+			in:   sig + "\x21\x11\x00\x04\xc0\x00\x02\x01",
+		},
+		{
+			name: "v2 truncated",
+			in:   sig + "\x21\x11\x00\x40\xc0",
+		},
+	}
+	for _, tt := range testdata {
+		r := strings.NewReader(tt.in + "SSH-2.0-test")
+		got, err := Read(r)
+		if err != nil {
+			if tt.ok {
+				t.Errorf("Read(%v) error = %v", tt.name, err)
+			} else if !errors.Is(err, ErrBadHeader) {
+				t.Errorf("Read(%v) error = %v, want %v", tt.name, err, ErrBadHeader)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("Read(%v) error = nil", tt.name)
+			continue
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("Read(%v) diff (-got +want):\n%v", tt.name, diff)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "SSH-2.0-test" {
+			t.Errorf("Read(%v) left %q", tt.name, rest)
+		}
+	}
+}
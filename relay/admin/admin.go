@@ -0,0 +1,170 @@
+// Package admin implements a gRPC service for inspecting and managing live SSH relay sessions.
+package admin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/hazaelsan/ssh-relay/relay/session/manager"
+	"github.com/hazaelsan/ssh-relay/session"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/protocolversionpb"
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/adminpb"
+)
+
+var (
+	versionMap = map[session.ProtocolVersion]protocolversionpb.ProtocolVersion{
+		session.CorpRelay:   protocolversionpb.ProtocolVersion_CORP_RELAY,
+		session.CorpRelayV4: protocolversionpb.ProtocolVersion_CORP_RELAY_V4,
+		session.SSHFE:       protocolversionpb.ProtocolVersion_SSH_FE,
+	}
+
+	eventMap = map[manager.EventType]adminpb.SessionEvent_Type{
+		manager.Created:    adminpb.SessionEvent_CREATED,
+		manager.Suspended:  adminpb.SessionEvent_SUSPENDED,
+		manager.Resumed:    adminpb.SessionEvent_RESUMED,
+		manager.Terminated: adminpb.SessionEvent_TERMINATED,
+	}
+)
+
+// A Maintainer toggles maintenance mode, rejecting new sessions with msg while enabled.
+type Maintainer interface {
+	SetMaintenance(enabled bool, msg string)
+}
+
+// New instantiates a *Server for the sessions registered in a *manager.Manager.
+// Maintenance mode is unavailable if m is nil.
+func New(mgr *manager.Manager, m Maintainer) *Server {
+	return &Server{
+		mgr: mgr,
+		m:   m,
+	}
+}
+
+// A Server implements the RelayAdmin gRPC service.
+type Server struct {
+	adminpb.UnimplementedRelayAdminServer
+	mgr *manager.Manager
+	m   Maintainer
+}
+
+// ListSessions lists all sessions, oldest first.
+func (s *Server) ListSessions(ctx context.Context, req *adminpb.ListSessionsRequest) (*adminpb.ListSessionsResponse, error) {
+	resp := new(adminpb.ListSessionsResponse)
+	for _, i := range s.mgr.List() {
+		resp.Sessions = append(resp.Sessions, sessionProto(i))
+	}
+	return resp, nil
+}
+
+// GetSession retrieves a single session.
+func (s *Server) GetSession(ctx context.Context, req *adminpb.GetSessionRequest) (*adminpb.Session, error) {
+	sid, err := parseSID(req.GetSid())
+	if err != nil {
+		return nil, err
+	}
+	i, err := s.mgr.Info(sid)
+	if err != nil {
+		return nil, managerError(err)
+	}
+	return sessionProto(i), nil
+}
+
+// TerminateSession closes a session, the caller is always logged.
+func (s *Server) TerminateSession(ctx context.Context, req *adminpb.TerminateSessionRequest) (*adminpb.TerminateSessionResponse, error) {
+	sid, err := parseSID(req.GetSid())
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("%v: Session termination requested by %v", sid, caller(ctx))
+	if err := s.mgr.Terminate(sid); err != nil {
+		return nil, managerError(err)
+	}
+	return new(adminpb.TerminateSessionResponse), nil
+}
+
+// WatchSessions streams session events until the client disconnects.
+func (s *Server) WatchSessions(req *adminpb.WatchSessionsRequest, stream grpc.ServerStreamingServer[adminpb.SessionEvent]) error {
+	ch, cancel := s.mgr.Watch()
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev := <-ch:
+			if err := stream.Send(eventProto(ev)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// SetMaintenanceMode enables or disables maintenance mode, the caller is always logged.
+func (s *Server) SetMaintenanceMode(ctx context.Context, req *adminpb.SetMaintenanceModeRequest) (*adminpb.SetMaintenanceModeResponse, error) {
+	if s.m == nil {
+		return nil, status.Error(codes.Unimplemented, "maintenance mode is not supported")
+	}
+	glog.Infof("Maintenance mode change to %v requested by %v", req.GetEnabled(), caller(ctx))
+	s.m.SetMaintenance(req.GetEnabled(), req.GetMessage())
+	return new(adminpb.SetMaintenanceModeResponse), nil
+}
+
+// parseSID parses a Session ID.
+func parseSID(s string) (uuid.UUID, error) {
+	sid, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, status.Errorf(codes.InvalidArgument, "bad SID %q: %v", s, err)
+	}
+	return sid, nil
+}
+
+// managerError converts a manager error to a gRPC status error.
+func managerError(err error) error {
+	if errors.Is(err, manager.ErrNoSuchSID) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// caller returns the peer address and certificate subject of the caller, used for logging.
+func caller(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "unknown"
+	}
+	if ti, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(ti.State.PeerCertificates) > 0 {
+		return ti.State.PeerCertificates[0].Subject.String() + "@" + p.Addr.String()
+	}
+	return p.Addr.String()
+}
+
+// sessionProto converts a manager.Info to its proto equivalent.
+func sessionProto(i manager.Info) *adminpb.Session {
+	return &adminpb.Session{
+		Sid:             i.SID.String(),
+		ProtocolVersion: versionMap[i.Version],
+		Destination:     i.Destination,
+		Origin:          i.Origin,
+		Identity:        i.Identity,
+		StartTime:       timestamppb.New(i.Start),
+		BytesToClient:   i.BytesToClient,
+		BytesToServer:   i.BytesToServer,
+		Suspended:       i.Suspended,
+	}
+}
+
+// eventProto converts a manager.Event to its proto equivalent.
+func eventProto(ev manager.Event) *adminpb.SessionEvent {
+	return &adminpb.SessionEvent{
+		Type:    eventMap[ev.Type],
+		Session: sessionProto(ev.Info),
+	}
+}
@@ -0,0 +1,186 @@
+package admin
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hazaelsan/ssh-relay/relay/session/manager"
+	"github.com/hazaelsan/ssh-relay/session"
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/protocolversionpb"
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/adminpb"
+)
+
+var md = manager.Metadata{
+	Destination: "example.org:22",
+	Origin:      "chrome-extension://foo",
+	Identity:    "user@example.org",
+}
+
+func newServer(t *testing.T) (*Server, session.Session) {
+	t.Helper()
+	mgr := manager.New(0, time.Minute, 0)
+	p, _ := net.Pipe()
+	s, err := mgr.New(p, session.CorpRelayV4, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(mgr, nil), s
+}
+
+func wantSession(s session.Session, got *adminpb.Session) *adminpb.Session {
+	return &adminpb.Session{
+		Sid:             s.SID().String(),
+		ProtocolVersion: protocolversionpb.ProtocolVersion_CORP_RELAY_V4,
+		Destination:     md.Destination,
+		Origin:          md.Origin,
+		Identity:        md.Identity,
+		StartTime:       got.GetStartTime(),
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	srv, s := newServer(t)
+	resp, err := srv.ListSessions(context.Background(), new(adminpb.ListSessionsRequest))
+	if err != nil {
+		t.Fatalf("ListSessions() error = %v", err)
+	}
+	if len(resp.GetSessions()) != 1 {
+		t.Fatalf("ListSessions() = %v, want 1 session", resp)
+	}
+	if diff := pretty.Compare(resp.GetSessions()[0], wantSession(s, resp.GetSessions()[0])); diff != "" {
+		t.Errorf("ListSessions() diff (-got +want):\n%v", diff)
+	}
+}
+
+func TestGetSession(t *testing.T) {
+	srv, s := newServer(t)
+	testdata := []struct {
+		sid  string
+		code codes.Code
+	}{
+		{
+			sid:  s.SID().String(),
+			code: codes.OK,
+		},
+		{
+			sid:  "invalid",
+			code: codes.InvalidArgument,
+		},
+		{
+			sid:  uuid.NewString(),
+			code: codes.NotFound,
+		},
+	}
+	for _, tt := range testdata {
+		got, err := srv.GetSession(context.Background(), &adminpb.GetSessionRequest{Sid: tt.sid})
+		if c := status.Code(err); c != tt.code {
+			t.Errorf("GetSession(%v) code = %v, want %v", tt.sid, c, tt.code)
+		}
+		if err != nil {
+			continue
+		}
+		if diff := pretty.Compare(got, wantSession(s, got)); diff != "" {
+			t.Errorf("GetSession(%v) diff (-got +want):\n%v", tt.sid, diff)
+		}
+	}
+}
+
+func TestTerminateSession(t *testing.T) {
+	srv, s := newServer(t)
+	req := &adminpb.TerminateSessionRequest{Sid: uuid.NewString()}
+	if _, err := srv.TerminateSession(context.Background(), req); status.Code(err) != codes.NotFound {
+		t.Errorf("TerminateSession(%v) error = %v, want %v", req.GetSid(), err, codes.NotFound)
+	}
+	req.Sid = s.SID().String()
+	if _, err := srv.TerminateSession(context.Background(), req); err != nil {
+		t.Errorf("TerminateSession(%v) error = %v", req.GetSid(), err)
+	}
+}
+
+// fakeStream is a WatchSessions stream sending events to a channel.
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	ch  chan *adminpb.SessionEvent
+}
+
+func (f *fakeStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeStream) Send(ev *adminpb.SessionEvent) error {
+	f.ch <- ev
+	return nil
+}
+
+func TestWatchSessions(t *testing.T) {
+	srv, s := newServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &fakeStream{ctx: ctx, ch: make(chan *adminpb.SessionEvent, 16)}
+	errc := make(chan error)
+	go func() {
+		errc <- srv.WatchSessions(new(adminpb.WatchSessionsRequest), stream)
+	}()
+	// Sessions created before the watcher is registered aren't reported, keep creating them until one is.
+	for created := false; !created; {
+		p, _ := net.Pipe()
+		if _, err := srv.mgr.New(p, session.CorpRelay, md); err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		select {
+		case ev := <-stream.ch:
+			created = ev.GetType() == adminpb.SessionEvent_CREATED
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if err := srv.mgr.Terminate(s.SID()); err != nil {
+		t.Fatalf("Terminate(%v) error = %v", s, err)
+	}
+	timeout := time.After(time.Second)
+	for done := false; !done; {
+		select {
+		case ev := <-stream.ch:
+			done = ev.GetType() == adminpb.SessionEvent_TERMINATED && ev.GetSession().GetSid() == s.SID().String()
+		case <-timeout:
+			t.Fatal("WatchSessions() timed out waiting for termination")
+		}
+	}
+	cancel()
+	if err := <-errc; err != nil {
+		t.Errorf("WatchSessions() error = %v", err)
+	}
+}
+
+type fakeMaintainer struct {
+	enabled bool
+	msg     string
+}
+
+func (f *fakeMaintainer) SetMaintenance(enabled bool, msg string) {
+	f.enabled = enabled
+	f.msg = msg
+}
+
+func TestSetMaintenanceMode(t *testing.T) {
+	srv, _ := newServer(t)
+	req := &adminpb.SetMaintenanceModeRequest{Enabled: true, Message: "upgrade"}
+	if _, err := srv.SetMaintenanceMode(context.Background(), req); status.Code(err) != codes.Unimplemented {
+		t.Errorf("SetMaintenanceMode() error = %v, want %v", err, codes.Unimplemented)
+	}
+	m := new(fakeMaintainer)
+	srv.m = m
+	if _, err := srv.SetMaintenanceMode(context.Background(), req); err != nil {
+		t.Fatalf("SetMaintenanceMode() error = %v", err)
+	}
+	if diff := pretty.Compare(m, &fakeMaintainer{enabled: true, msg: "upgrade"}); diff != "" {
+		t.Errorf("SetMaintenanceMode() diff (-got +want):\n%v", diff)
+	}
+}
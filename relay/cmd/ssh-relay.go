@@ -3,12 +3,16 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/golang/glog"
 	"github.com/hazaelsan/ssh-relay/relay/runner"
+	"github.com/hazaelsan/ssh-relay/reload"
 	"google.golang.org/protobuf/encoding/prototext"
 
 	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
@@ -39,6 +43,12 @@ func loadConfig(s string) (*configpb.Config, error) {
 	if len(cfg.GetProtocolVersions()) == 0 {
 		return nil, errors.New("protocol_versions must be set")
 	}
+	if cfg.GetAuthToken() != nil && cfg.GetAuthToken().GetCookie().GetName() == "" {
+		return nil, errors.New("auth_token.cookie.name must be set")
+	}
+	if cfg.GetAdminOptions() != nil && cfg.GetAdminOptions().GetPort() == "" {
+		return nil, errors.New("admin_options.port must be set")
+	}
 	return cfg, nil
 }
 
@@ -55,5 +65,28 @@ func main() {
 	if err != nil {
 		glog.Exit(err)
 	}
-	glog.Exit(r.Run())
+	stop := reload.OnSIGHUP(func() error {
+		cfg, err := loadConfig(*cfgFile)
+		if err != nil {
+			return err
+		}
+		return r.Reload(cfg)
+	})
+	defer stop()
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGTERM, os.Interrupt)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- r.Run()
+	}()
+	select {
+	case err := <-errc:
+		glog.Exit(err)
+	case sig := <-sigc:
+		glog.Infof("Received %v, shutting down", sig)
+		if err := r.Drain(context.Background()); err != nil {
+			glog.Exit(err)
+		}
+		glog.Info("Shutdown complete")
+	}
 }
@@ -0,0 +1,176 @@
+// ssh-relay-ctl inspects and manages live sessions via the SSH relay's RelayAdmin gRPC service.
+//
+// Usage:
+//
+//	ssh-relay-ctl --config=/etc/ssh-relay-ctl.txtpb list
+//	ssh-relay-ctl --config=/etc/ssh-relay-ctl.txtpb get <sid>
+//	ssh-relay-ctl --config=/etc/ssh-relay-ctl.txtpb terminate <sid>
+//	ssh-relay-ctl --config=/etc/ssh-relay-ctl.txtpb watch
+//	ssh-relay-ctl --config=/etc/ssh-relay-ctl.txtpb maintenance <on|off> [message]
+//
+// The config file is a GrpcOptions text proto, with the relay's admin address and a client certificate.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hazaelsan/ssh-relay/tls"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/grpcpb"
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/adminpb"
+)
+
+var (
+	cfgFile = flag.String("config", "", "path to a GrpcOptions text proto file")
+	timeout = flag.Duration("timeout", 10*time.Second, "timeout for unary requests")
+)
+
+func loadConfig(s string) (*grpcpb.GrpcOptions, error) {
+	buf, err := os.ReadFile(s)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(grpcpb.GrpcOptions)
+	if err := prototext.Unmarshal(buf, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.GetAddr() == "" {
+		return nil, errors.New("addr must be set")
+	}
+	if cfg.GetPort() == "" {
+		return nil, errors.New("port must be set")
+	}
+	return cfg, nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v --config=<file> list|get <sid>|terminate <sid>|watch|maintenance <on|off> [message]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *cfgFile == "" {
+		glog.Exit("--config must be set")
+	}
+	cfg, err := loadConfig(*cfgFile)
+	if err != nil {
+		glog.Exit(err)
+	}
+	addr := net.JoinHostPort(cfg.GetAddr(), cfg.GetPort())
+	creds, err := tls.TransportCreds(cfg.GetTlsConfig())
+	if err != nil {
+		glog.Exit(err)
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		glog.Exitf("grpc.NewClient(%v) error: %v", addr, err)
+	}
+	defer conn.Close()
+	if err := run(adminpb.NewRelayAdminClient(conn), flag.Args()); err != nil {
+		if errors.Is(err, errUsage) {
+			usage()
+			os.Exit(2)
+		}
+		glog.Exit(err)
+	}
+}
+
+// errUsage is returned for bad command-line arguments.
+var errUsage = errors.New("bad usage")
+
+// run executes a single command.
+func run(c adminpb.RelayAdminClient, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	if args[0] == "watch" {
+		return watch(c)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		resp, err := c.ListSessions(ctx, new(adminpb.ListSessionsRequest))
+		if err != nil {
+			return fmt.Errorf("ListSessions() error: %w", err)
+		}
+		printSessions(os.Stdout, resp.GetSessions())
+	case args[0] == "get" && len(args) == 2:
+		s, err := c.GetSession(ctx, &adminpb.GetSessionRequest{Sid: args[1]})
+		if err != nil {
+			return fmt.Errorf("GetSession(%v) error: %w", args[1], err)
+		}
+		fmt.Print(prototext.MarshalOptions{Multiline: true}.Format(s))
+	case args[0] == "terminate" && len(args) == 2:
+		if _, err := c.TerminateSession(ctx, &adminpb.TerminateSessionRequest{Sid: args[1]}); err != nil {
+			return fmt.Errorf("TerminateSession(%v) error: %w", args[1], err)
+		}
+		fmt.Printf("%v: terminated\n", args[1])
+	case args[0] == "maintenance" && (len(args) == 2 || len(args) == 3):
+		req := new(adminpb.SetMaintenanceModeRequest)
+		switch args[1] {
+		case "on":
+			req.Enabled = true
+		case "off":
+		default:
+			return errUsage
+		}
+		if len(args) == 3 {
+			req.Message = args[2]
+		}
+		if _, err := c.SetMaintenanceMode(ctx, req); err != nil {
+			return fmt.Errorf("SetMaintenanceMode(%v) error: %w", req.GetEnabled(), err)
+		}
+		fmt.Printf("maintenance mode %v\n", args[1])
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+// watch prints session events until interrupted.
+func watch(c adminpb.RelayAdminClient) error {
+	stream, err := c.WatchSessions(context.Background(), new(adminpb.WatchSessionsRequest))
+	if err != nil {
+		return fmt.Errorf("WatchSessions() error: %w", err)
+	}
+	for {
+		ev, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("WatchSessions() error: %w", err)
+		}
+		s := ev.GetSession()
+		fmt.Printf("%v\t%v\t%v\t%v\t%v\t%v\n", time.Now().Format(time.RFC3339), ev.GetType(), s.GetSid(), s.GetDestination(), s.GetOrigin(), s.GetIdentity())
+	}
+}
+
+// printSessions prints sessions in tabular form.
+func printSessions(w io.Writer, sessions []*adminpb.Session) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SID\tPROTOCOL\tDESTINATION\tORIGIN\tIDENTITY\tAGE\tTO CLIENT\tTO SERVER\tSTATE")
+	for _, s := range sessions {
+		state := "active"
+		if s.GetSuspended() {
+			state = "suspended"
+		}
+		age := time.Since(s.GetStartTime().AsTime()).Round(time.Second)
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", s.GetSid(), s.GetProtocolVersion(), s.GetDestination(), s.GetOrigin(), s.GetIdentity(), age, s.GetBytesToClient(), s.GetBytesToServer(), state)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,245 @@
+// Package dialer implements the SSH Relay's outbound connection dialer.
+//
+// Destination hosts are resolved before dialing, every candidate address is checked against a Filter
+// and connections are made to the exact address that was checked,
+// a second DNS lookup (e.g., DNS rebinding) can't change the destination.
+//
+// Connections to some destinations may be tunneled through an upstream SOCKS5 or HTTP CONNECT proxy.
+package dialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hazaelsan/ssh-relay/duration"
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+const (
+	// defaultHappyEyeballsDelay is the delay between parallel connection attempts, as recommended by RFC 8305.
+	defaultHappyEyeballsDelay = 300 * time.Millisecond
+
+	// dnsPort is the default port for DNS resolvers.
+	dnsPort = 53
+)
+
+var (
+	// ErrBlocked is returned when a destination address is not allowed by the Filter.
+	ErrBlocked = errors.New("destination address not allowed")
+
+	// ErrBadPort is returned when a destination port is invalid.
+	ErrBadPort = errors.New("bad port")
+
+	// ErrNoAddress is returned when a destination host doesn't resolve to any address.
+	ErrNoAddress = errors.New("no address for host")
+
+	// ErrResolve is returned when a destination host could not be resolved.
+	ErrResolve = errors.New("host not found")
+
+	// ErrTimeout is returned when a connection could not be established in time.
+	ErrTimeout = errors.New("connection timed out")
+
+	// ErrRefused is returned when a destination refused the connection.
+	ErrRefused = errors.New("connection refused")
+
+	// ErrBadSourceAddress is returned when source_address is not a valid IP address.
+	ErrBadSourceAddress = errors.New("bad source address")
+
+	// ErrBadResolver is returned when dns_resolver is not a valid ip[:port].
+	ErrBadResolver = errors.New("bad DNS resolver address")
+)
+
+// New creates a *Dialer from a proto config message, a nil config uses the defaults.
+func New(cfg *configpb.Config_ClientOptions) (*Dialer, error) {
+	f, err := NewFilter(cfg.GetNetworkFilter())
+	if err != nil {
+		return nil, fmt.Errorf("NewFilter() error: %w", err)
+	}
+	d := &Dialer{
+		filter:   f,
+		resolver: net.DefaultResolver,
+		delay:    defaultHappyEyeballsDelay,
+		nodelay:  !cfg.GetDisableNodelay(),
+	}
+	for dst, src := range map[*time.Duration]*durationpb.Duration{
+		&d.timeout:          cfg.GetDialTimeout(),
+		&d.delay:            cfg.GetHappyEyeballsDelay(),
+		&d.dialer.KeepAlive: cfg.GetKeepaliveInterval(),
+	} {
+		if err := duration.FromProto(dst, src); err != nil {
+			return nil, fmt.Errorf("duration.FromProto(%v, %v) error: %w", dst, src, err)
+		}
+	}
+	if d.dialer.KeepAlive == 0 && cfg.GetKeepaliveInterval() != nil {
+		// A zero net.Dialer.KeepAlive means the default interval.
+		d.dialer.KeepAlive = -1
+	}
+	if s := cfg.GetSourceAddress(); s != "" {
+		if d.source, err = netip.ParseAddr(s); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadSourceAddress, err)
+		}
+		d.source = d.source.Unmap()
+		d.dialer.LocalAddr = net.TCPAddrFromAddrPort(netip.AddrPortFrom(d.source, 0))
+	}
+	if s := cfg.GetDnsResolver(); s != "" {
+		if d.resolver, err = newResolver(s); err != nil {
+			return nil, err
+		}
+	}
+	// Proxies are often on internal networks, they're not subject to the Filter.
+	d.proxyDialer = d.dialer
+	d.dialer.Control = d.control
+	if d.proxies, err = newProxyRules(cfg.GetUpstreamProxies()); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// newResolver creates a *net.Resolver sending all queries to a DNS server in ip[:port] format.
+func newResolver(s string) (*net.Resolver, error) {
+	ap, err := netip.ParseAddrPort(s)
+	if err != nil {
+		a, aErr := netip.ParseAddr(s)
+		if aErr != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadResolver, err)
+		}
+		ap = netip.AddrPortFrom(a, dnsPort)
+	}
+	addr := ap.String()
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}, nil
+}
+
+// A Dialer makes outbound TCP connections to allowed addresses only.
+type Dialer struct {
+	filter      *Filter
+	resolver    *net.Resolver
+	dialer      net.Dialer
+	proxyDialer net.Dialer
+	proxies     []proxyRule
+	timeout     time.Duration
+	delay       time.Duration // Happy Eyeballs delay, 0 tries addresses one at a time.
+	source      netip.Addr
+	nodelay     bool
+}
+
+// Dial connects to a destination, directly or through the upstream proxy selected for it.
+// Direct connections resolve the host and race connection attempts across allowed addresses (Happy Eyeballs).
+// If no address is allowed the returned error wraps ErrBlocked,
+// other failures wrap ErrResolve, ErrTimeout, ErrRefused or ErrProxy where applicable.
+func (d *Dialer) Dial(ctx context.Context, dst policy.Destination) (net.Conn, error) {
+	host := dst.Host
+	p, err := strconv.ParseUint(dst.Port, 10, 16)
+	if err != nil || p == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrBadPort, dst.Port)
+	}
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	if r := d.route(dst); r != nil && r.proxy != nil {
+		return d.dialProxy(ctx, r, host, uint16(p))
+	}
+	addrs, err := d.resolve(ctx, host)
+	if err != nil {
+		return nil, classify(err)
+	}
+	var blocked []error
+	var candidates []netip.AddrPort
+	for _, a := range addrs {
+		if err := d.filter.Check(a); err != nil {
+			glog.V(2).Infof("Skipping %v for %v: %v", a, host, err)
+			blocked = append(blocked, err)
+			continue
+		}
+		if d.source.IsValid() && d.source.Is4() != a.Unmap().Is4() {
+			glog.V(2).Infof("Skipping %v for %v: address family doesn't match source address %v", a, host, d.source)
+			continue
+		}
+		candidates = append(candidates, netip.AddrPortFrom(a, uint16(p)))
+	}
+	if len(candidates) == 0 {
+		if len(blocked) > 0 {
+			// Every candidate address was blocked.
+			return nil, fmt.Errorf("Dial(%v) error: %w", host, errors.Join(blocked...))
+		}
+		return nil, fmt.Errorf("Dial(%v) error: %w: no address matching source address family", host, ErrNoAddress)
+	}
+	conn, err := d.race(ctx, interleave(candidates))
+	if err != nil {
+		return nil, classify(fmt.Errorf("Dial(%v) error: %w", host, err))
+	}
+	if err := d.setNoDelay(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// setNoDelay disables TCP_NODELAY on a connection if configured to do so.
+func (d *Dialer) setNoDelay(conn net.Conn) error {
+	if tc, ok := conn.(*net.TCPConn); ok && !d.nodelay {
+		if err := tc.SetNoDelay(false); err != nil {
+			return fmt.Errorf("SetNoDelay(%v) error: %w", conn.RemoteAddr(), err)
+		}
+	}
+	return nil
+}
+
+// resolve returns all candidate addresses for a host.
+func (d *Dialer) resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	if a, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{a}, nil
+	}
+	addrs, err := d.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("LookupNetIP(%v) error: %w", host, err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrNoAddress, host)
+	}
+	return addrs, nil
+}
+
+// control re-checks the address right before connecting, guarding against any address
+// reaching the socket without having gone through the Filter.
+func (d *Dialer) control(_, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("netip.ParseAddrPort(%v) error: %w", address, err)
+	}
+	return d.filter.Check(ap.Addr())
+}
+
+// classify wraps a dial error with ErrResolve, ErrTimeout or ErrRefused, if applicable.
+// Timeouts take precedence when several addresses failed differently.
+func classify(err error) error {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	case errors.Is(err, ErrNoAddress), errors.As(err, &dnsErr):
+		return fmt.Errorf("%w: %w", ErrResolve, err)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return fmt.Errorf("%w: %w", ErrRefused, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,205 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+func listener(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestDial(t *testing.T) {
+	port := listener(t)
+	d, err := New(&configpb.Config_ClientOptions{
+		NetworkFilter: &configpb.Config_ClientOptions_NetworkFilter{
+			AllowCidrs: []string{"127.0.0.1/32"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"127.0.0.1", "localhost"} {
+		conn, err := d.Dial(context.Background(), policy.Destination{Host: host, Port: port})
+		if err != nil {
+			t.Errorf("Dial(%v) error = %v", host, err)
+			continue
+		}
+		if got, want := conn.RemoteAddr().(*net.TCPAddr).IP.String(), "127.0.0.1"; got != want {
+			t.Errorf("Dial(%v) remote address = %v, want %v", host, got, want)
+		}
+		conn.Close()
+	}
+}
+
+func TestDial_Failures(t *testing.T) {
+	port := listener(t)
+	d, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testdata := []struct {
+		host, port string
+		want       error
+	}{
+		{
+			host: "127.0.0.1",
+			port: port,
+			want: ErrBlocked,
+		},
+		{
+			host: "localhost",
+			port: port,
+			want: ErrBlocked,
+		},
+		{
+			host: "127.0.0.1",
+			port: "ssh",
+			want: ErrBadPort,
+		},
+		{
+			host: "127.0.0.1",
+			port: "0",
+			want: ErrBadPort,
+		},
+	}
+	for _, tt := range testdata {
+		if _, err := d.Dial(context.Background(), policy.Destination{Host: tt.host, Port: tt.port}); !errors.Is(err, tt.want) {
+			t.Errorf("Dial(%v, %v) error = %v, want %v", tt.host, tt.port, err, tt.want)
+		}
+	}
+}
+
+func TestControl(t *testing.T) {
+	d, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.control("tcp", "127.0.0.1:22", nil); !errors.Is(err, ErrBlocked) {
+		t.Errorf("control() error = %v, want %v", err, ErrBlocked)
+	}
+	if err := d.control("tcp", "192.0.2.1:22", nil); err != nil {
+		t.Errorf("control() error = %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	testdata := []struct {
+		name string
+		cfg  *configpb.Config_ClientOptions
+		ok   bool
+	}{
+		{
+			name: "defaults",
+			ok:   true,
+		},
+		{
+			name: "all options",
+			cfg: &configpb.Config_ClientOptions{
+				DialTimeout:        durationpb.New(time.Second),
+				SourceAddress:      "127.0.0.1",
+				HappyEyeballsDelay: durationpb.New(0),
+				KeepaliveInterval:  durationpb.New(0),
+				DisableNodelay:     true,
+				DnsResolver:        "[::1]:5353",
+			},
+			ok: true,
+		},
+		{
+			name: "resolver without port",
+			cfg:  &configpb.Config_ClientOptions{DnsResolver: "192.0.2.53"},
+			ok:   true,
+		},
+		{
+			name: "bad source address",
+			cfg:  &configpb.Config_ClientOptions{SourceAddress: "localhost"},
+		},
+		{
+			name: "bad resolver",
+			cfg:  &configpb.Config_ClientOptions{DnsResolver: "dns.example.org:53"},
+		},
+		{
+			name: "negative timeout",
+			cfg:  &configpb.Config_ClientOptions{DialTimeout: durationpb.New(-time.Second)},
+		},
+		{
+			name: "bad network filter",
+			cfg: &configpb.Config_ClientOptions{
+				NetworkFilter: &configpb.Config_ClientOptions_NetworkFilter{AllowCidrs: []string{"invalid"}},
+			},
+		},
+	}
+	for _, tt := range testdata {
+		d, err := New(tt.cfg)
+		if err != nil {
+			if tt.ok {
+				t.Errorf("New(%v) error = %v", tt.name, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("New(%v) error = nil", tt.name)
+		}
+		if tt.cfg.GetKeepaliveInterval() != nil && d.dialer.KeepAlive >= 0 {
+			t.Errorf("New(%v) keepalive = %v, want disabled", tt.name, d.dialer.KeepAlive)
+		}
+	}
+}
+
+func TestDial_Classify(t *testing.T) {
+	// Grab a free port, nothing listens on it afterwards.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+	d, err := New(&configpb.Config_ClientOptions{
+		NetworkFilter: &configpb.Config_ClientOptions_NetworkFilter{DisableDefaultDeny: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Dial(context.Background(), policy.Destination{Host: "127.0.0.1", Port: port}); !errors.Is(err, ErrRefused) {
+		t.Errorf("Dial(%v) error = %v, want %v", port, err, ErrRefused)
+	}
+	if _, err := d.Dial(context.Background(), policy.Destination{Host: "invalid host", Port: port}); !errors.Is(err, ErrResolve) {
+		t.Errorf("Dial(invalid host) error = %v, want %v", err, ErrResolve)
+	}
+	// A resolver that never answers.
+	d.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	d.timeout = 10 * time.Millisecond
+	if _, err := d.Dial(context.Background(), policy.Destination{Host: "example.org", Port: port}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Dial(example.org) error = %v, want %v", err, ErrTimeout)
+	}
+}
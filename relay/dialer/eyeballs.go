@@ -0,0 +1,89 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// interleave sorts addresses alternating between address families, starting with the family of the first address.
+// The relative order within each family is kept, see RFC 8305 section 4.
+func interleave(addrs []netip.AddrPort) []netip.AddrPort {
+	if len(addrs) == 0 {
+		return nil
+	}
+	var primary, secondary []netip.AddrPort
+	first := addrs[0].Addr().Unmap().Is4()
+	for _, a := range addrs {
+		if a.Addr().Unmap().Is4() == first {
+			primary = append(primary, a)
+		} else {
+			secondary = append(secondary, a)
+		}
+	}
+	out := make([]netip.AddrPort, 0, len(addrs))
+	for i := 0; i < len(primary) || i < len(secondary); i++ {
+		if i < len(primary) {
+			out = append(out, primary[i])
+		}
+		if i < len(secondary) {
+			out = append(out, secondary[i])
+		}
+	}
+	return out
+}
+
+// race connects to the first address that accepts the connection.
+// A new attempt is started whenever the previous one fails, or after d.delay if it's still pending;
+// with no delay addresses are tried one at a time.
+func (d *Dialer) race(ctx context.Context, addrs []netip.AddrPort) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result)
+	var next, pending int
+	start := func() {
+		a := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := d.dialer.DialContext(ctx, "tcp", a.String())
+			results <- result{conn, err}
+		}()
+	}
+	start()
+	var errs []error
+	for pending > 0 {
+		var delay <-chan time.Time
+		if next < len(addrs) && d.delay > 0 {
+			delay = time.After(d.delay)
+		}
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// Close any connections established by the remaining attempts.
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			errs = append(errs, r.err)
+			if next < len(addrs) {
+				start()
+			}
+		case <-delay:
+			start()
+		}
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,97 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+func TestInterleave(t *testing.T) {
+	v4a := netip.MustParseAddrPort("192.0.2.1:22")
+	v4b := netip.MustParseAddrPort("192.0.2.2:22")
+	v4c := netip.MustParseAddrPort("192.0.2.3:22")
+	v6a := netip.MustParseAddrPort("[2001:db8::1]:22")
+	v6b := netip.MustParseAddrPort("[2001:db8::2]:22")
+	testdata := []struct {
+		addrs []netip.AddrPort
+		want  []netip.AddrPort
+	}{
+		{},
+		{
+			addrs: []netip.AddrPort{v6a, v6b, v4a, v4b, v4c},
+			want:  []netip.AddrPort{v6a, v4a, v6b, v4b, v4c},
+		},
+		{
+			addrs: []netip.AddrPort{v4a, v4b, v6a},
+			want:  []netip.AddrPort{v4a, v6a, v4b},
+		},
+		{
+			addrs: []netip.AddrPort{v4a, v4b},
+			want:  []netip.AddrPort{v4a, v4b},
+		},
+	}
+	for _, tt := range testdata {
+		if diff := pretty.Compare(interleave(tt.addrs), tt.want); diff != "" {
+			t.Errorf("interleave(%v) diff (-got +want):\n%v", tt.addrs, diff)
+		}
+	}
+}
+
+func TestRace(t *testing.T) {
+	slow := netip.MustParseAddrPort("127.0.0.1:" + listener(t))
+	fast := netip.MustParseAddrPort("127.0.0.1:" + listener(t))
+	const stall = 500 * time.Millisecond
+	testdata := []struct {
+		name     string
+		delay    time.Duration
+		want     netip.AddrPort
+		minDelay time.Duration
+	}{
+		{
+			name:  "happy eyeballs",
+			delay: 10 * time.Millisecond,
+			want:  fast,
+		},
+		{
+			name:     "sequential",
+			want:     slow,
+			minDelay: stall,
+		},
+	}
+	for _, tt := range testdata {
+		d, err := New(&configpb.Config_ClientOptions{
+			NetworkFilter: &configpb.Config_ClientOptions_NetworkFilter{DisableDefaultDeny: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.delay = tt.delay
+		// Connections to the slow address stall before connecting.
+		d.dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			if address == slow.String() {
+				time.Sleep(stall)
+			}
+			return nil
+		}
+		start := time.Now()
+		conn, err := d.race(context.Background(), []netip.AddrPort{slow, fast})
+		if err != nil {
+			t.Errorf("race(%v) error = %v", tt.name, err)
+			continue
+		}
+		if got := conn.RemoteAddr().(*net.TCPAddr).AddrPort(); got != tt.want {
+			t.Errorf("race(%v) = %v, want %v", tt.name, got, tt.want)
+		}
+		if got := time.Since(start); got < tt.minDelay || (tt.minDelay == 0 && got >= stall) {
+			t.Errorf("race(%v) took %v", tt.name, got)
+		}
+		conn.Close()
+	}
+}
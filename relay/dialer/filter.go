@@ -0,0 +1,88 @@
+package dialer
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+// sharedPrefix is the shared address space used for carrier-grade NAT, see RFC 6598.
+var sharedPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// defaultDenied returns whether an address is blocked by default.
+func defaultDenied(a netip.Addr) bool {
+	return a.IsLoopback() ||
+		a.IsLinkLocalUnicast() ||
+		a.IsLinkLocalMulticast() ||
+		a.IsInterfaceLocalMulticast() ||
+		a.IsMulticast() ||
+		a.IsPrivate() ||
+		a.IsUnspecified() ||
+		sharedPrefix.Contains(a) ||
+		a == netip.AddrFrom4([4]byte{255, 255, 255, 255})
+}
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, c := range cidrs {
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("netip.ParsePrefix(%v) error: %w", c, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func contains(prefixes []netip.Prefix, a netip.Addr) (netip.Prefix, bool) {
+	for _, p := range prefixes {
+		if p.Contains(a) {
+			return p, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// NewFilter creates a *Filter from a NetworkFilter proto message.
+// A nil message creates a Filter that only blocks the default ranges.
+func NewFilter(pb *configpb.Config_ClientOptions_NetworkFilter) (*Filter, error) {
+	allow, err := parsePrefixes(pb.GetAllowCidrs())
+	if err != nil {
+		return nil, fmt.Errorf("allow_cidrs error: %w", err)
+	}
+	deny, err := parsePrefixes(pb.GetDenyCidrs())
+	if err != nil {
+		return nil, fmt.Errorf("deny_cidrs error: %w", err)
+	}
+	return &Filter{
+		allow:         allow,
+		deny:          deny,
+		defaultDenied: !pb.GetDisableDefaultDeny(),
+	}, nil
+}
+
+// A Filter decides which IP addresses the relay may connect to.
+type Filter struct {
+	allow         []netip.Prefix
+	deny          []netip.Prefix
+	defaultDenied bool
+}
+
+// Check returns an error wrapping ErrBlocked if an address is not allowed.
+func (f *Filter) Check(a netip.Addr) error {
+	a = a.Unmap()
+	if p, ok := contains(f.deny, a); ok {
+		return fmt.Errorf("%w: %v is in denied range %v", ErrBlocked, a, p)
+	}
+	if len(f.allow) > 0 {
+		if _, ok := contains(f.allow, a); ok {
+			return nil
+		}
+		return fmt.Errorf("%w: %v is not in an allowed range", ErrBlocked, a)
+	}
+	if f.defaultDenied && defaultDenied(a) {
+		return fmt.Errorf("%w: %v is in a range blocked by default", ErrBlocked, a)
+	}
+	return nil
+}
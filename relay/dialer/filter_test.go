@@ -0,0 +1,128 @@
+package dialer
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+func TestCheck(t *testing.T) {
+	testdata := []struct {
+		name string
+		pb   *configpb.Config_ClientOptions_NetworkFilter
+		addr string
+		ok   bool
+	}{
+		{
+			name: "public",
+			addr: "192.0.2.1",
+			ok:   true,
+		},
+		{
+			name: "public v6",
+			addr: "2001:db8::1",
+			ok:   true,
+		},
+		{
+			name: "loopback",
+			addr: "127.0.0.1",
+		},
+		{
+			name: "loopback v6",
+			addr: "::1",
+		},
+		{
+			name: "mapped loopback",
+			addr: "::ffff:127.0.0.1",
+		},
+		{
+			name: "metadata",
+			addr: "169.254.169.254",
+		},
+		{
+			name: "private",
+			addr: "10.1.2.3",
+		},
+		{
+			name: "private v6",
+			addr: "fd00::1",
+		},
+		{
+			name: "cgnat",
+			addr: "100.64.0.1",
+		},
+		{
+			name: "unspecified",
+			addr: "0.0.0.0",
+		},
+		{
+			name: "default deny disabled",
+			pb: &configpb.Config_ClientOptions_NetworkFilter{
+				DisableDefaultDeny: true,
+			},
+			addr: "127.0.0.1",
+			ok:   true,
+		},
+		{
+			name: "allowed private",
+			pb: &configpb.Config_ClientOptions_NetworkFilter{
+				AllowCidrs: []string{"10.1.0.0/16"},
+			},
+			addr: "10.1.2.3",
+			ok:   true,
+		},
+		{
+			name: "outside allowed ranges",
+			pb: &configpb.Config_ClientOptions_NetworkFilter{
+				AllowCidrs: []string{"10.1.0.0/16"},
+			},
+			addr: "192.0.2.1",
+		},
+		{
+			name: "deny wins",
+			pb: &configpb.Config_ClientOptions_NetworkFilter{
+				AllowCidrs: []string{"10.0.0.0/8"},
+				DenyCidrs:  []string{"10.1.0.0/16"},
+			},
+			addr: "10.1.2.3",
+		},
+		{
+			name: "denied public",
+			pb: &configpb.Config_ClientOptions_NetworkFilter{
+				DenyCidrs: []string{"192.0.2.0/24"},
+			},
+			addr: "192.0.2.1",
+		},
+	}
+	for _, tt := range testdata {
+		f, err := NewFilter(tt.pb)
+		if err != nil {
+			t.Errorf("NewFilter(%v) error = %v", tt.name, err)
+			continue
+		}
+		err = f.Check(netip.MustParseAddr(tt.addr))
+		if !tt.ok {
+			if !errors.Is(err, ErrBlocked) {
+				t.Errorf("Check(%v) error = %v, want %v", tt.name, err, ErrBlocked)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Check(%v) error = %v", tt.name, err)
+		}
+	}
+}
+
+func TestNewFilter_Failures(t *testing.T) {
+	testdata := []*configpb.Config_ClientOptions_NetworkFilter{
+		{AllowCidrs: []string{"10.0.0.0"}},
+		{DenyCidrs: []string{"invalid"}},
+	}
+	for _, tt := range testdata {
+		if _, err := NewFilter(tt); err == nil {
+			t.Errorf("NewFilter(%v) error = nil", tt)
+		}
+	}
+}
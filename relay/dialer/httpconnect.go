@@ -0,0 +1,97 @@
+package dialer
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+// newHTTPConnect creates an *httpConnect proxy from a proto config message.
+func newHTTPConnect(pb *configpb.Config_ClientOptions_UpstreamProxy_HttpConnectProxy) (*httpConnect, error) {
+	if err := checkProxyAddress(pb.GetAddress()); err != nil {
+		return nil, err
+	}
+	if pb.GetPassword() != "" && pb.GetUsername() == "" {
+		return nil, fmt.Errorf("%w: password requires a username", ErrBadProxy)
+	}
+	tlsCfg, err := proxyTLSConfig(pb.GetAddress(), pb)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadProxy, err)
+	}
+	p := &httpConnect{
+		addr: pb.GetAddress(),
+		tls:  tlsCfg,
+	}
+	if pb.GetUsername() != "" {
+		p.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(pb.GetUsername()+":"+pb.GetPassword()))
+	}
+	return p, nil
+}
+
+// httpConnect is an HTTP upstream proxy supporting the CONNECT method, optionally over TLS.
+type httpConnect struct {
+	addr string
+	tls  *tls.Config // nil for plain HTTP.
+	auth string      // Proxy-Authorization header.
+}
+
+func (h *httpConnect) address() string {
+	return h.addr
+}
+
+func (h *httpConnect) handshake(ctx context.Context, conn net.Conn, target string) (net.Conn, error) {
+	if h.tls != nil {
+		tc := tls.Client(conn, h.tls)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return nil, fmt.Errorf("%w: TLS handshake error: %w", ErrProxy, err)
+		}
+		conn = tc
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if h.auth != "" {
+		req.Header.Set("Proxy-Authorization", h.auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("%w: write error: %w", ErrProxy, err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: read error: %w", ErrProxy, err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGatewayTimeout:
+		return nil, fmt.Errorf("%w: %v", ErrTimeout, resp.Status)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrProxy, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		// The SSH server spoke first and its data was read along with the response.
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose reads go through a buffered reader first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,201 @@
+package dialer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+// SOCKS5 protocol constants, see RFC 1928 and RFC 1929.
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+
+	socks5PasswordVersion = 0x01
+
+	socks5CmdConnect = 0x01
+
+	socks5AtypIPv4   = 0x01
+	socks5AtypDomain = 0x03
+	socks5AtypIPv6   = 0x04
+
+	socks5Succeeded   = 0x00
+	socks5ConnRefused = 0x05
+	socks5TTLExpired  = 0x06
+
+	// socks5MaxFieldLength is the maximum length of host names, usernames and passwords.
+	socks5MaxFieldLength = 255
+)
+
+// socks5Replies are the SOCKS5 reply messages.
+var socks5Replies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// newSocks5 creates a *socks5 proxy from a proto config message.
+func newSocks5(pb *configpb.Config_ClientOptions_UpstreamProxy_Socks5Proxy) (*socks5, error) {
+	if err := checkProxyAddress(pb.GetAddress()); err != nil {
+		return nil, err
+	}
+	if pb.GetPassword() != "" && pb.GetUsername() == "" {
+		return nil, fmt.Errorf("%w: password requires a username", ErrBadProxy)
+	}
+	if len(pb.GetUsername()) > socks5MaxFieldLength || len(pb.GetPassword()) > socks5MaxFieldLength {
+		return nil, fmt.Errorf("%w: username and password must be at most %v bytes", ErrBadProxy, socks5MaxFieldLength)
+	}
+	return &socks5{
+		addr:     pb.GetAddress(),
+		username: pb.GetUsername(),
+		password: pb.GetPassword(),
+	}, nil
+}
+
+// socks5 is a SOCKS5 upstream proxy.
+type socks5 struct {
+	addr     string
+	username string
+	password string
+}
+
+func (s *socks5) address() string {
+	return s.addr
+}
+
+func (s *socks5) handshake(_ context.Context, conn net.Conn, target string) (net.Conn, error) {
+	if err := s.authenticate(conn); err != nil {
+		return nil, err
+	}
+	req, err := socks5Request(target)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return nil, fmt.Errorf("%w: write error: %w", ErrProxy, err)
+	}
+	// VER, REP, RSV, ATYP.
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return nil, fmt.Errorf("%w: read error: %w", ErrProxy, err)
+	}
+	if hdr[0] != socks5Version {
+		return nil, fmt.Errorf("%w: bad SOCKS version %v", ErrProxy, hdr[0])
+	}
+	switch hdr[1] {
+	case socks5Succeeded:
+	case socks5ConnRefused:
+		return nil, fmt.Errorf("%w: %v", ErrRefused, socks5Replies[hdr[1]])
+	case socks5TTLExpired:
+		return nil, fmt.Errorf("%w: %v", ErrTimeout, socks5Replies[hdr[1]])
+	default:
+		msg, ok := socks5Replies[hdr[1]]
+		if !ok {
+			msg = fmt.Sprintf("unknown reply %v", hdr[1])
+		}
+		return nil, fmt.Errorf("%w: %v", ErrProxy, msg)
+	}
+	// Discard the bound address, BND.ADDR and BND.PORT.
+	var n int
+	switch hdr[3] {
+	case socks5AtypIPv4:
+		n = net.IPv4len + 2
+	case socks5AtypIPv6:
+		n = net.IPv6len + 2
+	case socks5AtypDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return nil, fmt.Errorf("%w: read error: %w", ErrProxy, err)
+		}
+		n = int(l[0]) + 2
+	default:
+		return nil, fmt.Errorf("%w: bad address type %v", ErrProxy, hdr[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, n)); err != nil {
+		return nil, fmt.Errorf("%w: read error: %w", ErrProxy, err)
+	}
+	return conn, nil
+}
+
+// authenticate negotiates the authentication method, authenticating with a username/password if set.
+func (s *socks5) authenticate(conn net.Conn) error {
+	method := byte(socks5AuthNone)
+	if s.username != "" {
+		method = socks5AuthPassword
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return fmt.Errorf("%w: write error: %w", ErrProxy, err)
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("%w: read error: %w", ErrProxy, err)
+	}
+	if resp[0] != socks5Version {
+		return fmt.Errorf("%w: bad SOCKS version %v", ErrProxy, resp[0])
+	}
+	if resp[1] != method {
+		return fmt.Errorf("%w: no acceptable authentication method", ErrProxy)
+	}
+	if method == socks5AuthNone {
+		return nil
+	}
+	req := []byte{socks5PasswordVersion, byte(len(s.username))}
+	req = append(req, s.username...)
+	req = append(req, byte(len(s.password)))
+	req = append(req, s.password...)
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("%w: write error: %w", ErrProxy, err)
+	}
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("%w: read error: %w", ErrProxy, err)
+	}
+	if resp[0] != socks5PasswordVersion {
+		return fmt.Errorf("%w: bad SOCKS username/password version %v", ErrProxy, resp[0])
+	}
+	if resp[1] != 0 {
+		return fmt.Errorf("%w: authentication failed", ErrProxy)
+	}
+	return nil
+}
+
+// socks5Request builds a CONNECT request for a host:port target.
+func socks5Request(target string) ([]byte, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadPort, err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadPort, port)
+	}
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	if a, err := netip.ParseAddr(host); err == nil {
+		a = a.Unmap()
+		if a.Is4() {
+			req = append(req, socks5AtypIPv4)
+		} else {
+			req = append(req, socks5AtypIPv6)
+		}
+		req = append(req, a.AsSlice()...)
+	} else {
+		if len(host) > socks5MaxFieldLength {
+			return nil, fmt.Errorf("%w: host name too long", ErrProxy)
+		}
+		req = append(req, socks5AtypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	return binary.BigEndian.AppendUint16(req, uint16(p)), nil
+}
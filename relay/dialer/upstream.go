@@ -0,0 +1,190 @@
+package dialer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+	rtls "github.com/hazaelsan/ssh-relay/tls"
+
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+var (
+	// ErrProxy is returned when an upstream proxy could not be reached or failed to connect to the destination.
+	ErrProxy = errors.New("upstream proxy error")
+
+	// ErrBadProxy is returned when an upstream proxy rule is invalid.
+	ErrBadProxy = errors.New("bad upstream proxy")
+)
+
+// A proxy is an upstream proxy connections are tunneled through.
+type proxy interface {
+	// address returns the proxy address, in host:port format.
+	address() string
+
+	// handshake asks the proxy to connect to target (in host:port format) over conn,
+	// returning the connection to use from then on.
+	// Errors wrap ErrProxy, or ErrRefused/ErrTimeout if the proxy reported so.
+	handshake(ctx context.Context, conn net.Conn, target string) (net.Conn, error)
+}
+
+// A proxyRule selects the upstream proxy to use for matching destinations.
+type proxyRule struct {
+	name      string
+	m         *policy.Matcher
+	proxy     proxy // nil dials directly.
+	remoteDNS bool
+}
+
+// newProxyRules creates the upstream proxy rules from proto config messages.
+func newProxyRules(pbs []*configpb.Config_ClientOptions_UpstreamProxy) ([]proxyRule, error) {
+	var rules []proxyRule
+	for i, pb := range pbs {
+		m, err := policy.NewMatcher(pb.GetMatch())
+		if err != nil {
+			return nil, fmt.Errorf("upstream proxy %v: NewMatcher() error: %w", i, err)
+		}
+		r := proxyRule{
+			name:      pb.GetName(),
+			m:         m,
+			remoteDNS: pb.GetRemoteDns(),
+		}
+		if r.name == "" {
+			r.name = fmt.Sprintf("#%v", i)
+		}
+		switch {
+		case pb.GetSocks5() != nil:
+			r.proxy, err = newSocks5(pb.GetSocks5())
+		case pb.GetHttpConnect() != nil:
+			r.proxy, err = newHTTPConnect(pb.GetHttpConnect())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("upstream proxy %v: %w", r.name, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// checkProxyAddress validates a proxy address in host:port format.
+func checkProxyAddress(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBadProxy, err)
+	}
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+		return fmt.Errorf("%w: bad port in %v", ErrBadProxy, addr)
+	}
+	return nil
+}
+
+// proxyTLSConfig creates the *tls.Config for connecting to an upstream proxy, nil if TLS is disabled.
+func proxyTLSConfig(addr string, pb *configpb.Config_ClientOptions_UpstreamProxy_HttpConnectProxy) (*tls.Config, error) {
+	r, err := rtls.NewReloader(pb.GetTlsConfig())
+	if err != nil || r == nil {
+		return nil, err
+	}
+	c := r.ClientConfig()
+	if c.ServerName == "" {
+		c.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	return c, nil
+}
+
+// route returns the upstream proxy rule matching a Destination, nil if none matches.
+func (d *Dialer) route(dst policy.Destination) *proxyRule {
+	for i, r := range d.proxies {
+		if r.m.Match(dst) {
+			return &d.proxies[i]
+		}
+	}
+	return nil
+}
+
+// dialProxy connects to a destination through an upstream proxy.
+// Unless the rule sends hostnames to the proxy, the destination host is resolved locally
+// and the allowed addresses are sent to the proxy in turn until one of them succeeds.
+func (d *Dialer) dialProxy(ctx context.Context, r *proxyRule, host string, port uint16) (net.Conn, error) {
+	targets, err := d.proxyTargets(ctx, r, host, port)
+	if err != nil {
+		return nil, err
+	}
+	return d.dialProxyTargets(ctx, r, targets)
+}
+
+// dialProxyTargets asks an upstream proxy to connect to each target in turn, returns the first connection established.
+// Other targets are not tried if the proxy itself can't be reached or ctx is done.
+func (d *Dialer) dialProxyTargets(ctx context.Context, r *proxyRule, targets []string) (net.Conn, error) {
+	var errs []error
+	for _, target := range targets {
+		glog.V(2).Infof("Connecting to %v via upstream proxy %v (%v)", target, r.name, r.proxy.address())
+		conn, err := d.proxyDialer.DialContext(ctx, "tcp", r.proxy.address())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: DialContext(%v) error: %v", ErrProxy, r.proxy.address(), err))
+			break
+		}
+		if err := d.setNoDelay(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		pc, err := r.proxy.handshake(ctx, conn, target)
+		if err != nil {
+			conn.Close()
+			errs = append(errs, fmt.Errorf("Dial(%v) via %v error: %w", target, r.name, err))
+			if ctx.Err() != nil {
+				break
+			}
+			glog.V(2).Infof("Dial(%v) via %v error: %v, trying the next address", target, r.name, err)
+			continue
+		}
+		pc.SetDeadline(time.Time{})
+		return pc, nil
+	}
+	return nil, classify(errors.Join(errs...))
+}
+
+// proxyTargets returns the host:port targets to ask an upstream proxy to connect to, in order.
+func (d *Dialer) proxyTargets(ctx context.Context, r *proxyRule, host string, port uint16) ([]string, error) {
+	p := strconv.Itoa(int(port))
+	if _, err := netip.ParseAddr(host); r.remoteDNS && err != nil {
+		return []string{net.JoinHostPort(host, p)}, nil
+	}
+	addrs, err := d.resolve(ctx, host)
+	if err != nil {
+		return nil, classify(err)
+	}
+	var blocked []error
+	var targets []string
+	for _, a := range interleave(toAddrPorts(addrs, port)) {
+		if err := d.filter.Check(a.Addr()); err != nil {
+			glog.V(2).Infof("Skipping %v for %v: %v", a, host, err)
+			blocked = append(blocked, err)
+			continue
+		}
+		targets = append(targets, a.String())
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("Dial(%v) error: %w", host, errors.Join(blocked...))
+	}
+	return targets, nil
+}
+
+// toAddrPorts pairs every address with a port.
+func toAddrPorts(addrs []netip.Addr, port uint16) []netip.AddrPort {
+	aps := make([]netip.AddrPort, len(addrs))
+	for i, a := range addrs {
+		aps[i] = netip.AddrPortFrom(a, port)
+	}
+	return aps
+}
@@ -0,0 +1,431 @@
+package dialer
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/destinationpb"
+	"github.com/hazaelsan/ssh-relay/proto/v1/tlspb"
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+// greeting is sent by the fake proxies once connected, as an SSH server would.
+const greeting = "SSH-2.0-test\r\n"
+
+// fakeProxy serves a proxy connection per serve func in order, reporting the requested targets.
+func fakeProxy(t *testing.T, serve ...func(conn net.Conn) (string, error)) (string, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	targets := make(chan string, len(serve))
+	go func() {
+		for _, s := range serve {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			target, err := s(conn)
+			conn.Close()
+			if err != nil {
+				t.Errorf("fake proxy error: %v", err)
+			}
+			targets <- target
+		}
+	}()
+	return l.Addr().String(), targets
+}
+
+// socks5Server serves a SOCKS5 CONNECT request, requiring username "user" and password "pass" if auth is set.
+func socks5Server(auth bool, reply byte) func(net.Conn) (string, error) {
+	return func(conn net.Conn) (string, error) {
+		hdr := make([]byte, 2)
+		if _, err := io.ReadFull(conn, hdr); err != nil {
+			return "", err
+		}
+		methods := make([]byte, hdr[1])
+		if _, err := io.ReadFull(conn, methods); err != nil {
+			return "", err
+		}
+		if !auth {
+			conn.Write([]byte{socks5Version, socks5AuthNone})
+		} else {
+			conn.Write([]byte{socks5Version, socks5AuthPassword})
+			ver := make([]byte, 1)
+			io.ReadFull(conn, ver)
+			if user, pass := readField(conn), readField(conn); ver[0] != socks5PasswordVersion || user != "user" || pass != "pass" {
+				conn.Write([]byte{socks5PasswordVersion, 1})
+				return "", nil
+			}
+			conn.Write([]byte{socks5PasswordVersion, 0})
+		}
+		return socks5Connect(conn, reply)
+	}
+}
+
+// socks5Connect serves the CONNECT request of an authenticated SOCKS5 connection.
+func socks5Connect(conn net.Conn, reply byte) (string, error) {
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return "", err
+	}
+	var host string
+	switch req[3] {
+	case socks5AtypIPv4:
+		b := make([]byte, net.IPv4len)
+		io.ReadFull(conn, b)
+		host = net.IP(b).String()
+	case socks5AtypIPv6:
+		b := make([]byte, net.IPv6len)
+		io.ReadFull(conn, b)
+		host = net.IP(b).String()
+	case socks5AtypDomain:
+		host = readField(conn)
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+	target := net.JoinHostPort(host, fmt.Sprint(int(port[0])<<8|int(port[1])))
+	conn.Write([]byte{socks5Version, reply, 0, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+	if reply == socks5Succeeded {
+		conn.Write([]byte(greeting))
+	}
+	return target, nil
+}
+
+// readField reads a length-prefixed SOCKS5 field.
+func readField(r io.Reader) string {
+	l := make([]byte, 1)
+	io.ReadFull(r, l)
+	b := make([]byte, l[0])
+	io.ReadFull(r, b)
+	return string(b)
+}
+
+// connectServer serves an HTTP CONNECT request, requiring user:pass Basic auth if auth is set.
+func connectServer(auth bool, code int) func(net.Conn) (string, error) {
+	return func(conn net.Conn) (string, error) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return "", err
+		}
+		if req.Method != http.MethodConnect {
+			return "", fmt.Errorf("method = %v, want %v", req.Method, http.MethodConnect)
+		}
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+		if auth && req.Header.Get("Proxy-Authorization") != want {
+			code = http.StatusProxyAuthRequired
+		}
+		// The greeting is sent along with the response, as a fast SSH server would.
+		resp := fmt.Sprintf("HTTP/1.1 %v %v\r\n\r\n", code, http.StatusText(code))
+		if code == http.StatusOK {
+			resp += greeting
+		}
+		conn.Write([]byte(resp))
+		return req.Host, nil
+	}
+}
+
+func newProxyDialer(t *testing.T, proxies ...*configpb.Config_ClientOptions_UpstreamProxy) *Dialer {
+	t.Helper()
+	d, err := New(&configpb.Config_ClientOptions{
+		NetworkFilter: &configpb.Config_ClientOptions_NetworkFilter{
+			DenyCidrs:          []string{"192.0.2.0/24"},
+			DisableDefaultDeny: true,
+		},
+		UpstreamProxies: proxies,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDial_Proxy(t *testing.T) {
+	plainHTTP := &tlspb.TlsConfig{TlsMode: tlspb.TlsConfig_TLS_MODE_DISABLED}
+	testdata := []struct {
+		name       string
+		serve      func(net.Conn) (string, error)
+		proxy      *configpb.Config_ClientOptions_UpstreamProxy
+		host       string
+		wantTarget string
+		wantErr    error
+	}{
+		{
+			name:  "socks5",
+			serve: socks5Server(false, socks5Succeeded),
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy: &configpb.Config_ClientOptions_UpstreamProxy_Socks5{Socks5: &configpb.Config_ClientOptions_UpstreamProxy_Socks5Proxy{}},
+			},
+			host:       "127.0.0.1",
+			wantTarget: "127.0.0.1:22",
+		},
+		{
+			name:  "socks5 auth",
+			serve: socks5Server(true, socks5Succeeded),
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy: &configpb.Config_ClientOptions_UpstreamProxy_Socks5{Socks5: &configpb.Config_ClientOptions_UpstreamProxy_Socks5Proxy{
+					Username: "user",
+					Password: "pass",
+				}},
+			},
+			host:       "::1",
+			wantTarget: "[::1]:22",
+		},
+		{
+			name:  "socks5 remote dns",
+			serve: socks5Server(false, socks5Succeeded),
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy:     &configpb.Config_ClientOptions_UpstreamProxy_Socks5{Socks5: &configpb.Config_ClientOptions_UpstreamProxy_Socks5Proxy{}},
+				RemoteDns: true,
+			},
+			host:       "ssh.internal.example",
+			wantTarget: "ssh.internal.example:22",
+		},
+		{
+			name:  "socks5 bad auth",
+			serve: socks5Server(true, socks5Succeeded),
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy: &configpb.Config_ClientOptions_UpstreamProxy_Socks5{Socks5: &configpb.Config_ClientOptions_UpstreamProxy_Socks5Proxy{
+					Username: "user",
+					Password: "wrong",
+				}},
+			},
+			host:    "127.0.0.1",
+			wantErr: ErrProxy,
+		},
+		{
+			name: "socks5 bad auth version",
+			serve: func(conn net.Conn) (string, error) {
+				io.ReadFull(conn, make([]byte, 3))
+				conn.Write([]byte{socks5Version, socks5AuthPassword})
+				io.ReadFull(conn, make([]byte, 1))
+				readField(conn)
+				readField(conn)
+				conn.Write([]byte{socks5Version, 0})
+				// Only reached if the client accepted the bad reply.
+				socks5Connect(conn, socks5Succeeded)
+				return "", nil
+			},
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy: &configpb.Config_ClientOptions_UpstreamProxy_Socks5{Socks5: &configpb.Config_ClientOptions_UpstreamProxy_Socks5Proxy{
+					Username: "user",
+					Password: "pass",
+				}},
+			},
+			host:    "127.0.0.1",
+			wantErr: ErrProxy,
+		},
+		{
+			name:  "socks5 refused",
+			serve: socks5Server(false, socks5ConnRefused),
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy: &configpb.Config_ClientOptions_UpstreamProxy_Socks5{Socks5: &configpb.Config_ClientOptions_UpstreamProxy_Socks5Proxy{}},
+			},
+			host:       "127.0.0.1",
+			wantTarget: "127.0.0.1:22",
+			wantErr:    ErrRefused,
+		},
+		{
+			name:  "http connect",
+			serve: connectServer(true, http.StatusOK),
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy: &configpb.Config_ClientOptions_UpstreamProxy_HttpConnect{HttpConnect: &configpb.Config_ClientOptions_UpstreamProxy_HttpConnectProxy{
+					TlsConfig: plainHTTP,
+					Username:  "user",
+					Password:  "pass",
+				}},
+			},
+			host:       "127.0.0.1",
+			wantTarget: "127.0.0.1:22",
+		},
+		{
+			name:  "http connect denied",
+			serve: connectServer(true, http.StatusOK),
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy: &configpb.Config_ClientOptions_UpstreamProxy_HttpConnect{HttpConnect: &configpb.Config_ClientOptions_UpstreamProxy_HttpConnectProxy{
+					TlsConfig: plainHTTP,
+				}},
+			},
+			host:       "127.0.0.1",
+			wantTarget: "127.0.0.1:22",
+			wantErr:    ErrProxy,
+		},
+		{
+			name:  "http connect timeout",
+			serve: connectServer(false, http.StatusGatewayTimeout),
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy: &configpb.Config_ClientOptions_UpstreamProxy_HttpConnect{HttpConnect: &configpb.Config_ClientOptions_UpstreamProxy_HttpConnectProxy{
+					TlsConfig: plainHTTP,
+				}},
+			},
+			host:       "127.0.0.1",
+			wantTarget: "127.0.0.1:22",
+			wantErr:    ErrTimeout,
+		},
+		{
+			name:  "blocked",
+			serve: socks5Server(false, socks5Succeeded),
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy:     &configpb.Config_ClientOptions_UpstreamProxy_Socks5{Socks5: &configpb.Config_ClientOptions_UpstreamProxy_Socks5Proxy{}},
+				RemoteDns: true,
+			},
+			host:    "192.0.2.1",
+			wantErr: ErrBlocked,
+		},
+	}
+	for _, tt := range testdata {
+		addr, targets := fakeProxy(t, tt.serve)
+		switch p := tt.proxy.GetProxy().(type) {
+		case *configpb.Config_ClientOptions_UpstreamProxy_Socks5:
+			p.Socks5.Address = addr
+		case *configpb.Config_ClientOptions_UpstreamProxy_HttpConnect:
+			p.HttpConnect.Address = addr
+		}
+		d := newProxyDialer(t, tt.proxy)
+		conn, err := d.Dial(context.Background(), policy.Destination{Host: tt.host, Port: "22"})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Dial(%v) error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantTarget != "" {
+			if got := <-targets; got != tt.wantTarget {
+				t.Errorf("Dial(%v) target = %v, want %v", tt.name, got, tt.wantTarget)
+			}
+		}
+		if err != nil {
+			continue
+		}
+		b := make([]byte, len(greeting))
+		if _, err := io.ReadFull(conn, b); err != nil || string(b) != greeting {
+			t.Errorf("Dial(%v) read = %q, %v, want %q", tt.name, b, err, greeting)
+		}
+		conn.Close()
+	}
+}
+
+func TestDialProxyTargets(t *testing.T) {
+	addr, targets := fakeProxy(t, socks5Server(false, socks5ConnRefused), socks5Server(false, socks5Succeeded))
+	r := &proxyRule{name: "test", proxy: &socks5{addr: addr}}
+	d := newProxyDialer(t)
+	conn, err := d.dialProxyTargets(context.Background(), r, []string{"127.0.0.2:22", "127.0.0.1:22"})
+	if err != nil {
+		t.Fatalf("dialProxyTargets() error = %v", err)
+	}
+	defer conn.Close()
+	// The first target is refused, the next one is tried.
+	for _, want := range []string{"127.0.0.2:22", "127.0.0.1:22"} {
+		if got := <-targets; got != want {
+			t.Errorf("dialProxyTargets() target = %v, want %v", got, want)
+		}
+	}
+	b := make([]byte, len(greeting))
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != greeting {
+		t.Errorf("dialProxyTargets() read = %q, %v, want %q", b, err, greeting)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	socks := &configpb.Config_ClientOptions_UpstreamProxy_Socks5{Socks5: &configpb.Config_ClientOptions_UpstreamProxy_Socks5Proxy{Address: "proxy:1080"}}
+	d := newProxyDialer(t,
+		&configpb.Config_ClientOptions_UpstreamProxy{
+			Name:  "direct",
+			Match: &destinationpb.DestinationMatcher{Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "bastion.example.org"}},
+		},
+		&configpb.Config_ClientOptions_UpstreamProxy{
+			Name:  "internal",
+			Match: &destinationpb.DestinationMatcher{Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "*.example.org"}},
+			Proxy: socks,
+		},
+	)
+	testdata := []struct {
+		host string
+		want string
+	}{
+		{host: "bastion.example.org", want: "direct"},
+		{host: "ssh.example.org", want: "internal"},
+		{host: "example.com"},
+	}
+	for _, tt := range testdata {
+		var got string
+		if r := d.route(policy.Destination{Host: tt.host, Port: "22"}); r != nil {
+			got = r.name
+		}
+		if got != tt.want {
+			t.Errorf("route(%v) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestNewProxyRules(t *testing.T) {
+	testdata := []struct {
+		name  string
+		proxy *configpb.Config_ClientOptions_UpstreamProxy
+		ok    bool
+	}{
+		{
+			name:  "direct",
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{},
+			ok:    true,
+		},
+		{
+			name: "socks5",
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy: &configpb.Config_ClientOptions_UpstreamProxy_Socks5{Socks5: &configpb.Config_ClientOptions_UpstreamProxy_Socks5Proxy{Address: "proxy:1080"}},
+			},
+			ok: true,
+		},
+		{
+			name: "https connect",
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy: &configpb.Config_ClientOptions_UpstreamProxy_HttpConnect{HttpConnect: &configpb.Config_ClientOptions_UpstreamProxy_HttpConnectProxy{Address: "proxy:3128"}},
+			},
+			ok: true,
+		},
+		{
+			name: "missing address",
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy: &configpb.Config_ClientOptions_UpstreamProxy_Socks5{Socks5: &configpb.Config_ClientOptions_UpstreamProxy_Socks5Proxy{}},
+			},
+		},
+		{
+			name: "bad port",
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy: &configpb.Config_ClientOptions_UpstreamProxy_HttpConnect{HttpConnect: &configpb.Config_ClientOptions_UpstreamProxy_HttpConnectProxy{Address: "proxy:0"}},
+			},
+		},
+		{
+			name: "password without username",
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Proxy: &configpb.Config_ClientOptions_UpstreamProxy_Socks5{Socks5: &configpb.Config_ClientOptions_UpstreamProxy_Socks5Proxy{
+					Address:  "proxy:1080",
+					Password: "pass",
+				}},
+			},
+		},
+		{
+			name: "bad match",
+			proxy: &configpb.Config_ClientOptions_UpstreamProxy{
+				Match: &destinationpb.DestinationMatcher{Host: &destinationpb.DestinationMatcher_HostRegex{HostRegex: "("}},
+			},
+		},
+	}
+	for _, tt := range testdata {
+		_, err := newProxyRules([]*configpb.Config_ClientOptions_UpstreamProxy{tt.proxy})
+		if err != nil && tt.ok {
+			t.Errorf("newProxyRules(%v) error = %v", tt.name, err)
+		}
+		if err == nil && !tt.ok {
+			t.Errorf("newProxyRules(%v) error = nil", tt.name)
+		}
+	}
+}
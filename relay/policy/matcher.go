@@ -0,0 +1,150 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/destinationpb"
+)
+
+// A Destination is an outbound connection request made by a client.
+type Destination struct {
+	// Host is the destination host, as requested by the client.
+	Host string
+
+	// Port is the destination port.
+	Port string
+
+	// Origin is the client origin.
+	Origin string
+
+	// Identity is the client identity from its TLS certificate, if any.
+	Identity string
+}
+
+func (d Destination) String() string {
+	if d.Identity != "" {
+		return fmt.Sprintf("%v:%v (origin %v, identity %v)", d.Host, d.Port, d.Origin, d.Identity)
+	}
+	return fmt.Sprintf("%v:%v (origin %v)", d.Host, d.Port, d.Origin)
+}
+
+// NormalizeHost returns the canonical form of a destination host, as used for matching:
+// lowercase, without the trailing dot of a fully-qualified name.
+func NormalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// portRange is an inclusive range of TCP ports.
+type portRange struct {
+	start, end int
+}
+
+// NewMatcher creates a *Matcher from a DestinationMatcher proto message.
+// A nil message creates a Matcher that matches all requests.
+func NewMatcher(pb *destinationpb.DestinationMatcher) (*Matcher, error) {
+	m := &Matcher{
+		glob:       NormalizeHost(pb.GetHostGlob()),
+		origins:    pb.GetOrigins(),
+		identities: pb.GetIdentities(),
+	}
+	if m.glob != "" {
+		if _, err := path.Match(m.glob, ""); err != nil {
+			return nil, fmt.Errorf("path.Match(%v) error: %w", m.glob, err)
+		}
+	}
+	for _, g := range m.identities {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("path.Match(%v) error: %w", g, err)
+		}
+	}
+	if re := pb.GetHostRegex(); re != "" {
+		var err error
+		if m.re, err = regexp.Compile("(?i)^(?:" + re + ")$"); err != nil {
+			return nil, fmt.Errorf("regexp.Compile(%v) error: %w", re, err)
+		}
+	}
+	for _, pr := range pb.GetPorts() {
+		r := portRange{start: int(pr.GetStart()), end: int(pr.GetEnd())}
+		if r.end == 0 {
+			r.end = r.start
+		}
+		if r.start < 1 || r.end > 65535 || r.start > r.end {
+			return nil, fmt.Errorf("%w: %v-%v", ErrBadPortRange, pr.GetStart(), pr.GetEnd())
+		}
+		m.ports = append(m.ports, r)
+	}
+	return m, nil
+}
+
+// A Matcher matches Destinations by host, port, origin and client identity.
+type Matcher struct {
+	glob       string
+	re         *regexp.Regexp
+	ports      []portRange
+	origins    []string
+	identities []string
+}
+
+// Match returns whether a Destination matches all the conditions set in the Matcher.
+// Hosts are compared in their normalized form, see NormalizeHost.
+func (m *Matcher) Match(d Destination) bool {
+	return m.matchHost(NormalizeHost(d.Host)) && m.matchPort(d.Port) && m.matchOrigin(d.Origin) && m.matchIdentity(d.Identity)
+}
+
+func (m *Matcher) matchHost(host string) bool {
+	if m.glob != "" {
+		ok, err := path.Match(m.glob, host)
+		return err == nil && ok
+	}
+	if m.re != nil {
+		return m.re.MatchString(host)
+	}
+	return true
+}
+
+func (m *Matcher) matchPort(port string) bool {
+	if len(m.ports) == 0 {
+		return true
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	for _, r := range m.ports {
+		if p >= r.start && p <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Matcher) matchOrigin(origin string) bool {
+	if len(m.origins) == 0 {
+		return true
+	}
+	for _, o := range m.origins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Matcher) matchIdentity(identity string) bool {
+	if len(m.identities) == 0 {
+		return true
+	}
+	if identity == "" {
+		return false
+	}
+	for _, g := range m.identities {
+		if ok, err := path.Match(g, identity); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
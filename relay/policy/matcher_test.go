@@ -0,0 +1,205 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/destinationpb"
+)
+
+func TestMatch(t *testing.T) {
+	testdata := []struct {
+		name string
+		pb   *destinationpb.DestinationMatcher
+		d    Destination
+		want bool
+	}{
+		{
+			name: "empty",
+			d:    Destination{Host: "foo", Port: "22"},
+			want: true,
+		},
+		{
+			name: "glob",
+			pb: &destinationpb.DestinationMatcher{
+				Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "*.example.org"},
+			},
+			d:    Destination{Host: "SSH.Example.org", Port: "22"},
+			want: true,
+		},
+		{
+			name: "glob mismatch",
+			pb: &destinationpb.DestinationMatcher{
+				Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "*.example.org"},
+			},
+			d: Destination{Host: "example.org.evil.com", Port: "22"},
+		},
+		{
+			name: "glob fully-qualified",
+			pb: &destinationpb.DestinationMatcher{
+				Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "*.example.org"},
+			},
+			d:    Destination{Host: "ssh.example.org.", Port: "22"},
+			want: true,
+		},
+		{
+			name: "regex",
+			pb: &destinationpb.DestinationMatcher{
+				Host: &destinationpb.DestinationMatcher_HostRegex{HostRegex: `ssh[0-9]+\.example\.org`},
+			},
+			d:    Destination{Host: "ssh12.example.org", Port: "22"},
+			want: true,
+		},
+		{
+			name: "regex is anchored",
+			pb: &destinationpb.DestinationMatcher{
+				Host: &destinationpb.DestinationMatcher_HostRegex{HostRegex: `ssh[0-9]+\.example\.org`},
+			},
+			d: Destination{Host: "ssh12.example.org.evil.com", Port: "22"},
+		},
+		{
+			name: "regex fully-qualified",
+			pb: &destinationpb.DestinationMatcher{
+				Host: &destinationpb.DestinationMatcher_HostRegex{HostRegex: `ssh[0-9]+\.example\.org`},
+			},
+			d:    Destination{Host: "SSH12.example.org.", Port: "22"},
+			want: true,
+		},
+		{
+			name: "port range",
+			pb: &destinationpb.DestinationMatcher{
+				Ports: []*destinationpb.PortRange{
+					{Start: 22},
+					{Start: 2200, End: 2299},
+				},
+			},
+			d:    Destination{Host: "foo", Port: "2222"},
+			want: true,
+		},
+		{
+			name: "port mismatch",
+			pb: &destinationpb.DestinationMatcher{
+				Ports: []*destinationpb.PortRange{{Start: 22}},
+			},
+			d: Destination{Host: "foo", Port: "23"},
+		},
+		{
+			name: "bad port",
+			pb: &destinationpb.DestinationMatcher{
+				Ports: []*destinationpb.PortRange{{Start: 22}},
+			},
+			d: Destination{Host: "foo", Port: "ssh"},
+		},
+		{
+			name: "origin",
+			pb: &destinationpb.DestinationMatcher{
+				Origins: []string{"chrome-extension://foo"},
+			},
+			d:    Destination{Host: "foo", Port: "22", Origin: "chrome-extension://foo"},
+			want: true,
+		},
+		{
+			name: "origin mismatch",
+			pb: &destinationpb.DestinationMatcher{
+				Origins: []string{"chrome-extension://foo"},
+			},
+			d: Destination{Host: "foo", Port: "22", Origin: "chrome-extension://bar"},
+		},
+		{
+			name: "identity",
+			pb: &destinationpb.DestinationMatcher{
+				Identities: []string{"admin@example.org", "*@ops.example.org"},
+			},
+			d:    Destination{Host: "foo", Port: "22", Identity: "user@ops.example.org"},
+			want: true,
+		},
+		{
+			name: "identity mismatch",
+			pb: &destinationpb.DestinationMatcher{
+				Identities: []string{"*@ops.example.org"},
+			},
+			d: Destination{Host: "foo", Port: "22", Identity: "user@example.org"},
+		},
+		{
+			name: "no identity",
+			pb: &destinationpb.DestinationMatcher{
+				Identities: []string{"*"},
+			},
+			d: Destination{Host: "foo", Port: "22"},
+		},
+	}
+	for _, tt := range testdata {
+		m, err := NewMatcher(tt.pb)
+		if err != nil {
+			t.Errorf("NewMatcher(%v) error = %v", tt.name, err)
+			continue
+		}
+		if got := m.Match(tt.d); got != tt.want {
+			t.Errorf("Match(%v, %v) = %v, want %v", tt.name, tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	testdata := map[string]string{
+		"ssh.example.org":   "ssh.example.org",
+		"SSH.Example.org":   "ssh.example.org",
+		"ssh.example.org.":  "ssh.example.org",
+		"ssh.example.org..": "ssh.example.org.",
+		"192.0.2.1":         "192.0.2.1",
+		"2001:DB8::1":       "2001:db8::1",
+	}
+	for host, want := range testdata {
+		if got := NormalizeHost(host); got != want {
+			t.Errorf("NormalizeHost(%v) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestNewMatcher_Failures(t *testing.T) {
+	testdata := []struct {
+		name string
+		pb   *destinationpb.DestinationMatcher
+	}{
+		{
+			name: "bad glob",
+			pb: &destinationpb.DestinationMatcher{
+				Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "[foo"},
+			},
+		},
+		{
+			name: "bad regex",
+			pb: &destinationpb.DestinationMatcher{
+				Host: &destinationpb.DestinationMatcher_HostRegex{HostRegex: "(foo"},
+			},
+		},
+		{
+			name: "bad identity glob",
+			pb: &destinationpb.DestinationMatcher{
+				Identities: []string{"[foo"},
+			},
+		},
+		{
+			name: "port 0",
+			pb: &destinationpb.DestinationMatcher{
+				Ports: []*destinationpb.PortRange{{Start: 0}},
+			},
+		},
+		{
+			name: "port too large",
+			pb: &destinationpb.DestinationMatcher{
+				Ports: []*destinationpb.PortRange{{Start: 22, End: 65536}},
+			},
+		},
+		{
+			name: "reversed range",
+			pb: &destinationpb.DestinationMatcher{
+				Ports: []*destinationpb.PortRange{{Start: 23, End: 22}},
+			},
+		},
+	}
+	for _, tt := range testdata {
+		if _, err := NewMatcher(tt.pb); err == nil {
+			t.Errorf("NewMatcher(%v) error = nil", tt.name)
+		}
+	}
+}
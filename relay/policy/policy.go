@@ -0,0 +1,100 @@
+// Package policy decides which destinations SSH Relay clients may connect to.
+package policy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/policypb"
+)
+
+var (
+	// ErrDenied is returned when a destination is rejected by the policy.
+	ErrDenied = errors.New("destination not allowed")
+
+	// ErrBadPortRange is returned when a port range is invalid.
+	ErrBadPortRange = errors.New("bad port range")
+)
+
+// rule is a single policy rule.
+type rule struct {
+	name  string
+	allow bool
+	m     *Matcher
+}
+
+// New creates a *Policy from a DestinationPolicy proto message.
+// A nil message creates a Policy that allows all destinations.
+func New(pb *policypb.DestinationPolicy) (*Policy, error) {
+	if pb == nil {
+		return nil, nil
+	}
+	p := &Policy{
+		allow: pb.GetDefaultAction() == policypb.DestinationPolicy_ALLOW,
+	}
+	for i, r := range pb.GetRules() {
+		if r.GetAction() == policypb.DestinationPolicy_ACTION_UNSPECIFIED {
+			return nil, fmt.Errorf("rule %v: action must be set", i)
+		}
+		m, err := NewMatcher(r.GetMatch())
+		if err != nil {
+			return nil, fmt.Errorf("rule %v: NewMatcher() error: %w", i, err)
+		}
+		name := r.GetName()
+		if name == "" {
+			name = fmt.Sprintf("#%v", i)
+		}
+		p.rules = append(p.rules, rule{
+			name:  name,
+			allow: r.GetAction() == policypb.DestinationPolicy_ALLOW,
+			m:     m,
+		})
+	}
+	return p, nil
+}
+
+// A Policy is an ordered set of rules deciding which destinations clients may connect to.
+// A nil *Policy allows all destinations.
+type Policy struct {
+	rules []rule
+	allow bool
+}
+
+// Check returns an error wrapping ErrDenied if a Destination is not allowed,
+// the error includes the reason for the rejection.
+func (p *Policy) Check(d Destination) error {
+	if p == nil {
+		return nil
+	}
+	if r := p.match(d); r != nil {
+		if r.allow {
+			return nil
+		}
+		return fmt.Errorf("%w: %v denied by rule %v", ErrDenied, d, r.name)
+	}
+	if p.allow {
+		return nil
+	}
+	return fmt.Errorf("%w: %v matched no rule", ErrDenied, d)
+}
+
+// Rule returns the name of the first rule matching a Destination, or "" if none does.
+func (p *Policy) Rule(d Destination) string {
+	if p == nil {
+		return ""
+	}
+	if r := p.match(d); r != nil {
+		return r.name
+	}
+	return ""
+}
+
+// match returns the first rule matching a Destination, if any.
+func (p *Policy) match(d Destination) *rule {
+	for i := range p.rules {
+		if p.rules[i].m.Match(d) {
+			return &p.rules[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/destinationpb"
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/policypb"
+)
+
+func TestCheck(t *testing.T) {
+	pb := &policypb.DestinationPolicy{
+		Rules: []*policypb.DestinationPolicy_Rule{
+			{
+				Name:   "ops-bastion",
+				Action: policypb.DestinationPolicy_ALLOW,
+				Match: &destinationpb.DestinationMatcher{
+					Host:       &destinationpb.DestinationMatcher_HostGlob{HostGlob: "bastion.example.org"},
+					Identities: []string{"*@ops.example.org"},
+				},
+			},
+			{
+				Name:   "no-bastion",
+				Action: policypb.DestinationPolicy_DENY,
+				Match: &destinationpb.DestinationMatcher{
+					Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "bastion.example.org"},
+				},
+			},
+			{
+				Action: policypb.DestinationPolicy_ALLOW,
+				Match: &destinationpb.DestinationMatcher{
+					Host:  &destinationpb.DestinationMatcher_HostGlob{HostGlob: "*.example.org"},
+					Ports: []*destinationpb.PortRange{{Start: 22}},
+				},
+			},
+		},
+	}
+	p, err := New(pb)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	testdata := []struct {
+		d  Destination
+		ok bool
+	}{
+		{
+			d:  Destination{Host: "ssh.example.org", Port: "22"},
+			ok: true,
+		},
+		{
+			d: Destination{Host: "bastion.example.org", Port: "22"},
+		},
+		{
+			d:  Destination{Host: "bastion.example.org", Port: "22", Identity: "user@ops.example.org"},
+			ok: true,
+		},
+		{
+			d: Destination{Host: "bastion.example.org", Port: "22", Identity: "user@example.org"},
+		},
+		{
+			d: Destination{Host: "bastion.example.org.", Port: "22"},
+		},
+		{
+			d: Destination{Host: "Bastion.Example.org.", Port: "22"},
+		},
+		{
+			d:  Destination{Host: "bastion.example.org.", Port: "22", Identity: "user@ops.example.org"},
+			ok: true,
+		},
+		{
+			d: Destination{Host: "ssh.example.org", Port: "25"},
+		},
+		{
+			d: Destination{Host: "localhost", Port: "22"},
+		},
+	}
+	for _, tt := range testdata {
+		err := p.Check(tt.d)
+		if !tt.ok {
+			if !errors.Is(err, ErrDenied) {
+				t.Errorf("Check(%v) error = %v, want %v", tt.d, err, ErrDenied)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Check(%v) error = %v", tt.d, err)
+		}
+	}
+}
+
+func TestRule(t *testing.T) {
+	pb := &policypb.DestinationPolicy{
+		Rules: []*policypb.DestinationPolicy_Rule{
+			{
+				Name:   "bastion",
+				Action: policypb.DestinationPolicy_DENY,
+				Match: &destinationpb.DestinationMatcher{
+					Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "bastion.example.org"},
+				},
+			},
+			{
+				Action: policypb.DestinationPolicy_ALLOW,
+				Match: &destinationpb.DestinationMatcher{
+					Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "*.example.org"},
+				},
+			},
+		},
+	}
+	p, err := New(pb)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	testdata := map[string]string{
+		"bastion.example.org.": "bastion",
+		"ssh.example.org":      "#1",
+		"localhost":            "",
+	}
+	for host, want := range testdata {
+		d := Destination{Host: host, Port: "22"}
+		if got := p.Rule(d); got != want {
+			t.Errorf("Rule(%v) = %q, want %q", d, got, want)
+		}
+	}
+	var nilPolicy *Policy
+	if got := nilPolicy.Rule(Destination{Host: "localhost", Port: "22"}); got != "" {
+		t.Errorf("Rule() nil policy = %q, want \"\"", got)
+	}
+}
+
+func TestCheck_DefaultAction(t *testing.T) {
+	d := Destination{Host: "localhost", Port: "22"}
+	var p *Policy
+	if err := p.Check(d); err != nil {
+		t.Errorf("Check(%v) nil policy error = %v", d, err)
+	}
+	p, err := New(&policypb.DestinationPolicy{DefaultAction: policypb.DestinationPolicy_ALLOW})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Check(d); err != nil {
+		t.Errorf("Check(%v) default allow error = %v", d, err)
+	}
+	p, err = New(&policypb.DestinationPolicy{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Check(d); !errors.Is(err, ErrDenied) {
+		t.Errorf("Check(%v) default deny error = %v, want %v", d, err, ErrDenied)
+	}
+}
+
+func TestNew_Failures(t *testing.T) {
+	testdata := []*policypb.DestinationPolicy{
+		// Missing action.
+		{
+			Rules: []*policypb.DestinationPolicy_Rule{{}},
+		},
+		// Bad matcher.
+		{
+			Rules: []*policypb.DestinationPolicy_Rule{
+				{
+					Action: policypb.DestinationPolicy_ALLOW,
+					Match: &destinationpb.DestinationMatcher{
+						Ports: []*destinationpb.PortRange{{Start: -1}},
+					},
+				},
+			},
+		},
+	}
+	for i, tt := range testdata {
+		if _, err := New(tt); err == nil {
+			t.Errorf("New(%v) error = nil", i)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package connect
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	rrequest "github.com/hazaelsan/ssh-relay/relay/request"
+)
+
+// secretSize is the size in bytes of session secrets.
+const secretSize = 32
+
+var (
+	// ErrBindingMismatch is returned when a /connect request doesn't come from the client that created the session.
+	ErrBindingMismatch = errors.New("session binding mismatch")
+
+	// ErrAttached is returned when a session already has a WebSocket attached.
+	ErrAttached = errors.New("session already attached")
+
+	// ErrAttachTimeout is returned when attaching to a session after its attach timeout.
+	ErrAttachTimeout = errors.New("session not attached in time")
+)
+
+// A Client identifies the client making a request.
+type Client struct {
+	// Origin is the client origin, from the origin cookie.
+	Origin string
+
+	// IP is the client IP address.
+	IP string
+
+	// Identity is the client identity from its TLS certificate, if any.
+	Identity string
+}
+
+// ClientFromRequest returns the Client making a request, given its origin and identity.
+func ClientFromRequest(req *http.Request, origin, identity string) Client {
+	return Client{Origin: origin, IP: rrequest.ClientIP(req), Identity: identity}
+}
+
+func (c Client) String() string {
+	if c.Identity != "" {
+		return fmt.Sprintf("%v (origin %v, identity %v)", c.IP, c.Origin, c.Identity)
+	}
+	return fmt.Sprintf("%v (origin %v)", c.IP, c.Origin)
+}
+
+// NewBinding creates a *Binding for the client creating a session, with a random secret.
+func NewBinding(c Client) (*Binding, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("rand.Read() error: %w", err)
+	}
+	return &Binding{
+		client: c,
+		secret: base64.RawURLEncoding.EncodeToString(b),
+	}, nil
+}
+
+// A Binding ties a session to the client that created it via /proxy.
+// Only requests from the same client, presenting the session secret, may attach to the session,
+// and only one at a time.
+type Binding struct {
+	client Client
+	secret string
+
+	mu       sync.Mutex
+	attached bool
+	timer    *time.Timer // Attach timeout, nil once attached.
+	expired  bool
+}
+
+// SID returns the value /proxy returns to clients for a session, sent back as the sid in /connect requests.
+// It carries the session secret, and must not be logged.
+func (b *Binding) SID(sid uuid.UUID) string {
+	return sid.String() + "." + b.secret
+}
+
+// Check returns an error wrapping ErrBindingMismatch if a request doesn't match the binding,
+// the error describes the mismatch but never includes the secret.
+func (b *Binding) Check(r *Request, c Client) error {
+	if subtle.ConstantTimeCompare([]byte(r.secret), []byte(b.secret)) != 1 {
+		return fmt.Errorf("%w: bad session secret", ErrBindingMismatch)
+	}
+	if c.Origin != b.client.Origin {
+		return fmt.Errorf("%w: origin %v, want %v", ErrBindingMismatch, c.Origin, b.client.Origin)
+	}
+	if c.IP != b.client.IP {
+		return fmt.Errorf("%w: client IP %v, want %v", ErrBindingMismatch, c.IP, b.client.IP)
+	}
+	if c.Identity != b.client.Identity {
+		return fmt.Errorf("%w: identity %q, want %q", ErrBindingMismatch, c.Identity, b.client.Identity)
+	}
+	return nil
+}
+
+// SetAttachTimeout calls expire if no WebSocket attaches to the session within d,
+// must be called before the session is returned to the client.
+func (b *Binding) SetAttachTimeout(d time.Duration, expire func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timer = time.AfterFunc(d, func() {
+		b.mu.Lock()
+		if b.timer == nil {
+			// Attached just as the timer fired.
+			b.mu.Unlock()
+			return
+		}
+		b.expired = true
+		b.mu.Unlock()
+		expire()
+	})
+}
+
+// Attach marks the session as attached, stopping the attach timeout.
+// Returns ErrAttached if it already is, ErrAttachTimeout if the attach timeout expired.
+// The returned func detaches the session.
+func (b *Binding) Attach() (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.expired:
+		return nil, ErrAttachTimeout
+	case b.attached:
+		return nil, ErrAttached
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.attached = true
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.attached = false
+	}, nil
+}
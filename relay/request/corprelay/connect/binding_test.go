@@ -0,0 +1,161 @@
+package connect
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+var client = Client{Origin: "chrome-extension://foo", IP: "192.0.2.1", Identity: "user@example.org"}
+
+func TestClientFromRequest(t *testing.T) {
+	testdata := []struct {
+		addr string
+		want Client
+	}{
+		{
+			addr: "192.0.2.1:40000",
+			want: Client{Origin: "chrome-extension://foo", IP: "192.0.2.1"},
+		},
+		{
+			addr: "[2001:db8::1]:40000",
+			want: Client{Origin: "chrome-extension://foo", IP: "2001:db8::1"},
+		},
+		{
+			addr: "pipe",
+			want: Client{Origin: "chrome-extension://foo", IP: "pipe"},
+		},
+	}
+	for _, tt := range testdata {
+		req := httptest.NewRequest("GET", "/connect", nil)
+		req.RemoteAddr = tt.addr
+		if diff := pretty.Compare(ClientFromRequest(req, "chrome-extension://foo", ""), tt.want); diff != "" {
+			t.Errorf("ClientFromRequest(%v) diff (-got +want):\n%v", tt.addr, diff)
+		}
+	}
+}
+
+func TestBinding_Check(t *testing.T) {
+	b, err := NewBinding(client)
+	if err != nil {
+		t.Fatalf("NewBinding() error = %v", err)
+	}
+	other, err := NewBinding(client)
+	if err != nil {
+		t.Fatalf("NewBinding() error = %v", err)
+	}
+	if b.secret == other.secret {
+		t.Fatalf("NewBinding() secrets match: %v", b.secret)
+	}
+	got, secret, _ := strings.Cut(b.SID(sid), ".")
+	if got != sid.String() || secret != b.secret {
+		t.Errorf("SID() = %v.%v, want %v.%v", got, secret, sid, b.secret)
+	}
+	testdata := []struct {
+		name   string
+		secret string
+		c      Client
+		ok     bool
+	}{
+		{
+			name:   "match",
+			secret: b.secret,
+			c:      client,
+			ok:     true,
+		},
+		{
+			name:   "bad secret",
+			secret: other.secret,
+			c:      client,
+		},
+		{
+			name:   "other origin",
+			secret: b.secret,
+			c:      Client{Origin: "chrome-extension://bar", IP: client.IP, Identity: client.Identity},
+		},
+		{
+			name:   "other ip",
+			secret: b.secret,
+			c:      Client{Origin: client.Origin, IP: "192.0.2.2", Identity: client.Identity},
+		},
+		{
+			name:   "other identity",
+			secret: b.secret,
+			c:      Client{Origin: client.Origin, IP: client.IP, Identity: "admin@example.org"},
+		},
+		{
+			name:   "no identity",
+			secret: b.secret,
+			c:      Client{Origin: client.Origin, IP: client.IP},
+		},
+	}
+	for _, tt := range testdata {
+		err := b.Check(&Request{SID: sid, secret: tt.secret}, tt.c)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("Check(%v) error = %v", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrBindingMismatch) {
+			t.Errorf("Check(%v) error = %v, want %v", tt.name, err, ErrBindingMismatch)
+		}
+		if err != nil && strings.Contains(err.Error(), b.secret) {
+			t.Errorf("Check(%v) error = %v, includes the secret", tt.name, err)
+		}
+	}
+}
+
+func TestBinding_Attach(t *testing.T) {
+	b, err := NewBinding(client)
+	if err != nil {
+		t.Fatalf("NewBinding() error = %v", err)
+	}
+	release, err := b.Attach()
+	if err != nil {
+		t.Fatalf("Attach() error = %v", err)
+	}
+	if _, err := b.Attach(); !errors.Is(err, ErrAttached) {
+		t.Errorf("Attach() error = %v, want %v", err, ErrAttached)
+	}
+	release()
+	if _, err := b.Attach(); err != nil {
+		t.Errorf("Attach() error = %v", err)
+	}
+}
+
+func TestBinding_SetAttachTimeout(t *testing.T) {
+	b, err := NewBinding(client)
+	if err != nil {
+		t.Fatalf("NewBinding() error = %v", err)
+	}
+	expired := make(chan struct{})
+	b.SetAttachTimeout(10*time.Millisecond, func() { close(expired) })
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("SetAttachTimeout() did not expire")
+	}
+	if _, err := b.Attach(); !errors.Is(err, ErrAttachTimeout) {
+		t.Errorf("Attach() error = %v, want %v", err, ErrAttachTimeout)
+	}
+
+	// Attaching stops the timeout.
+	if b, err = NewBinding(client); err != nil {
+		t.Fatalf("NewBinding() error = %v", err)
+	}
+	b.SetAttachTimeout(10*time.Millisecond, func() { t.Error("SetAttachTimeout() expired after Attach()") })
+	release, err := b.Attach()
+	if err != nil {
+		t.Fatalf("Attach() error = %v", err)
+	}
+	release()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := b.Attach(); err != nil {
+		t.Errorf("Attach() error = %v", err)
+	}
+}
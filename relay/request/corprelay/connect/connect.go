@@ -3,20 +3,28 @@ package connect
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/hazaelsan/ssh-relay/request"
 )
 
 // New creates a *Request from an *http.Request.
+// The sid is in <SID>.<secret> format, as returned by Binding.SID.
 // TODO: Session resumption is not supported, pos/try MUST be 0.
 func New(req *http.Request) (*Request, error) {
 	var err error
 	r := new(Request)
-	r.SID, err = uuid.Parse(req.URL.Query().Get("sid"))
+	sid, secret, ok := strings.Cut(req.URL.Query().Get("sid"), ".")
+	if !ok || secret == "" {
+		return nil, request.ErrBadRequest
+	}
+	r.SID, err = uuid.Parse(sid)
 	if err != nil {
 		return nil, request.ErrBadRequest
 	}
+	r.secret = secret
 	r.ack, err = request.Uint(req, "ack")
 	if err != nil || r.ack != 0 {
 		return nil, request.ErrBadRequest
@@ -32,12 +40,24 @@ func New(req *http.Request) (*Request, error) {
 	return r, nil
 }
 
+// Redact returns a /connect URL with the sid secret removed, for logging.
+func Redact(u *url.URL) string {
+	q := u.Query()
+	if sid, _, ok := strings.Cut(q.Get("sid"), "."); ok {
+		q.Set("sid", sid+".REDACTED")
+	}
+	r := *u
+	r.RawQuery = q.Encode()
+	return r.String()
+}
+
 // A Request is a normalized request to /connect.
 type Request struct {
-	SID uuid.UUID
-	ack uint
-	pos uint
-	try uint
+	SID    uuid.UUID
+	secret string
+	ack    uint
+	pos    uint
+	try    uint
 }
 
 func (r Request) String() string {
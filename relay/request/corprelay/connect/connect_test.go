@@ -2,6 +2,7 @@ package connect
 
 import (
 	"net/http"
+	"net/url"
 	"testing"
 
 	"github.com/google/uuid"
@@ -19,36 +20,45 @@ func TestNew(t *testing.T) {
 	}{
 		{
 			name: "good",
-			uri:  "/connect?ack=0&pos=0&try=1&sid=" + sid.String(),
+			uri:  "/connect?ack=0&pos=0&try=1&sid=" + sid.String() + ".secret",
 			want: &Request{
-				SID: sid,
-				try: 1,
+				SID:    sid,
+				secret: "secret",
+				try:    1,
 			},
 			ok: true,
 		},
 		{
 			name: "non-zero ack",
-			uri:  "/connect?ack=1&pos=0&try=1&sid=" + sid.String(),
+			uri:  "/connect?ack=1&pos=0&try=1&sid=" + sid.String() + ".secret",
 		},
 		{
 			name: "non-zero pos",
-			uri:  "/connect?ack=0&pos=1&try=1&sid=" + sid.String(),
+			uri:  "/connect?ack=0&pos=1&try=1&sid=" + sid.String() + ".secret",
 		},
 		{
 			name: "bad ack",
-			uri:  "/connect?ack=foo&pos=0&try=1&sid=" + sid.String(),
+			uri:  "/connect?ack=foo&pos=0&try=1&sid=" + sid.String() + ".secret",
 		},
 		{
 			name: "bad pos",
-			uri:  "/connect?ack=0&pos=foo&try=1&sid=" + sid.String(),
+			uri:  "/connect?ack=0&pos=foo&try=1&sid=" + sid.String() + ".secret",
 		},
 		{
 			name: "bad try",
-			uri:  "/connect?ack=0&pos=0&try=foo&sid=" + sid.String(),
+			uri:  "/connect?ack=0&pos=0&try=foo&sid=" + sid.String() + ".secret",
 		},
 		{
 			name: "bad sid",
-			uri:  "/connect?ack=0&pos=0&try=1&sid=foobar",
+			uri:  "/connect?ack=0&pos=0&try=1&sid=foobar.secret",
+		},
+		{
+			name: "missing secret",
+			uri:  "/connect?ack=0&pos=0&try=1&sid=" + sid.String(),
+		},
+		{
+			name: "empty secret",
+			uri:  "/connect?ack=0&pos=0&try=1&sid=" + sid.String() + ".",
 		},
 	}
 	for _, tt := range testdata {
@@ -75,3 +85,36 @@ func TestNew(t *testing.T) {
 		}
 	}
 }
+
+func TestRedact(t *testing.T) {
+	testdata := []struct {
+		name string
+		uri  string
+		want string
+	}{
+		{
+			name: "secret",
+			uri:  "/connect?ack=0&sid=" + sid.String() + ".secret",
+			want: "/connect?ack=0&sid=" + sid.String() + ".REDACTED",
+		},
+		{
+			name: "no secret",
+			uri:  "/connect?sid=" + sid.String(),
+			want: "/connect?sid=" + sid.String(),
+		},
+		{
+			name: "no sid",
+			uri:  "/connect?ack=0",
+			want: "/connect?ack=0",
+		},
+	}
+	for _, tt := range testdata {
+		u, err := url.Parse(tt.uri)
+		if err != nil {
+			t.Fatalf("url.Parse(%v) error = %v", tt.name, err)
+		}
+		if got := Redact(u); got != tt.want {
+			t.Errorf("Redact(%v) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,8 +2,10 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 
+	"github.com/golang/glog"
 	"github.com/gorilla/websocket"
 	"github.com/hazaelsan/ssh-relay/relay/request"
 	"github.com/hazaelsan/ssh-relay/relay/request/corprelay/connect"
@@ -12,30 +14,52 @@ import (
 	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
 )
 
-// New creates a *Handler for an HTTP request.
-func New(cfg *configpb.Config, s session.Session, cr *connect.Request, w http.ResponseWriter, r *http.Request) (*Handler, error) {
+// New creates a *Handler for an HTTP request attaching to a Session created by the client in b,
+// identity is the client identity from its TLS certificate, if any.
+func New(cfg *configpb.Config, s session.Session, b *connect.Binding, cr *connect.Request, identity string, w http.ResponseWriter, r *http.Request) (*Handler, error) {
 	h := &Handler{
 		s:  s,
+		b:  b,
 		cr: cr,
 		w:  w,
 		r:  r,
 	}
 	var err error
 	h.origin, err = request.Origin(r, cfg.OriginCookieName)
+	h.client = connect.ClientFromRequest(r, h.origin, identity)
 	return h, err
 }
 
 // A Handler is an HTTP handler for /connect requests, handles bidirectional SSH traffic.
 type Handler struct {
 	origin string
+	client connect.Client
 	s      session.Session
+	b      *connect.Binding
 	cr     *connect.Request
 	w      http.ResponseWriter
 	r      *http.Request
 }
 
 // Handle processes the /connect HTTP request, WebSocket session.
+// Requests not matching the session binding or for an already attached session are rejected and audit-logged,
+// the returned errors wrap connect.ErrBindingMismatch, connect.ErrAttached or connect.ErrAttachTimeout;
+// the Session is not affected.
 func (h *Handler) Handle() error {
+	if err := h.b.Check(h.cr, h.client); err != nil {
+		h.reject(err, http.StatusForbidden)
+		return err
+	}
+	detach, err := h.b.Attach()
+	if err != nil {
+		code := http.StatusConflict
+		if errors.Is(err, connect.ErrAttachTimeout) {
+			code = http.StatusGone
+		}
+		h.reject(err, code)
+		return err
+	}
+	defer detach()
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
 			return r.Header.Get("Origin") == h.origin
@@ -48,3 +72,9 @@ func (h *Handler) Handle() error {
 	defer ws.Close()
 	return h.s.Run(ws)
 }
+
+// reject audit-logs a rejected request and responds to the client, the reason is not disclosed.
+func (h *Handler) reject(err error, code int) {
+	glog.Warningf("AUDIT: %v: Rejected /connect from %v: %v", h.s, h.client, err)
+	http.Error(h.w, http.StatusText(code), code)
+}
@@ -0,0 +1,38 @@
+// Package reconnect represents a corp-relay-v4@google.com /v4/reconnect request to the SSH Relay.
+package reconnect
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/hazaelsan/ssh-relay/request"
+)
+
+// New creates a *Request from an *http.Request.
+func New(req *http.Request) (*Request, error) {
+	var err error
+	r := new(Request)
+	r.SID, err = uuid.Parse(req.URL.Query().Get("sid"))
+	if err != nil {
+		return nil, request.ErrBadRequest
+	}
+	ack, err := request.Uint(req, "ack")
+	if err != nil {
+		return nil, request.ErrBadRequest
+	}
+	r.Ack = uint64(ack)
+	return r, nil
+}
+
+// A Request is a normalized request to /v4/reconnect.
+type Request struct {
+	// SID is the ID of the session to resume.
+	SID uuid.UUID
+
+	// Ack is the amount of data the client has received.
+	Ack uint64
+}
+
+func (r Request) String() string {
+	return r.SID.String()
+}
@@ -0,0 +1,78 @@
+package reconnect
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+var sid = uuid.MustParse("aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee")
+
+func TestNew(t *testing.T) {
+	testdata := []struct {
+		name string
+		uri  string
+		want *Request
+		ok   bool
+	}{
+		{
+			name: "good",
+			uri:  "/v4/reconnect?ack=1234&sid=" + sid.String(),
+			want: &Request{
+				SID: sid,
+				Ack: 1234,
+			},
+			ok: true,
+		},
+		{
+			name: "zero ack",
+			uri:  "/v4/reconnect?ack=0&sid=" + sid.String(),
+			want: &Request{
+				SID: sid,
+			},
+			ok: true,
+		},
+		{
+			name: "missing ack",
+			uri:  "/v4/reconnect?sid=" + sid.String(),
+		},
+		{
+			name: "bad ack",
+			uri:  "/v4/reconnect?ack=foo&sid=" + sid.String(),
+		},
+		{
+			name: "negative ack",
+			uri:  "/v4/reconnect?ack=-1&sid=" + sid.String(),
+		},
+		{
+			name: "missing sid",
+			uri:  "/v4/reconnect?ack=0",
+		},
+		{
+			name: "bad sid",
+			uri:  "/v4/reconnect?ack=0&sid=foo",
+		},
+	}
+	for _, tt := range testdata {
+		req, err := http.NewRequest("GET", tt.uri, nil)
+		if err != nil {
+			t.Errorf("http.NewRequest(%v) error = %v", tt.name, err)
+			continue
+		}
+		got, err := New(req)
+		if err != nil {
+			if tt.ok {
+				t.Errorf("New(%v) error = %v", tt.name, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("New(%v) error = nil", tt.name)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("New(%v) diff (-got +want):\n%v", tt.name, diff)
+		}
+	}
+}
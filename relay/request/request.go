@@ -4,6 +4,7 @@ package request
 import (
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 )
@@ -28,3 +29,12 @@ func Origin(req *http.Request, name string) (string, error) {
 	}
 	return cookie.Value, nil
 }
+
+// ClientIP returns the IP address of the client making an *http.Request.
+func ClientIP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/golang/glog"
+	"github.com/hazaelsan/ssh-relay/relay/admin"
+	"github.com/hazaelsan/ssh-relay/relay/session/manager"
+	rtls "github.com/hazaelsan/ssh-relay/tls"
+	"google.golang.org/grpc"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/grpcpb"
+	"github.com/hazaelsan/ssh-relay/proto/v1/tlspb"
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/adminpb"
+)
+
+// errAdminInsecure is returned if the admin service isn't configured for mutual TLS.
+var errAdminInsecure = errors.New("admin_options requires TLS with verified client certificates")
+
+// newAdminServer creates a gRPC server for the RelayAdmin service, client certificates are mandatory.
+func newAdminServer(opts *grpcpb.GrpcOptions, mgr *manager.Manager, m admin.Maintainer) (*grpc.Server, error) {
+	cfg := opts.GetTlsConfig()
+	if cfg.GetTlsMode() == tlspb.TlsConfig_TLS_MODE_DISABLED || len(cfg.GetClientCaCerts()) == 0 {
+		return nil, errAdminInsecure
+	}
+	if cat, err := rtls.ClientAuthType(cfg.GetClientAuthType()); err != nil || cat != tls.RequireAndVerifyClientCert {
+		return nil, errAdminInsecure
+	}
+	creds, err := rtls.TransportCreds(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("tls.TransportCreds() error = %w", err)
+	}
+	srv := grpc.NewServer(grpc.Creds(creds))
+	adminpb.RegisterRelayAdminServer(srv, admin.New(mgr, m))
+	return srv, nil
+}
+
+// runAdmin serves the RelayAdmin service.
+func (r *Runner) runAdmin() error {
+	opts := r.current().cfg.GetAdminOptions()
+	addr := net.JoinHostPort(opts.GetAddr(), opts.GetPort())
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("net.Listen(%v) error = %w", addr, err)
+	}
+	glog.Infof("Admin gRPC server listening on %v", addr)
+	return r.admin.Serve(l)
+}
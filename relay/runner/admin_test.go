@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/hazaelsan/ssh-relay/relay/session/manager"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/grpcpb"
+	"github.com/hazaelsan/ssh-relay/proto/v1/tlspb"
+)
+
+func TestNewAdminServer_Insecure(t *testing.T) {
+	testdata := []struct {
+		name string
+		cfg  *tlspb.TlsConfig
+	}{
+		{
+			name: "no tls config",
+		},
+		{
+			name: "tls disabled",
+			cfg: &tlspb.TlsConfig{
+				TlsMode:       tlspb.TlsConfig_TLS_MODE_DISABLED,
+				ClientCaCerts: []string{"ca.crt"},
+			},
+		},
+		{
+			name: "no client ca",
+			cfg: &tlspb.TlsConfig{
+				CertFile: "server.crt",
+				KeyFile:  "server.key",
+			},
+		},
+		{
+			name: "optional client cert",
+			cfg: &tlspb.TlsConfig{
+				CertFile:       "server.crt",
+				KeyFile:        "server.key",
+				ClientCaCerts:  []string{"ca.crt"},
+				ClientAuthType: tlspb.TlsConfig_VERIFY_CLIENT_CERT_IF_GIVEN,
+			},
+		},
+	}
+	mgr := manager.New(0, 0, 0)
+	for _, tt := range testdata {
+		opts := &grpcpb.GrpcOptions{Port: "8023", TlsConfig: tt.cfg}
+		if _, err := newAdminServer(opts, mgr, nil); err != errAdminInsecure {
+			t.Errorf("newAdminServer(%v) error = %v, want %v", tt.name, err, errAdminInsecure)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"runtime/metrics"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hazaelsan/ssh-relay/duration"
+	"github.com/hazaelsan/ssh-relay/relay/session/manager"
+
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+const (
+	// DefaultQueueTimeout is how long requests wait in the admission queue if queue_timeout is unset.
+	DefaultQueueTimeout = 10 * time.Second
+
+	// loadSampleInterval is how often process resource usage is sampled for load shedding.
+	loadSampleInterval = time.Second
+)
+
+// errOverloaded is returned to clients requesting new sessions while load is being shed.
+var errOverloaded = errors.New("relay is overloaded")
+
+// admission builds manager.Admission from a proto config message, reserved slots are taken from maxSessions.
+// Origins are bound to auth tokens if tokens is set, they're only trusted along with an identity otherwise.
+func admission(pb *configpb.Config_Admission, maxSessions int, tokens bool) (manager.Admission, error) {
+	a := manager.Admission{
+		QueueSize:    int(pb.GetQueueSize()),
+		QueueTimeout: DefaultQueueTimeout,
+		Reserved:     int(pb.GetReservedSessions()),
+	}
+	if err := duration.FromProto(&a.QueueTimeout, pb.GetQueueTimeout()); err != nil {
+		return a, fmt.Errorf("duration.FromProto(%v) error: %w", pb.GetQueueTimeout(), err)
+	}
+	if a.Reserved < 0 || (a.Reserved > 0 && a.Reserved >= maxSessions) {
+		return a, fmt.Errorf("reserved_sessions %v not in [0, max_sessions %v)", a.Reserved, maxSessions)
+	}
+	origins := pb.GetPrivilegedOrigins()
+	identities := pb.GetPrivilegedIdentities()
+	for _, g := range identities {
+		if _, err := path.Match(g, ""); err != nil {
+			return a, fmt.Errorf("path.Match(%v) error: %w", g, err)
+		}
+	}
+	if len(origins) > 0 || len(identities) > 0 {
+		a.Privileged = func(md manager.Metadata) bool {
+			// Origins are client-supplied, only trust them if verified against the auth token or along with an identity.
+			if (tokens || md.Identity != "") && slices.Contains(origins, md.Origin) {
+				return true
+			}
+			for _, g := range identities {
+				if ok, _ := path.Match(g, md.Identity); ok {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return a, nil
+}
+
+// newLoadShedder creates a *loadShedder from a proto config message, returns nil if no thresholds are set.
+func newLoadShedder(pb *configpb.Config_Admission_LoadShedding) (*loadShedder, error) {
+	l := &loadShedder{
+		maxFiles:      pb.GetMaxOpenFiles(),
+		maxGoroutines: pb.GetMaxGoroutines(),
+		maxMemory:     pb.GetMaxMemoryBytes(),
+	}
+	if l.maxFiles <= 0 && l.maxGoroutines <= 0 && l.maxMemory <= 0 {
+		return nil, nil
+	}
+	if l.maxFiles > 0 && runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("max_open_files is not supported on %v", runtime.GOOS)
+	}
+	return l, nil
+}
+
+// A loadShedder rejects new sessions while process resource usage exceeds its thresholds.
+// Usage is sampled at most once every loadSampleInterval.
+type loadShedder struct {
+	maxFiles      int64
+	maxGoroutines int64
+	maxMemory     int64
+
+	mu      sync.Mutex
+	sampled time.Time
+	signal  string // The exceeded threshold in the last sample, if any.
+}
+
+// check returns errOverloaded if any threshold is exceeded, a nil *loadShedder never sheds load.
+func (l *loadShedder) check() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.sampled) >= loadSampleInterval {
+		l.sampled = time.Now()
+		if l.signal != "" {
+			loadShedding.With(l.signal).Set(0)
+		}
+		if l.signal = l.sample(); l.signal != "" {
+			loadShedding.With(l.signal).Set(1)
+		}
+	}
+	if l.signal == "" {
+		return nil
+	}
+	return errOverloaded
+}
+
+// sample returns the name of the first exceeded threshold, if any.
+func (l *loadShedder) sample() string {
+	if l.maxGoroutines > 0 {
+		if n := int64(runtime.NumGoroutine()); n > l.maxGoroutines {
+			glog.Warningf("Shedding load, %v goroutines > %v", n, l.maxGoroutines)
+			return "goroutines"
+		}
+	}
+	if l.maxMemory > 0 {
+		if n := memoryBytes(); n > l.maxMemory {
+			glog.Warningf("Shedding load, %v bytes of memory > %v", n, l.maxMemory)
+			return "memory"
+		}
+	}
+	if l.maxFiles > 0 {
+		n, err := openFiles()
+		if err != nil {
+			glog.Errorf("openFiles() error: %v", err)
+		} else if n > l.maxFiles {
+			glog.Warningf("Shedding load, %v open files > %v", n, l.maxFiles)
+			return "open_files"
+		}
+	}
+	return ""
+}
+
+// memoryBytes returns the memory obtained from the OS by the Go runtime and not yet returned to it.
+func memoryBytes() int64 {
+	s := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(s)
+	return int64(s[0].Value.Uint64() - s[1].Value.Uint64())
+}
+
+// openFiles returns the number of open file descriptors of the process.
+func openFiles() (int64, error) {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(fds)), nil
+}
@@ -0,0 +1,181 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/ssh-relay/relay/session/manager"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+func TestAdmission(t *testing.T) {
+	testdata := []struct {
+		name         string
+		pb           *configpb.Config_Admission
+		maxSessions  int
+		queueSize    int
+		queueTimeout time.Duration
+		reserved     int
+		tokens       bool
+		privileged   map[manager.Metadata]bool
+		ok           bool
+	}{
+		{
+			name:         "unset",
+			queueTimeout: DefaultQueueTimeout,
+			privileged:   map[manager.Metadata]bool{{Origin: "https://example.org"}: false},
+			ok:           true,
+		},
+		{
+			name: "queue",
+			pb: &configpb.Config_Admission{
+				QueueSize:    10,
+				QueueTimeout: &durationpb.Duration{Seconds: 5},
+			},
+			queueSize:    10,
+			queueTimeout: 5 * time.Second,
+			ok:           true,
+		},
+		{
+			name: "privileged",
+			pb: &configpb.Config_Admission{
+				ReservedSessions:     2,
+				PrivilegedOrigins:    []string{"https://admin.example.org"},
+				PrivilegedIdentities: []string{"*@example.org"},
+			},
+			maxSessions:  10,
+			queueTimeout: DefaultQueueTimeout,
+			reserved:     2,
+			privileged: map[manager.Metadata]bool{
+				{Origin: "https://admin.example.org", Identity: "foo@example.com"}: true,
+				{Origin: "https://admin.example.org"}:                              false,
+				{Origin: "https://example.org", Identity: "foo@example.com"}:       false,
+				{Identity: "foo@example.org"}:                                      true,
+				{Identity: "foo@example.com"}:                                      false,
+				{}:                                                                 false,
+			},
+			ok: true,
+		},
+		{
+			name: "privileged origins with auth tokens",
+			pb: &configpb.Config_Admission{
+				ReservedSessions:  2,
+				PrivilegedOrigins: []string{"https://admin.example.org"},
+			},
+			maxSessions:  10,
+			queueTimeout: DefaultQueueTimeout,
+			reserved:     2,
+			tokens:       true,
+			privileged: map[manager.Metadata]bool{
+				{Origin: "https://admin.example.org"}: true,
+				{Origin: "https://example.org"}:       false,
+				{Identity: "foo@example.org"}:         false,
+			},
+			ok: true,
+		},
+		{
+			name: "reserved without session limit",
+			pb: &configpb.Config_Admission{
+				ReservedSessions: 1,
+			},
+		},
+		{
+			name: "reserved >= max_sessions",
+			pb: &configpb.Config_Admission{
+				ReservedSessions: 2,
+			},
+			maxSessions: 2,
+		},
+		{
+			name: "bad queue_timeout",
+			pb: &configpb.Config_Admission{
+				QueueTimeout: &durationpb.Duration{Seconds: -1},
+			},
+		},
+		{
+			name: "bad identity glob",
+			pb: &configpb.Config_Admission{
+				PrivilegedIdentities: []string{"["},
+			},
+		},
+	}
+	for _, tt := range testdata {
+		a, err := admission(tt.pb, tt.maxSessions, tt.tokens)
+		if err != nil {
+			if tt.ok {
+				t.Errorf("admission(%v) error = %v", tt.name, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("admission(%v) error = nil", tt.name)
+			continue
+		}
+		if a.QueueSize != tt.queueSize {
+			t.Errorf("admission(%v) QueueSize = %v, want %v", tt.name, a.QueueSize, tt.queueSize)
+		}
+		if a.QueueTimeout != tt.queueTimeout {
+			t.Errorf("admission(%v) QueueTimeout = %v, want %v", tt.name, a.QueueTimeout, tt.queueTimeout)
+		}
+		if a.Reserved != tt.reserved {
+			t.Errorf("admission(%v) Reserved = %v, want %v", tt.name, a.Reserved, tt.reserved)
+		}
+		for md, want := range tt.privileged {
+			if got := a.Privileged != nil && a.Privileged(md); got != want {
+				t.Errorf("admission(%v) Privileged(%+v) = %v, want %v", tt.name, md, got, want)
+			}
+		}
+	}
+}
+
+func TestLoadShedder(t *testing.T) {
+	testdata := []struct {
+		name string
+		pb   *configpb.Config_Admission_LoadShedding
+		want error
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name: "below thresholds",
+			pb: &configpb.Config_Admission_LoadShedding{
+				MaxGoroutines:  1 << 20,
+				MaxMemoryBytes: 1 << 40,
+			},
+		},
+		{
+			name: "goroutines",
+			pb: &configpb.Config_Admission_LoadShedding{
+				MaxGoroutines: 1,
+			},
+			want: errOverloaded,
+		},
+		{
+			name: "memory",
+			pb: &configpb.Config_Admission_LoadShedding{
+				MaxMemoryBytes: 1,
+			},
+			want: errOverloaded,
+		},
+		{
+			name: "open files",
+			pb: &configpb.Config_Admission_LoadShedding{
+				MaxOpenFiles: 1,
+			},
+			want: errOverloaded,
+		},
+	}
+	for _, tt := range testdata {
+		l, err := newLoadShedder(tt.pb)
+		if err != nil {
+			t.Errorf("newLoadShedder(%v) error = %v", tt.name, err)
+			continue
+		}
+		if err := l.check(); err != tt.want {
+			t.Errorf("check(%v) error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
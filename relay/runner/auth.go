@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+	"github.com/hazaelsan/ssh-relay/relay/request"
+	"github.com/hazaelsan/ssh-relay/token"
+)
+
+// errUnauthorized is returned to clients without a valid auth token.
+var errUnauthorized = errors.New("unauthorized")
+
+// authorize verifies the client's auth token, if tokens are enabled, returns the token claims.
+// Rejections are always logged, errors returned to clients wrap errUnauthorized.
+func (r *Runner) authorize(req *http.Request) (*token.Claims, error) {
+	st := r.current()
+	if st.verifier == nil {
+		return nil, nil
+	}
+	c, err := verifyToken(st, req)
+	if err != nil {
+		glog.Warningf("Rejected request from %v: %v", req.RemoteAddr, err)
+		return nil, fmt.Errorf("verifyToken() error: %w", errUnauthorized)
+	}
+	glog.V(2).Infof("Authorized %q from %v", c.Identity, req.RemoteAddr)
+	return c, nil
+}
+
+// verifyToken verifies the auth token cookie is valid and bound to the client origin.
+func verifyToken(st *settings, req *http.Request) (*token.Claims, error) {
+	origin, err := request.Origin(req, st.cfg.OriginCookieName)
+	if err != nil {
+		return nil, fmt.Errorf("request.Origin(%v) error: %w", st.cfg.OriginCookieName, err)
+	}
+	name := st.cfg.GetAuthToken().GetCookie().GetName()
+	cookie, err := req.Cookie(name)
+	if err != nil {
+		return nil, fmt.Errorf("req.Cookie(%v) error: %w", name, err)
+	}
+	c, err := st.verifier.Verify(cookie.Value)
+	if err != nil {
+		return nil, fmt.Errorf("Verify() error: %w", err)
+	}
+	if c.Origin != origin {
+		return nil, fmt.Errorf("token for %q bound to origin %v, got %v", c.Identity, c.Origin, origin)
+	}
+	return c, nil
+}
+
+// checkClaims returns an error wrapping policy.ErrDenied if the auth token doesn't allow connecting to a destination.
+func checkClaims(c *token.Claims, d policy.Destination) error {
+	if c == nil || len(c.Destinations) == 0 {
+		return nil
+	}
+	for _, td := range c.Destinations {
+		m, err := policy.NewMatcher(td.Proto())
+		if err != nil {
+			return fmt.Errorf("%w: bad destination in token for %q: %w", policy.ErrDenied, c.Identity, err)
+		}
+		if m.Match(d) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v not allowed by token for %q", policy.ErrDenied, d, c.Identity)
+}
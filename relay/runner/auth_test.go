@@ -0,0 +1,170 @@
+package runner
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+	"github.com/hazaelsan/ssh-relay/token"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/cookiepb"
+	"github.com/hazaelsan/ssh-relay/proto/v1/tokenpb"
+)
+
+// tokenOptions returns TokenOptions with a single HMAC key.
+func tokenOptions(t *testing.T) *tokenpb.TokenOptions {
+	t.Helper()
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte(strings.Repeat("s", 32)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &tokenpb.TokenOptions{
+		Cookie: &cookiepb.Cookie{
+			Name:   "token",
+			MaxAge: &durationpb.Duration{Seconds: 60},
+		},
+		Keys: []*tokenpb.TokenOptions_Key{
+			{
+				Id:  "key",
+				Key: &tokenpb.TokenOptions_Key_HmacSecretFile{HmacSecretFile: secret},
+			},
+		},
+		SigningKeyId: "key",
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	opts := tokenOptions(t)
+	s, err := token.NewSigner(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := s.Sign(token.Claims{Identity: "foo", Origin: "chrome-extension://foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherTok, err := s.Sign(token.Claims{Identity: "foo", Origin: "chrome-extension://bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testdata := []struct {
+		name    string
+		cookies []*http.Cookie
+		ok      bool
+	}{
+		{
+			name:    "good",
+			cookies: []*http.Cookie{originCookie, {Name: "token", Value: tok}},
+			ok:      true,
+		},
+		{
+			name:    "missing token",
+			cookies: []*http.Cookie{originCookie},
+		},
+		{
+			name:    "bad token",
+			cookies: []*http.Cookie{originCookie, {Name: "token", Value: tok + "x"}},
+		},
+		{
+			name:    "origin mismatch",
+			cookies: []*http.Cookie{originCookie, {Name: "token", Value: otherTok}},
+		},
+		{
+			name:    "missing origin",
+			cookies: []*http.Cookie{{Name: "token", Value: tok}},
+		},
+	}
+	r := newRunner()
+	st := r.current()
+	st.cfg.AuthToken = opts
+	if st.verifier, err = token.NewVerifier(opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range testdata {
+		req := httptest.NewRequest("GET", "/proxy", nil)
+		for _, c := range tt.cookies {
+			req.AddCookie(c)
+		}
+		c, err := r.authorize(req)
+		if err != nil {
+			if tt.ok {
+				t.Errorf("authorize(%v) error = %v", tt.name, err)
+			} else if !errors.Is(err, errUnauthorized) {
+				t.Errorf("authorize(%v) error = %v, want %v", tt.name, err, errUnauthorized)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("authorize(%v) error = nil", tt.name)
+			continue
+		}
+		if c.Identity != "foo" {
+			t.Errorf("authorize(%v) identity = %v, want foo", tt.name, c.Identity)
+		}
+	}
+}
+
+func TestAuthorize_Disabled(t *testing.T) {
+	r := newRunner()
+	req := httptest.NewRequest("GET", "/proxy", nil)
+	if c, err := r.authorize(req); c != nil || err != nil {
+		t.Errorf("authorize() = %v, %v, want nil, nil", c, err)
+	}
+}
+
+func TestCheckClaims(t *testing.T) {
+	c := &token.Claims{
+		Destinations: []token.Destination{
+			{
+				HostGlob: "*.example.org",
+				Ports:    []token.PortRange{{Start: 22}},
+			},
+		},
+	}
+	testdata := []struct {
+		c  *token.Claims
+		d  policy.Destination
+		ok bool
+	}{
+		{
+			d:  policy.Destination{Host: "localhost", Port: "25"},
+			ok: true,
+		},
+		{
+			c:  &token.Claims{},
+			d:  policy.Destination{Host: "localhost", Port: "25"},
+			ok: true,
+		},
+		{
+			c:  c,
+			d:  policy.Destination{Host: "ssh.example.org", Port: "22"},
+			ok: true,
+		},
+		{
+			c: c,
+			d: policy.Destination{Host: "ssh.example.org", Port: "25"},
+		},
+		{
+			c: c,
+			d: policy.Destination{Host: "localhost", Port: "22"},
+		},
+	}
+	for _, tt := range testdata {
+		err := checkClaims(tt.c, tt.d)
+		if !tt.ok {
+			if !errors.Is(err, policy.ErrDenied) {
+				t.Errorf("checkClaims(%v) error = %v, want %v", tt.d, err, policy.ErrDenied)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("checkClaims(%v) error = %v", tt.d, err)
+		}
+	}
+}
@@ -1,30 +1,50 @@
 package runner
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 
 	"github.com/golang/glog"
+	"github.com/hazaelsan/ssh-relay/relay/policy"
 	rrequest "github.com/hazaelsan/ssh-relay/relay/request"
 	"github.com/hazaelsan/ssh-relay/relay/request/corprelay/connect"
 	"github.com/hazaelsan/ssh-relay/relay/request/corprelay/connect/handler"
 	"github.com/hazaelsan/ssh-relay/relay/request/corprelay/proxy"
+	"github.com/hazaelsan/ssh-relay/relay/session/manager"
 	"github.com/hazaelsan/ssh-relay/request"
 	"github.com/hazaelsan/ssh-relay/session"
 )
 
 // connectHandle handles /connect requests.
 // WebSocket session, handles bidirectional traffic.
+// Only the client that created the session may attach to it, see connect.Binding,
+// and only if its destination is still allowed; rejected requests are audit-logged.
 func (r *Runner) connectHandle(w http.ResponseWriter, req *http.Request) {
 	cr, err := connect.New(req)
 	if err != nil {
 		if glog.V(1) {
-			glog.Errorf("connect.New(%v) error: %v", req, err)
+			glog.Errorf("connect.New(%v) error: %v", connect.Redact(req.URL), err)
 		}
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	origin, err := rrequest.Origin(req, r.current().cfg.OriginCookieName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	claims, err := r.authorize(req)
+	if err != nil {
+		http.Error(w, errUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+	id, err := r.identify(req)
+	if err != nil {
+		http.Error(w, errUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
 	s, err := r.mgr.Get(cr.SID)
 	if err != nil {
 		if glog.V(1) {
@@ -33,17 +53,31 @@ func (r *Runner) connectHandle(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, request.ErrBadRequest.Error(), http.StatusBadRequest)
 		return
 	}
-	defer func() {
-		if err := r.mgr.Delete(s.SID()); err != nil && glog.V(1) {
-			glog.Errorf("mgr.Delete(%v) error: %v", s, err)
-		}
-	}()
-	h, err := handler.New(r.cfg, s, cr, w, req)
+	b, ok := r.bindings.Load(cr.SID)
+	if !ok {
+		glog.Warningf("AUDIT: %v: Rejected /connect from %v: session not created via /proxy", s, req.RemoteAddr)
+		http.Error(w, request.ErrBadRequest.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := r.checkSessionDestination(cr.SID, origin, id, claims); err != nil {
+		glog.Warningf("AUDIT: %v: Rejected /connect from %v: %v", s, req.RemoteAddr, err)
+		http.Error(w, policy.ErrDenied.Error(), http.StatusForbidden)
+		return
+	}
+	h, err := handler.New(r.current().cfg, s, b.(*connect.Binding), cr, id, w, req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := h.Handle(); err != nil {
+	err = h.Handle()
+	if errors.Is(err, connect.ErrBindingMismatch) || errors.Is(err, connect.ErrAttached) || errors.Is(err, connect.ErrAttachTimeout) {
+		// Rejected before attaching, the session is left alone.
+		return
+	}
+	if err := r.mgr.Delete(s.SID()); err != nil && glog.V(1) {
+		glog.Errorf("mgr.Delete(%v) error: %v", s, err)
+	}
+	if err != nil {
 		glog.Error(err)
 	}
 }
@@ -51,32 +85,98 @@ func (r *Runner) connectHandle(w http.ResponseWriter, req *http.Request) {
 // proxyHandle handles /proxy requests.
 // Sets up the SSH connection and returns the SID to the client.
 func (r *Runner) proxyHandle(w http.ResponseWriter, req *http.Request) {
+	if err := r.admit(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	pr, err := proxy.New(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	origin, err := rrequest.Origin(req, r.cfg.OriginCookieName)
+	origin, err := rrequest.Origin(req, r.current().cfg.OriginCookieName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	claims, err := r.authorize(req)
+	if err != nil {
+		http.Error(w, errUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+	id, err := r.identify(req)
+	if err != nil {
+		http.Error(w, errUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+	dst := policy.Destination{Host: pr.Host, Port: pr.Port, Origin: origin, Identity: id}
+	if err := r.checkDestination(dst, claims); err != nil {
+		http.Error(w, policy.ErrDenied.Error(), http.StatusForbidden)
+		return
+	}
 	addr := net.JoinHostPort(pr.Host, pr.Port)
-	ssh, err := net.Dial("tcp", addr)
+	md := manager.Metadata{Destination: addr, Origin: origin, Identity: id, ClientIP: rrequest.ClientIP(req)}
+	res, err := r.mgr.Reserve(req.Context(), md)
+	if err != nil {
+		http.Error(w, err.Error(), r.sessionStatus(w, err))
+		return
+	}
+	defer res.Release()
+	ssh, code, err := r.dial(req.Context(), dst)
 	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	s, err := res.New(ssh, session.CorpRelay)
+	if err != nil {
+		ssh.Close()
+		http.Error(w, err.Error(), r.sessionStatus(w, err))
+		return
+	}
+	if err := r.sendProxyHeader(req, ssh, dst, s, claims); err != nil {
+		s.Close()
 		if glog.V(1) {
-			glog.Errorf("Dial(%v) error: %v", addr, err)
+			glog.Errorf("%v: sendProxyHeader() error: %v", s, err)
 		}
-		http.Error(w, "connection error", http.StatusBadGateway)
+		http.Error(w, errConnection.Error(), http.StatusBadGateway)
 		return
 	}
-	s, err := r.mgr.New(ssh, session.CorpRelay)
+	b, err := r.bind(s, connect.ClientFromRequest(req, origin, id))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		s.Close()
+		glog.Errorf("%v: bind() error: %v", s, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 	glog.V(4).Infof("%v: Connected to %v", s, addr)
 	w.Header().Add("Access-Control-Allow-Origin", origin)
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
-	fmt.Fprint(w, s.SID())
+	fmt.Fprint(w, b.SID(s.SID()))
+}
+
+// bind binds a session to the client that created it, the binding is dropped once the session terminates.
+// Sessions no WebSocket attaches to within the attach timeout are closed.
+func (r *Runner) bind(s session.Session, c connect.Client) (*connect.Binding, error) {
+	b, err := connect.NewBinding(c)
+	if err != nil {
+		return nil, err
+	}
+	if d := r.current().attachTimeout; d > 0 {
+		b.SetAttachTimeout(d, func() {
+			select {
+			case <-s.Done():
+				return
+			default:
+			}
+			glog.Warningf("%v: Closing orphaned session, not attached within %v", s, d)
+			orphanedSessions.With(s.Version().String()).Inc()
+			s.Close()
+		})
+	}
+	r.bindings.Store(s.SID(), b)
+	go func() {
+		<-s.Done()
+		r.bindings.Delete(s.SID())
+	}()
+	return b, nil
 }
@@ -13,11 +13,16 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/hazaelsan/ssh-relay/relay/dialer"
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+	"github.com/hazaelsan/ssh-relay/relay/request/corprelay/connect"
 	"github.com/hazaelsan/ssh-relay/relay/session/manager"
 	"github.com/hazaelsan/ssh-relay/session"
 	"github.com/kylelemons/godebug/pretty"
 
+	"github.com/hazaelsan/ssh-relay/proto/v1/destinationpb"
 	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/policypb"
 )
 
 const (
@@ -54,21 +59,59 @@ func listener(t *testing.T, done <-chan struct{}) string {
 }
 
 func newRunner() *Runner {
-	return &Runner{
+	// Tests connect to local SSH listeners.
+	d, err := dialer.New(&configpb.Config_ClientOptions{
+		NetworkFilter: &configpb.Config_ClientOptions_NetworkFilter{
+			DisableDefaultDeny: true,
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	r := &Runner{
+		mgr: manager.New(1, maxAge, 0),
+	}
+	r.settings.Store(&settings{
 		cfg: &configpb.Config{
 			OriginCookieName: "origin",
 		},
-		mgr: manager.New(1, maxAge),
+		dialer:          d,
+		identitySources: defaultIdentitySources,
+	})
+	return r
+}
+
+// newPolicy creates a *policy.Policy only allowing connections to example.org.
+func newPolicy(t *testing.T) *policy.Policy {
+	t.Helper()
+	p, err := policy.New(&policypb.DestinationPolicy{
+		Rules: []*policypb.DestinationPolicy_Rule{
+			{
+				Action: policypb.DestinationPolicy_ALLOW,
+				Match: &destinationpb.DestinationMatcher{
+					Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "example.org"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
+	return p
 }
 
-func newSSH(r *Runner) (net.Conn, session.Session, error) {
+// newSSH creates a session bound to a local client, returns the sid clients connect with.
+func newSSH(r *Runner) (net.Conn, session.Session, string, error) {
 	a, b := net.Pipe()
-	s, err := r.mgr.New(b, session.CorpRelay)
+	s, err := r.mgr.New(b, session.CorpRelay, manager.Metadata{Destination: "localhost:22"})
+	if err != nil {
+		return nil, nil, "", err
+	}
+	bd, err := r.bind(s, connect.Client{Origin: originCookie.Value, IP: "127.0.0.1"})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, "", err
 	}
-	return a, s, nil
+	return a, s, bd.SID(s.SID()), nil
 }
 
 func testConnectHandle(r *Runner, req *http.Request) *http.Response {
@@ -94,11 +137,10 @@ func wsReq(url string) (int, []byte, error) {
 
 func TestConnectHandle(t *testing.T) {
 	r := newRunner()
-	conn, s, err := newSSH(r)
+	conn, _, sid, err := newSSH(r)
 	if err != nil {
 		t.Fatalf("newSSH() error = %v", err)
 	}
-	sid := s.SID().String()
 
 	// Failure modes.
 	testdata := []struct {
@@ -115,7 +157,7 @@ func TestConnectHandle(t *testing.T) {
 			wantCode: http.StatusBadRequest,
 		},
 		{
-			url:      fmt.Sprintf("/connect?sid=%v&ack=0&pos=0&try=1", dummySID),
+			url:      fmt.Sprintf("/connect?sid=%v.secret&ack=0&pos=0&try=1", dummySID),
 			wantCode: http.StatusBadRequest,
 		},
 		// Bad ack.
@@ -159,7 +201,7 @@ func TestConnectHandle(t *testing.T) {
 	wantMsg := []byte{0, 0, 0, 0, 0xab, 0xcd, 0xef}
 	go conn.Write(sshMsg)
 	srv := httptest.NewServer(http.HandlerFunc(r.connectHandle))
-	url := fmt.Sprintf("ws%v/connect?sid=%v&ack=0&pos=0&try=1", strings.TrimPrefix(srv.URL, "http"), s.SID())
+	url := fmt.Sprintf("ws%v/connect?sid=%v&ack=0&pos=0&try=1", strings.TrimPrefix(srv.URL, "http"), sid)
 	mt, got, err := wsReq(url)
 	if err != nil {
 		t.Fatalf("wsReq(%v) error = %v", url, err)
@@ -178,6 +220,161 @@ func TestConnectHandle(t *testing.T) {
 	}
 }
 
+func TestConnectHandle_Binding(t *testing.T) {
+	r := newRunner()
+	r.mgr.SetLimits(0, time.Minute, 0)
+	srv := httptest.NewServer(http.HandlerFunc(r.connectHandle))
+	defer srv.Close()
+	wsURL := func(sid string) string {
+		return fmt.Sprintf("ws%v/connect?sid=%v&ack=0&pos=0&try=1", strings.TrimPrefix(srv.URL, "http"), sid)
+	}
+	dial := func(sid string, cookie *http.Cookie) (*websocket.Conn, int) {
+		hdr := http.Header{}
+		hdr.Add("Origin", cookie.Value)
+		hdr.Add("Cookie", cookie.String())
+		ws, resp, err := websocket.DefaultDialer.Dial(wsURL(sid), hdr)
+		if err != nil {
+			if resp == nil {
+				t.Fatalf("Dial(%v) error = %v", sid, err)
+			}
+			return nil, resp.StatusCode
+		}
+		return ws, http.StatusSwitchingProtocols
+	}
+	_, s, sid, err := newSSH(r)
+	if err != nil {
+		t.Fatalf("newSSH() error = %v", err)
+	}
+	id, secret, _ := strings.Cut(sid, ".")
+
+	// Session bound to another client address.
+	p, _ := net.Pipe()
+	other, err := r.mgr.New(p, session.CorpRelay, manager.Metadata{Destination: "localhost:22"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob, err := r.bind(other, connect.Client{Origin: originCookie.Value, IP: "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Session not created via /proxy.
+	p, _ = net.Pipe()
+	unbound, err := r.mgr.New(p, session.CorpRelay, manager.Metadata{Destination: "localhost:22"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testdata := []struct {
+		name     string
+		sid      string
+		cookie   *http.Cookie
+		wantCode int
+	}{
+		{
+			name:     "bad secret",
+			sid:      id + ".invalid",
+			cookie:   originCookie,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "other session secret",
+			sid:      id + "." + strings.SplitN(ob.SID(other.SID()), ".", 2)[1],
+			cookie:   originCookie,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "other origin",
+			sid:      sid,
+			cookie:   &http.Cookie{Name: "origin", Value: "chrome-extension://bar"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "other client address",
+			sid:      ob.SID(other.SID()),
+			cookie:   originCookie,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "unbound session",
+			sid:      unbound.SID().String() + "." + secret,
+			cookie:   originCookie,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range testdata {
+		if _, code := dial(tt.sid, tt.cookie); code != tt.wantCode {
+			t.Errorf("connectHandle(%v) status code = %v, want %v", tt.name, code, tt.wantCode)
+		}
+	}
+	for _, sid := range []uuid.UUID{s.SID(), other.SID(), unbound.SID()} {
+		if _, err := r.mgr.Get(sid); err != nil {
+			t.Errorf("mgr.Get(%v) error = %v, rejected requests must not affect sessions", sid, err)
+		}
+	}
+
+	// Only one WebSocket may be attached at a time.
+	ws, code := dial(sid, originCookie)
+	if code != http.StatusSwitchingProtocols {
+		t.Fatalf("connectHandle(%v) status code = %v, want %v", sid, code, http.StatusSwitchingProtocols)
+	}
+	defer ws.Close()
+	if _, code := dial(sid, originCookie); code != http.StatusConflict {
+		t.Errorf("connectHandle(%v) status code = %v, want %v", sid, code, http.StatusConflict)
+	}
+}
+
+func TestConnectHandle_Policy(t *testing.T) {
+	r := newRunner()
+	_, s, sid, err := newSSH(r)
+	if err != nil {
+		t.Fatalf("newSSH() error = %v", err)
+	}
+	// The destination is no longer allowed by the current policy.
+	r.current().policy = newPolicy(t)
+	srv := httptest.NewServer(http.HandlerFunc(r.connectHandle))
+	defer srv.Close()
+	url := fmt.Sprintf("ws%v/connect?sid=%v&ack=0&pos=0&try=1", strings.TrimPrefix(srv.URL, "http"), sid)
+	hdr := http.Header{}
+	hdr.Add("Origin", originCookie.Value)
+	hdr.Add("Cookie", originCookie.String())
+	_, resp, err := websocket.DefaultDialer.Dial(url, hdr)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("connectHandle() = %v, %v, want status code %v", resp, err, http.StatusForbidden)
+	}
+	if _, err := r.mgr.Get(s.SID()); err != nil {
+		t.Errorf("mgr.Get(%v) error = %v, rejected requests must not affect sessions", s, err)
+	}
+}
+
+func TestProxyHandle_AttachTimeout(t *testing.T) {
+	done := make(chan struct{})
+	port := listener(t, done)
+	defer close(done)
+	r := newRunner()
+	r.current().attachTimeout = 50 * time.Millisecond
+	req := httptest.NewRequest("GET", fmt.Sprintf("/proxy?host=localhost&port=%v", port), nil)
+	req.AddCookie(originCookie)
+	resp := testProxyHandle(r, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("proxyHandle() status code = %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid, _, _ := strings.Cut(string(b), ".")
+	s, err := r.mgr.Get(uuid.MustParse(sid))
+	if err != nil {
+		t.Fatalf("mgr.Get(%v) error = %v", sid, err)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("%v: session not closed after the attach timeout", s)
+	}
+}
+
 func testProxyHandle(r *Runner, req *http.Request) *http.Response {
 	w := httptest.NewRecorder()
 	r.proxyHandle(w, req)
@@ -191,7 +388,7 @@ func TestProxyHandle(t *testing.T) {
 	port := listener(t, done)
 	defer close(done)
 	r := newRunner()
-	if _, err := r.mgr.New(p, session.CorpRelay); err != nil {
+	if _, err := r.mgr.New(p, session.CorpRelay, manager.Metadata{}); err != nil {
 		t.Errorf("mgr.New() error = %v", err)
 	}
 	url := fmt.Sprintf("/proxy?host=localhost&port=%v", port)
@@ -218,8 +415,9 @@ func TestProxyHandle(t *testing.T) {
 	if err != nil {
 		t.Errorf("io.ReadAll(%v) error = %v", url, err)
 	}
-	if _, err := uuid.ParseBytes(b); err != nil {
-		t.Errorf("uuid.ParseBytes(%v) error = %v", string(b), err)
+	sid, secret, _ := strings.Cut(string(b), ".")
+	if _, err := uuid.Parse(sid); err != nil || secret == "" {
+		t.Errorf("uuid.Parse(%v) error = %v", string(b), err)
 	}
 }
 
@@ -278,3 +476,32 @@ func TestProxyHandle_Failures(t *testing.T) {
 		}
 	}
 }
+
+func TestProxyHandle_DestinationPolicy(t *testing.T) {
+	r := newRunner()
+	r.current().policy = newPolicy(t)
+	url := "/proxy?host=localhost&port=22"
+	req := httptest.NewRequest("GET", url, nil)
+	req.AddCookie(originCookie)
+	if got, want := testProxyHandle(r, req).StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("proxyHandle(%v) status code = %v, want %v", url, got, want)
+	}
+}
+
+func TestProxyHandle_NetworkFilter(t *testing.T) {
+	done := make(chan struct{})
+	port := listener(t, done)
+	defer close(done)
+	r := newRunner()
+	d, err := dialer.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.current().dialer = d
+	url := fmt.Sprintf("/proxy?host=localhost&port=%v", port)
+	req := httptest.NewRequest("GET", url, nil)
+	req.AddCookie(originCookie)
+	if got, want := testProxyHandle(r, req).StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("proxyHandle(%v) status code = %v, want %v", url, got, want)
+	}
+}
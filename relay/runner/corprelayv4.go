@@ -9,11 +9,23 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/gorilla/websocket"
+	"github.com/hazaelsan/ssh-relay/relay/policy"
 	"github.com/hazaelsan/ssh-relay/relay/request"
+	"github.com/hazaelsan/ssh-relay/relay/request/corprelayv4/reconnect"
+	"github.com/hazaelsan/ssh-relay/relay/session/manager"
 	"github.com/hazaelsan/ssh-relay/session"
 )
 
-// TODO: Implement /v4/reconnect logic.
+// upgradeV4 upgrades a corp-relay-v4@google.com request to a WebSocket.
+func upgradeV4(w http.ResponseWriter, req *http.Request, origin string) (*websocket.Conn, error) {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return r.Header.Get("Origin") == origin
+		},
+		Subprotocols: []string{"ssh"},
+	}
+	return upgrader.Upgrade(w, req, nil)
+}
 
 // connectHandleV4 handles /v4/connect requests.
 func (r *Runner) connectHandleV4(w http.ResponseWriter, req *http.Request) {
@@ -21,31 +33,57 @@ func (r *Runner) connectHandleV4(w http.ResponseWriter, req *http.Request) {
 	var ws *websocket.Conn
 	var addr string
 	code, err := func() (code int, err error) {
+		if err := r.admit(); err != nil {
+			return http.StatusServiceUnavailable, fmt.Errorf("admit() error: %w", err)
+		}
 		host := req.URL.Query().Get("host")
 		port := req.URL.Query().Get("port")
-		origin, err := request.Origin(req, r.cfg.OriginCookieName)
+		name := r.current().cfg.OriginCookieName
+		origin, err := request.Origin(req, name)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("request.Origin(%v) error: %w", name, err)
+		}
+		claims, err := r.authorize(req)
+		if err != nil {
+			return http.StatusUnauthorized, fmt.Errorf("authorize() error: %w", errUnauthorized)
+		}
+		id, err := r.identify(req)
 		if err != nil {
-			return http.StatusBadRequest, fmt.Errorf("request.Origin(%v) error: %w", r.cfg.OriginCookieName, err)
+			return http.StatusUnauthorized, fmt.Errorf("identify() error: %w", errUnauthorized)
 		}
 		addr = net.JoinHostPort(host, port)
-		ssh, err := net.Dial("tcp", addr)
+		dst := policy.Destination{Host: host, Port: port, Origin: origin, Identity: id}
+		if err := r.checkDestination(dst, claims); err != nil {
+			// The rejection reason is logged, don't leak policy details to clients.
+			return http.StatusForbidden, fmt.Errorf("checkDestination(%v) error: %w", addr, policy.ErrDenied)
+		}
+		md := manager.Metadata{Destination: addr, Origin: origin, Identity: id, ClientIP: request.ClientIP(req)}
+		res, err := r.mgr.Reserve(req.Context(), md)
+		if err != nil {
+			return r.sessionStatus(w, err), fmt.Errorf("mgr.Reserve(%v) error: %w", addr, err)
+		}
+		defer res.Release()
+		ssh, code, err := r.dial(req.Context(), dst)
 		if err != nil {
-			return http.StatusBadGateway, fmt.Errorf("net.Dial(%v) error: %w", addr, err)
+			return code, fmt.Errorf("dial(%v) error: %w", addr, err)
 		}
-		s, err = r.mgr.New(ssh, session.CorpRelayV4)
+		s, err = res.New(ssh, session.CorpRelayV4)
 		if err != nil {
-			return http.StatusServiceUnavailable, fmt.Errorf("mgr.New(%v) error: %w", addr, err)
+			ssh.Close()
+			return r.sessionStatus(w, err), fmt.Errorf("res.New(%v) error: %w", addr, err)
+		}
+		if err := r.sendProxyHeader(req, ssh, dst, s, claims); err != nil {
+			s.Close()
+			return http.StatusBadGateway, fmt.Errorf("sendProxyHeader(%v) error: %w: %v", addr, errConnection, err)
 		}
 		glog.V(4).Infof("%v: Connected to %v", s, addr)
 
-		upgrader := websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return r.Header.Get("Origin") == origin
-			},
-			Subprotocols: []string{"ssh"},
-		}
-		ws, err = upgrader.Upgrade(w, req, nil)
+		ws, err = upgradeV4(w, req, origin)
 		if err != nil {
+			s.Close()
+			if err := r.mgr.Delete(s.SID()); err != nil {
+				glog.Errorf("mgr.Delete(%v) error: %v", s, err)
+			}
 			return http.StatusBadGateway, fmt.Errorf("upgrader.Upgrade(%v) error: %w", origin, err)
 		}
 		return 0, nil
@@ -58,14 +96,94 @@ func (r *Runner) connectHandleV4(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	defer ws.Close()
-	if err := s.Run(ws); err != nil {
-		if errors.Is(err, io.EOF) {
-			glog.V(1).Infof("%v: Connection to %v closed", s, addr)
-			if err := r.mgr.Delete(s.SID()); err != nil {
-				glog.Errorf("mgr.Delete(%v) error: %v", s, err)
+	r.endV4(s, s.Run(ws))
+}
+
+// reconnectHandleV4 handles /v4/reconnect requests, resuming a disconnected session.
+// Only the client that created the session may resume it, and only if its destination is still allowed;
+// rejected requests are audit-logged.
+func (r *Runner) reconnectHandleV4(w http.ResponseWriter, req *http.Request) {
+	var s session.Resumer
+	var ws *websocket.Conn
+	var rr *reconnect.Request
+	code, err := func() (code int, err error) {
+		rr, err = reconnect.New(req)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("reconnect.New(%v) error: %w", req.URL, err)
+		}
+		name := r.current().cfg.OriginCookieName
+		origin, err := request.Origin(req, name)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("request.Origin(%v) error: %w", name, err)
+		}
+		claims, err := r.authorize(req)
+		if err != nil {
+			return http.StatusUnauthorized, fmt.Errorf("authorize() error: %w", errUnauthorized)
+		}
+		id, err := r.identify(req)
+		if err != nil {
+			return http.StatusUnauthorized, fmt.Errorf("identify() error: %w", errUnauthorized)
+		}
+		info, err := r.mgr.Info(rr.SID)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("mgr.Info(%v) error: %w", rr, err)
+		}
+		host, port, err := net.SplitHostPort(info.Destination)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("net.SplitHostPort(%v) error: %w", info.Destination, err)
+		}
+		dst := policy.Destination{Host: host, Port: port, Origin: origin, Identity: id}
+		if err := r.checkDestination(dst, claims); err != nil {
+			glog.Warningf("AUDIT: %v: Rejected /v4/reconnect from %v: %v", rr, request.ClientIP(req), err)
+			return http.StatusForbidden, fmt.Errorf("checkDestination(%v) error: %w", info.Destination, policy.ErrDenied)
+		}
+		md := manager.Metadata{Origin: origin, Identity: id}
+		s, err = r.mgr.Resume(rr.SID, md)
+		if errors.Is(err, manager.ErrPrincipalMismatch) {
+			glog.Warningf("AUDIT: %v: Rejected /v4/reconnect from %v: %v", rr, request.ClientIP(req), err)
+			return http.StatusForbidden, fmt.Errorf("mgr.Resume(%v) error: %w", rr, policy.ErrDenied)
+		}
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("mgr.Resume(%v) error: %w", rr, err)
+		}
+		ws, err = upgradeV4(w, req, origin)
+		if err != nil {
+			if err := r.mgr.Suspend(rr.SID); err != nil {
+				glog.Errorf("mgr.Suspend(%v) error: %v", rr, err)
 			}
-			return
+			return http.StatusBadGateway, fmt.Errorf("upgrader.Upgrade(%v) error: %w", origin, err)
+		}
+		return 0, nil
+	}()
+	if err != nil {
+		http.Error(w, errors.Unwrap(err).Error(), code)
+		if glog.V(5) {
+			glog.Error(err)
+		}
+		return
+	}
+	defer ws.Close()
+	glog.V(1).Infof("%v: Resuming session, ack %v", s, rr.Ack)
+	r.endV4(s, s.Resume(ws, rr.Ack))
+}
+
+// endV4 handles the end of a corp-relay-v4@google.com WebSocket connection.
+// Disconnected sessions are suspended, giving clients a chance to resume them.
+func (r *Runner) endV4(s session.Session, err error) {
+	switch {
+	case errors.Is(err, session.ErrDisconnected):
+		glog.V(1).Infof("%v: %v", s, err)
+		if err := r.mgr.Suspend(s.SID()); err != nil {
+			glog.Errorf("mgr.Suspend(%v) error: %v", s, err)
+		}
+	case errors.Is(err, session.ErrReplaced):
+		glog.V(1).Infof("%v: %v", s, err)
+	case errors.Is(err, io.EOF):
+		glog.V(1).Infof("%v: SSH connection closed", s)
+		if err := r.mgr.Delete(s.SID()); err != nil {
+			glog.Errorf("mgr.Delete(%v) error: %v", s, err)
 		}
+	default:
 		glog.Error(err)
 	}
 }
@@ -0,0 +1,294 @@
+package runner
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hazaelsan/ssh-relay/relay/session/manager"
+	"github.com/hazaelsan/ssh-relay/session"
+	"github.com/hazaelsan/ssh-relay/session/corprelayv4/command"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// sshListener accepts a single SSH connection and writes sshMsg to it.
+func sshListener(t *testing.T, done <-chan struct{}) string {
+	t.Helper()
+	s, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-done
+		s.Close()
+	}()
+	go func() {
+		conn, err := s.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write(sshMsg)
+		<-done
+		conn.Close()
+	}()
+	return strconv.Itoa(s.Addr().(*net.TCPAddr).Port)
+}
+
+func wsDialV4(url string) (*websocket.Conn, error) {
+	hdr := http.Header{}
+	hdr.Add("Origin", "chrome-extension://foo")
+	hdr.Add("Cookie", originCookie.String())
+	ws, _, err := websocket.DefaultDialer.Dial(url, hdr)
+	return ws, err
+}
+
+func readCmd(ws *websocket.Conn) (command.Command, error) {
+	_, b, err := ws.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	return command.Unmarshal(b)
+}
+
+func TestConnectHandleV4_DestinationPolicy(t *testing.T) {
+	r := newRunner()
+	r.current().policy = newPolicy(t)
+	url := "/v4/connect?host=localhost&port=22"
+	req := httptest.NewRequest("GET", url, nil)
+	req.AddCookie(originCookie)
+	w := httptest.NewRecorder()
+	r.connectHandleV4(w, req)
+	if got, want := w.Result().StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("connectHandleV4(%v) status code = %v, want %v", url, got, want)
+	}
+}
+
+func TestConnectHandleV4_UpgradeFailure(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	port := sshListener(t, done)
+	r := newRunner()
+	// Not a WebSocket request, the session is created before the upgrade fails.
+	url := fmt.Sprintf("/v4/connect?host=127.0.0.1&port=%v", port)
+	req := httptest.NewRequest("GET", url, nil)
+	req.AddCookie(originCookie)
+	w := httptest.NewRecorder()
+	r.connectHandleV4(w, req)
+	if got, want := w.Result().StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("connectHandleV4(%v) status code = %v, want %v", url, got, want)
+	}
+	if n := r.mgr.Len(); n != 0 {
+		t.Errorf("Len() = %v, want 0", n)
+	}
+}
+
+func TestReconnectHandleV4_Failures(t *testing.T) {
+	testdata := []struct {
+		url      string
+		cookies  []*http.Cookie
+		wantCode int
+	}{
+		// Missing SID.
+		{
+			url:      "/v4/reconnect?ack=0",
+			cookies:  []*http.Cookie{originCookie},
+			wantCode: http.StatusBadRequest,
+		},
+		// Missing ack.
+		{
+			url:      fmt.Sprintf("/v4/reconnect?sid=%v", dummySID),
+			cookies:  []*http.Cookie{originCookie},
+			wantCode: http.StatusBadRequest,
+		},
+		// Bad origin cookie.
+		{
+			url:      fmt.Sprintf("/v4/reconnect?sid=%v&ack=0", dummySID),
+			wantCode: http.StatusBadRequest,
+		},
+		// Unknown SID.
+		{
+			url:      fmt.Sprintf("/v4/reconnect?sid=%v&ack=0", dummySID),
+			cookies:  []*http.Cookie{originCookie},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range testdata {
+		r := newRunner()
+		req := httptest.NewRequest("GET", tt.url, nil)
+		for _, c := range tt.cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		r.reconnectHandleV4(w, req)
+		if got := w.Result(); got.StatusCode != tt.wantCode {
+			t.Errorf("reconnectHandleV4(%v) status code = %v, want %v", tt.url, got.StatusCode, tt.wantCode)
+		}
+	}
+}
+
+func TestReconnectHandleV4(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	port := sshListener(t, done)
+	r := newRunner()
+	r.mgr = manager.New(1, time.Minute, time.Minute)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v4/connect", r.connectHandleV4)
+	mux.HandleFunc("/v4/reconnect", r.reconnectHandleV4)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	base := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	url := fmt.Sprintf("%v/v4/connect?host=localhost&port=%v", base, port)
+	ws, err := wsDialV4(url)
+	if err != nil {
+		t.Fatalf("wsDialV4(%v) error = %v", url, err)
+	}
+	c, err := readCmd(ws)
+	if err != nil {
+		t.Fatalf("readCmd() error = %v", err)
+	}
+	cs, ok := c.(command.ConnectSuccess)
+	if !ok {
+		t.Fatalf("readCmd() = %v, want %v", c.Tag(), command.TagConnectSuccess)
+	}
+	c, err = readCmd(ws)
+	if err != nil {
+		t.Fatalf("readCmd() error = %v", err)
+	}
+	if diff := pretty.Compare(c, command.Data(sshMsg)); diff != "" {
+		t.Errorf("readCmd() diff (-got +want):\n%v", diff)
+	}
+
+	// Drop the connection without acking any data.
+	ws.Close()
+	url = fmt.Sprintf("%v/v4/reconnect?sid=%v&ack=0", base, cs.SID())
+	for i := 0; i < 10; i++ {
+		if ws, err = wsDialV4(url); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("wsDialV4(%v) error = %v", url, err)
+	}
+	defer ws.Close()
+	want := []command.Command{
+		command.NewReconnectSuccess(0),
+		command.Data(sshMsg),
+	}
+	for _, w := range want {
+		c, err := readCmd(ws)
+		if err != nil {
+			t.Fatalf("readCmd() error = %v", err)
+		}
+		if diff := pretty.Compare(c, w); diff != "" {
+			t.Errorf("readCmd() diff (-got +want):\n%v", diff)
+		}
+	}
+
+	// Reconnecting before the relay notices the loss of the WebSocket replaces it.
+	ws2, err := wsDialV4(url)
+	if err != nil {
+		t.Fatalf("wsDialV4(%v) error = %v", url, err)
+	}
+	defer ws2.Close()
+	for _, w := range want {
+		c, err := readCmd(ws2)
+		if err != nil {
+			t.Fatalf("readCmd() error = %v", err)
+		}
+		if diff := pretty.Compare(c, w); diff != "" {
+			t.Errorf("readCmd() diff (-got +want):\n%v", diff)
+		}
+	}
+	if _, err := readCmd(ws); err == nil {
+		t.Errorf("readCmd() error = nil on replaced WebSocket")
+	}
+}
+
+func TestReconnectHandleV4_Principal(t *testing.T) {
+	testdata := []struct {
+		name     string
+		origin   string
+		cert     *x509.Certificate
+		policy   bool
+		wantCode int
+	}{
+		{
+			// Resumed, then the WebSocket upgrade of a plain request fails with 400.
+			name:     "same principal",
+			origin:   originCookie.Value,
+			cert:     identityCert,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "other identity",
+			origin:   originCookie.Value,
+			cert:     &x509.Certificate{EmailAddresses: []string{"user@example.com"}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "no identity",
+			origin:   originCookie.Value,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "other origin",
+			origin:   "chrome-extension://bar",
+			cert:     identityCert,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "destination denied",
+			origin:   originCookie.Value,
+			cert:     identityCert,
+			policy:   true,
+			wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range testdata {
+		r := newRunner()
+		r.mgr = manager.New(1, time.Minute, time.Minute)
+		if tt.policy {
+			r.current().policy = newPolicy(t)
+		}
+		p, _ := net.Pipe()
+		md := manager.Metadata{Destination: "localhost:22", Origin: originCookie.Value, Identity: "user@example.org"}
+		s, err := r.mgr.New(p, session.CorpRelayV4, md)
+		if err != nil {
+			t.Fatalf("New(%v) error = %v", tt.name, err)
+		}
+		if err := r.mgr.Suspend(s.SID()); err != nil {
+			t.Fatalf("Suspend(%v) error = %v", tt.name, err)
+		}
+		url := fmt.Sprintf("/v4/reconnect?sid=%v&ack=0", s.SID())
+		req := httptest.NewRequest("GET", url, nil)
+		req.AddCookie(&http.Cookie{Name: originCookie.Name, Value: tt.origin})
+		if tt.cert != nil {
+			req = withCert(req, tt.cert)
+		}
+		w := httptest.NewRecorder()
+		r.reconnectHandleV4(w, req)
+		if got := w.Result().StatusCode; got != tt.wantCode {
+			t.Errorf("reconnectHandleV4(%v) status code = %v, want %v", tt.name, got, tt.wantCode)
+		}
+		// Rejected requests leave the session suspended.
+		info, err := r.mgr.Info(s.SID())
+		if err != nil {
+			t.Fatalf("Info(%v) error = %v", tt.name, err)
+		}
+		if !info.Suspended {
+			t.Errorf("Info(%v).Suspended = false", tt.name)
+		}
+		s.Close()
+		p.Close()
+	}
+}
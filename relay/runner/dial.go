@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hazaelsan/ssh-relay/relay/dialer"
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+)
+
+// errConnection is returned to clients for dial errors that can't be classified further.
+var errConnection = errors.New("connection error")
+
+// otherDestination is the metric label for destinations not matching any destination policy rule.
+const otherDestination = "other"
+
+// destinationLabel returns the metric label for a destination, the name of the first destination policy rule
+// it matches; client-supplied hosts aren't used directly to keep the number of label values bounded.
+func (r *Runner) destinationLabel(dst policy.Destination) string {
+	if rule := r.current().policy.Rule(dst); rule != "" {
+		return rule
+	}
+	return otherDestination
+}
+
+// dial connects to an SSH backend, returns the HTTP status code to use on failure.
+// Returned errors are safe to show to clients, the full error is logged instead;
+// rejections due to the network filter are always logged.
+func (r *Runner) dial(ctx context.Context, dst policy.Destination) (net.Conn, int, error) {
+	addr := net.JoinHostPort(dst.Host, dst.Port)
+	label := r.destinationLabel(dst)
+	start := time.Now()
+	conn, err := r.current().dialer.Dial(ctx, dst)
+	dialDurations.With(label).Observe(time.Since(start).Seconds())
+	if err == nil {
+		return conn, 0, nil
+	}
+	code, safeErr, reason := http.StatusBadGateway, errConnection, "connection"
+	switch {
+	case errors.Is(err, dialer.ErrBlocked):
+		code, safeErr, reason = http.StatusForbidden, dialer.ErrBlocked, "blocked"
+		// The rejection reason is logged, don't leak resolved addresses to clients.
+		glog.Warningf("Rejected connection request to %v: %v", addr, err)
+	case errors.Is(err, dialer.ErrBadPort):
+		code, safeErr, reason = http.StatusBadRequest, dialer.ErrBadPort, "bad_port"
+	case errors.Is(err, dialer.ErrTimeout):
+		code, safeErr, reason = http.StatusGatewayTimeout, dialer.ErrTimeout, "timeout"
+	case errors.Is(err, dialer.ErrResolve):
+		safeErr, reason = dialer.ErrResolve, "resolution"
+	case errors.Is(err, dialer.ErrRefused):
+		safeErr, reason = dialer.ErrRefused, "refused"
+	case errors.Is(err, dialer.ErrProxy):
+		safeErr, reason = dialer.ErrProxy, "proxy"
+	}
+	dialErrors.With(label, reason).Inc()
+	if glog.V(1) {
+		glog.Errorf("dial(%v) error: %v", addr, err)
+	}
+	return nil, code, safeErr
+}
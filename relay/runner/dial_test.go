@@ -0,0 +1,43 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+)
+
+func TestDestinationLabel(t *testing.T) {
+	testdata := []struct {
+		name   string
+		policy bool
+		d      policy.Destination
+		want   string
+	}{
+		{
+			name: "no policy",
+			d:    policy.Destination{Host: "example.org", Port: "22"},
+			want: otherDestination,
+		},
+		{
+			name:   "matching rule",
+			policy: true,
+			d:      policy.Destination{Host: "Example.org.", Port: "22"},
+			want:   "#0",
+		},
+		{
+			name:   "no matching rule",
+			policy: true,
+			d:      policy.Destination{Host: "attacker-controlled-1234.example.com", Port: "22"},
+			want:   otherDestination,
+		},
+	}
+	for _, tt := range testdata {
+		r := newRunner()
+		if tt.policy {
+			r.current().policy = newPolicy(t)
+		}
+		if got := r.destinationLabel(tt.d); got != tt.want {
+			t.Errorf("destinationLabel(%v) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// drainPollInterval is how often to check for remaining sessions while draining.
+	drainPollInterval = time.Second
+
+	// defaultMaintenanceMessage is sent to clients in maintenance mode if no message was set.
+	defaultMaintenanceMessage = "relay is under maintenance"
+)
+
+// errDraining is returned to clients requesting new sessions while the relay is shutting down.
+var errDraining = errors.New("relay is shutting down")
+
+// admit checks whether new sessions are accepted, returns the error to send to clients otherwise.
+// New sessions are not accepted while draining, in maintenance mode, or while shedding load.
+func (r *Runner) admit() error {
+	r.stateMu.RLock()
+	defer r.stateMu.RUnlock()
+	switch {
+	case r.draining:
+		return errDraining
+	case r.maintenance:
+		return errors.New(r.maintenanceMsg)
+	default:
+		return r.current().load.check()
+	}
+}
+
+// SetMaintenance enables or disables maintenance mode, rejecting new sessions with msg while enabled.
+// Existing sessions are not affected.
+func (r *Runner) SetMaintenance(enabled bool, msg string) {
+	if msg == "" {
+		msg = defaultMaintenanceMessage
+	}
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+	r.maintenance = enabled
+	r.maintenanceMsg = msg
+	glog.Infof("Maintenance mode enabled: %v (%v)", enabled, msg)
+}
+
+// readyHandle handles /readyz requests, the relay is not ready while draining or in maintenance mode.
+func (r *Runner) readyHandle(w http.ResponseWriter, req *http.Request) {
+	if err := r.admit(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// Drain gracefully shuts down the relay.
+// New sessions (including queued requests) are rejected, existing sessions are given up to drain_timeout to finish before being closed,
+// the servers are stopped afterwards.
+func (r *Runner) Drain(ctx context.Context) error {
+	r.stateMu.Lock()
+	r.draining = true
+	r.stateMu.Unlock()
+	// Requests waiting for a session slot would otherwise get the slots freed by draining sessions.
+	r.mgr.StopAdmission(errDraining)
+	timeout := r.current().drainTimeout
+	glog.Infof("Draining %v sessions for up to %v", r.mgr.Len(), timeout)
+
+	dctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := r.waitSessions(dctx); err != nil {
+		glog.Infof("Drain deadline reached, closing %v sessions", r.mgr.Len())
+		r.mgr.CloseAll()
+	}
+
+	if r.admin != nil {
+		r.admin.Stop()
+	}
+	var errs []error
+	if r.metrics != nil {
+		errs = append(errs, r.metrics.Shutdown(ctx))
+	}
+	errs = append(errs, r.server.Shutdown(ctx))
+	return errors.Join(errs...)
+}
+
+// waitSessions waits until all sessions have finished.
+func (r *Runner) waitSessions(ctx context.Context) error {
+	t := time.NewTicker(drainPollInterval)
+	defer t.Stop()
+	for n := r.mgr.Len(); n > 0; n = r.mgr.Len() {
+		glog.V(1).Infof("Waiting for %v sessions to finish", n)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	rhttp "github.com/hazaelsan/ssh-relay/http"
+	"github.com/hazaelsan/ssh-relay/relay/session/manager"
+	"github.com/hazaelsan/ssh-relay/session"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/httppb"
+	"github.com/hazaelsan/ssh-relay/proto/v1/tlspb"
+)
+
+func TestAdmit(t *testing.T) {
+	testdata := []struct {
+		name        string
+		draining    bool
+		maintenance bool
+		msg         string
+		wantCode    int
+		wantBody    string
+	}{
+		{
+			name:     "ready",
+			wantCode: http.StatusOK,
+			wantBody: "ok\n",
+		},
+		{
+			name:     "draining",
+			draining: true,
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: errDraining.Error() + "\n",
+		},
+		{
+			name:        "maintenance",
+			maintenance: true,
+			msg:         "upgrade in progress",
+			wantCode:    http.StatusServiceUnavailable,
+			wantBody:    "upgrade in progress\n",
+		},
+		{
+			name:        "maintenance default message",
+			maintenance: true,
+			wantCode:    http.StatusServiceUnavailable,
+			wantBody:    defaultMaintenanceMessage + "\n",
+		},
+	}
+	for _, tt := range testdata {
+		r := newRunner()
+		r.draining = tt.draining
+		r.SetMaintenance(tt.maintenance, tt.msg)
+		for url, h := range map[string]http.HandlerFunc{
+			"/readyz":                            r.readyHandle,
+			"/proxy?host=localhost&port=22":      r.proxyHandle,
+			"/v4/connect?host=localhost&port=22": r.connectHandleV4,
+		} {
+			if url != "/readyz" && tt.wantCode == http.StatusOK {
+				continue
+			}
+			req := httptest.NewRequest("GET", url, nil)
+			req.AddCookie(originCookie)
+			w := httptest.NewRecorder()
+			h(w, req)
+			if got := w.Result().StatusCode; got != tt.wantCode {
+				t.Errorf("%v %v status code = %v, want %v", tt.name, url, got, tt.wantCode)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("%v %v body = %q, want %q", tt.name, url, got, tt.wantBody)
+			}
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	testdata := []struct {
+		name      string
+		timeout   time.Duration
+		finish    bool
+		wantAfter time.Duration
+	}{
+		{
+			name:    "sessions finished",
+			timeout: time.Minute,
+			finish:  true,
+		},
+		{
+			name:      "deadline reached",
+			timeout:   100 * time.Millisecond,
+			wantAfter: 100 * time.Millisecond,
+		},
+	}
+	for _, tt := range testdata {
+		r := newRunner()
+		r.mgr = manager.New(0, time.Minute, 0)
+		r.current().drainTimeout = tt.timeout
+		var err error
+		r.server, err = rhttp.NewServer(&httppb.HttpServerOptions{
+			Port:      "0",
+			TlsConfig: &tlspb.TlsConfig{TlsMode: tlspb.TlsConfig_TLS_MODE_DISABLED},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, _ := net.Pipe()
+		s, err := r.mgr.New(p, session.CorpRelay, manager.Metadata{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.finish {
+			time.AfterFunc(10*time.Millisecond, func() { s.Close() })
+		}
+		start := time.Now()
+		if err := r.Drain(context.Background()); err != nil {
+			t.Errorf("Drain(%v) error = %v", tt.name, err)
+		}
+		if got := time.Since(start); got < tt.wantAfter {
+			t.Errorf("Drain(%v) took %v, want >= %v", tt.name, got, tt.wantAfter)
+		}
+		if err := r.admit(); err != errDraining {
+			t.Errorf("admit(%v) error = %v, want %v", tt.name, err, errDraining)
+		}
+		// Closed sessions are de-registered asynchronously.
+		for i := 0; i < 100 && r.mgr.Len() > 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := r.mgr.Len(); n != 0 {
+			t.Errorf("Drain(%v) left %v sessions", tt.name, n)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+// defaultIdentitySources are the certificate fields client identities are taken from if unset in the config.
+var defaultIdentitySources = []configpb.Config_ClientIdentity_Source{
+	configpb.Config_ClientIdentity_SAN_EMAIL,
+	configpb.Config_ClientIdentity_SAN_URI,
+	configpb.Config_ClientIdentity_SAN_DNS,
+	configpb.Config_ClientIdentity_SUBJECT_COMMON_NAME,
+}
+
+// identitySources validates the certificate fields client identities are taken from.
+func identitySources(pb *configpb.Config_ClientIdentity) ([]configpb.Config_ClientIdentity_Source, error) {
+	if len(pb.GetSources()) == 0 {
+		return defaultIdentitySources, nil
+	}
+	for _, src := range pb.GetSources() {
+		if _, ok := configpb.Config_ClientIdentity_Source_name[int32(src)]; !ok || src == configpb.Config_ClientIdentity_SOURCE_UNSPECIFIED {
+			return nil, fmt.Errorf("bad client identity source: %v", src)
+		}
+	}
+	return pb.GetSources(), nil
+}
+
+// certIdentity returns the identity in a certificate from the first source that's set, empty if none is.
+func certIdentity(cert *x509.Certificate, sources []configpb.Config_ClientIdentity_Source) string {
+	for _, src := range sources {
+		var id string
+		switch src {
+		case configpb.Config_ClientIdentity_SAN_EMAIL:
+			if len(cert.EmailAddresses) > 0 {
+				id = cert.EmailAddresses[0]
+			}
+		case configpb.Config_ClientIdentity_SAN_URI:
+			if len(cert.URIs) > 0 {
+				id = cert.URIs[0].String()
+			}
+		case configpb.Config_ClientIdentity_SAN_DNS:
+			if len(cert.DNSNames) > 0 {
+				id = cert.DNSNames[0]
+			}
+		case configpb.Config_ClientIdentity_SUBJECT_COMMON_NAME:
+			id = cert.Subject.CommonName
+		case configpb.Config_ClientIdentity_SUBJECT_ORGANIZATIONAL_UNIT:
+			if len(cert.Subject.OrganizationalUnit) > 0 {
+				id = cert.Subject.OrganizationalUnit[0]
+			}
+		case configpb.Config_ClientIdentity_SUBJECT_SERIAL_NUMBER:
+			id = cert.Subject.SerialNumber
+		}
+		if id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// identify returns the client identity from its verified TLS certificate, empty if there's none.
+// Clients without an identity are rejected if it's required, rejections are always logged;
+// errors returned to clients wrap errUnauthorized.
+func (r *Runner) identify(req *http.Request) (string, error) {
+	st := r.current()
+	var id string
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 && len(req.TLS.VerifiedChains[0]) > 0 {
+		id = certIdentity(req.TLS.VerifiedChains[0][0], st.identitySources)
+	}
+	if id == "" {
+		if st.cfg.GetClientIdentity().GetRequired() {
+			glog.Warningf("Rejected request from %v: no client certificate identity", req.RemoteAddr)
+			return "", fmt.Errorf("certIdentity() error: %w", errUnauthorized)
+		}
+		return "", nil
+	}
+	glog.V(2).Infof("Identified %q from %v", id, req.RemoteAddr)
+	return id, nil
+}
@@ -0,0 +1,248 @@
+package runner
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/destinationpb"
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/policypb"
+)
+
+var identityCert = &x509.Certificate{
+	Subject: pkix.Name{
+		CommonName:         "user",
+		OrganizationalUnit: []string{"ops"},
+		SerialNumber:       "1234",
+	},
+	EmailAddresses: []string{"user@example.org"},
+	URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/user"}},
+	DNSNames:       []string{"user.example.org"},
+}
+
+// withCert returns a request with a verified client certificate.
+func withCert(req *http.Request, cert *x509.Certificate) *http.Request {
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return req
+}
+
+func TestCertIdentity(t *testing.T) {
+	testdata := []struct {
+		cert    *x509.Certificate
+		sources []configpb.Config_ClientIdentity_Source
+		want    string
+	}{
+		{
+			cert:    identityCert,
+			sources: defaultIdentitySources,
+			want:    "user@example.org",
+		},
+		{
+			cert:    &x509.Certificate{Subject: pkix.Name{CommonName: "user"}},
+			sources: defaultIdentitySources,
+			want:    "user",
+		},
+		{
+			cert:    identityCert,
+			sources: []configpb.Config_ClientIdentity_Source{configpb.Config_ClientIdentity_SAN_URI},
+			want:    "spiffe://example.org/user",
+		},
+		{
+			cert:    identityCert,
+			sources: []configpb.Config_ClientIdentity_Source{configpb.Config_ClientIdentity_SAN_DNS},
+			want:    "user.example.org",
+		},
+		{
+			cert:    identityCert,
+			sources: []configpb.Config_ClientIdentity_Source{configpb.Config_ClientIdentity_SUBJECT_ORGANIZATIONAL_UNIT},
+			want:    "ops",
+		},
+		{
+			cert:    identityCert,
+			sources: []configpb.Config_ClientIdentity_Source{configpb.Config_ClientIdentity_SUBJECT_SERIAL_NUMBER},
+			want:    "1234",
+		},
+		{
+			cert: &x509.Certificate{Subject: pkix.Name{CommonName: "user"}},
+			sources: []configpb.Config_ClientIdentity_Source{
+				configpb.Config_ClientIdentity_SAN_EMAIL,
+				configpb.Config_ClientIdentity_SUBJECT_SERIAL_NUMBER,
+			},
+		},
+	}
+	for _, tt := range testdata {
+		if got := certIdentity(tt.cert, tt.sources); got != tt.want {
+			t.Errorf("certIdentity(%v) = %q, want %q", tt.sources, got, tt.want)
+		}
+	}
+}
+
+func TestIdentitySources(t *testing.T) {
+	testdata := []struct {
+		pb *configpb.Config_ClientIdentity
+		ok bool
+	}{
+		{
+			ok: true,
+		},
+		{
+			pb: &configpb.Config_ClientIdentity{
+				Sources: []configpb.Config_ClientIdentity_Source{configpb.Config_ClientIdentity_SAN_URI},
+			},
+			ok: true,
+		},
+		{
+			pb: &configpb.Config_ClientIdentity{
+				Sources: []configpb.Config_ClientIdentity_Source{configpb.Config_ClientIdentity_SOURCE_UNSPECIFIED},
+			},
+		},
+		{
+			pb: &configpb.Config_ClientIdentity{
+				Sources: []configpb.Config_ClientIdentity_Source{100},
+			},
+		},
+	}
+	for _, tt := range testdata {
+		_, err := identitySources(tt.pb)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("identitySources(%v) error = %v, want ok = %v", tt.pb, err, tt.ok)
+		}
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	testdata := []struct {
+		name     string
+		cert     *x509.Certificate
+		required bool
+		want     string
+		ok       bool
+	}{
+		{
+			name: "identity",
+			cert: identityCert,
+			want: "user@example.org",
+			ok:   true,
+		},
+		{
+			name: "no cert",
+			ok:   true,
+		},
+		{
+			name:     "required",
+			cert:     identityCert,
+			required: true,
+			want:     "user@example.org",
+			ok:       true,
+		},
+		{
+			name:     "required without cert",
+			required: true,
+		},
+		{
+			name:     "required without identity",
+			cert:     new(x509.Certificate),
+			required: true,
+		},
+	}
+	for _, tt := range testdata {
+		r := newRunner()
+		r.current().cfg.ClientIdentity = &configpb.Config_ClientIdentity{Required: tt.required}
+		req := httptest.NewRequest("GET", "/proxy", nil)
+		if tt.cert != nil {
+			req = withCert(req, tt.cert)
+		}
+		got, err := r.identify(req)
+		if err != nil {
+			if tt.ok {
+				t.Errorf("identify(%v) error = %v", tt.name, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("identify(%v) error = nil", tt.name)
+		}
+		if got != tt.want {
+			t.Errorf("identify(%v) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProxyHandle_Identity(t *testing.T) {
+	p, err := policy.New(&policypb.DestinationPolicy{
+		Rules: []*policypb.DestinationPolicy_Rule{
+			{
+				Action: policypb.DestinationPolicy_ALLOW,
+				Match: &destinationpb.DestinationMatcher{
+					Identities: []string{"*@example.org"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testdata := []struct {
+		name     string
+		cert     *x509.Certificate
+		wantCode int
+	}{
+		{
+			name:     "allowed identity",
+			cert:     identityCert,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "other identity",
+			cert:     &x509.Certificate{EmailAddresses: []string{"user@example.com"}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "no cert",
+			wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range testdata {
+		done := make(chan struct{})
+		port := listener(t, done)
+		r := newRunner()
+		r.current().policy = p
+		req := httptest.NewRequest("GET", fmt.Sprintf("/proxy?host=localhost&port=%v", port), nil)
+		req.AddCookie(originCookie)
+		if tt.cert != nil {
+			req = withCert(req, tt.cert)
+		}
+		resp := testProxyHandle(r, req)
+		close(done)
+		if resp.StatusCode != tt.wantCode {
+			t.Errorf("proxyHandle(%v) status code = %v, want %v", tt.name, resp.StatusCode, tt.wantCode)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sid, _, _ := strings.Cut(string(b), ".")
+		info, err := r.mgr.Info(uuid.MustParse(sid))
+		if err != nil {
+			t.Fatalf("mgr.Info(%v) error = %v", tt.name, err)
+		}
+		if info.Identity != "user@example.org" {
+			t.Errorf("mgr.Info(%v) Identity = %q, want %q", tt.name, info.Identity, "user@example.org")
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package runner
+
+import "github.com/hazaelsan/ssh-relay/metrics"
+
+var (
+	dialDurations    = metrics.NewHistogramVec("ssh_relay_dial_duration_seconds", "Time taken to connect to SSH servers, including name resolution, by destination policy rule.", metrics.DefBuckets, "rule")
+	dialErrors       = metrics.NewCounterVec("ssh_relay_dial_errors_total", "Failed connections to SSH servers, by destination policy rule.", "rule", "reason")
+	orphanedSessions = metrics.NewCounterVec("ssh_relay_orphaned_sessions_total", "Sessions closed after no WebSocket attached within attach_timeout.", "protocol")
+	loadShedding     = metrics.NewGaugeVec("ssh_relay_load_shedding", "Whether new sessions are rejected due to a load_shedding threshold.", "signal")
+)
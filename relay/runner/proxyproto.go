@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/hazaelsan/ssh-relay/proxyproto"
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+	"github.com/hazaelsan/ssh-relay/session"
+	"github.com/hazaelsan/ssh-relay/token"
+)
+
+// sendProxyHeader sends a PROXY protocol header to an SSH backend if enabled for the destination,
+// must be called before the session starts relaying data.
+func (r *Runner) sendProxyHeader(req *http.Request, ssh net.Conn, d policy.Destination, s session.Session, c *token.Claims) error {
+	if !r.proxyProtocol(d) {
+		return nil
+	}
+	h := &proxyproto.Header{
+		TLVs: []proxyproto.TLV{{Type: proxyproto.TypeUniqueID, Value: []byte(s.SID().String())}},
+	}
+	// Unparseable addresses are sent as unspecified.
+	h.Source, _ = netip.ParseAddrPort(req.RemoteAddr)
+	if a, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		h.Destination, _ = netip.ParseAddrPort(a.String())
+	}
+	// The auth token identity takes precedence over the TLS certificate one.
+	id := d.Identity
+	if c != nil && c.Identity != "" {
+		id = c.Identity
+	}
+	if id != "" {
+		h.TLVs = append(h.TLVs, proxyproto.TLV{Type: proxyproto.TypeIdentity, Value: []byte(id)})
+	}
+	if _, err := h.WriteTo(ssh); err != nil {
+		return fmt.Errorf("WriteTo(%v) error: %w", ssh.RemoteAddr(), err)
+	}
+	return nil
+}
+
+// proxyProtocol returns whether a PROXY protocol header is to be sent to a destination.
+func (r *Runner) proxyProtocol(d policy.Destination) bool {
+	for _, m := range r.current().proxyProtocol {
+		if m.Match(d) {
+			return true
+		}
+	}
+	return false
+}
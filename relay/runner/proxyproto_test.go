@@ -0,0 +1,98 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/destinationpb"
+)
+
+// headerListener accepts a single connection, sending whatever is read before the deadline.
+func headerListener(t *testing.T) (string, <-chan []byte) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	c := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		b, _ := io.ReadAll(conn)
+		c <- b
+	}()
+	return fmt.Sprint(l.Addr().(*net.TCPAddr).Port), c
+}
+
+func TestProxyHandle_ProxyProtocol(t *testing.T) {
+	testdata := []struct {
+		name string
+		glob string
+		want bool
+	}{
+		{
+			name: "enabled",
+			glob: "127.0.0.1",
+			want: true,
+		},
+		{
+			name: "other destination",
+			glob: "*.example.org",
+		},
+	}
+	for _, tt := range testdata {
+		port, got := headerListener(t)
+		r := newRunner()
+		m, err := policy.NewMatcher(&destinationpb.DestinationMatcher{Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: tt.glob}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.current().proxyProtocol = []*policy.Matcher{m}
+		req := httptest.NewRequest("GET", "/proxy?host=127.0.0.1&port="+port, nil)
+		req.RemoteAddr = "192.0.2.1:40000"
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 8022}))
+		req.AddCookie(originCookie)
+		resp := testProxyHandle(r, req)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("proxyHandle(%v) status code = %v, want %v", tt.name, resp.StatusCode, http.StatusOK)
+			continue
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sid, _, _ := bytes.Cut(b, []byte("."))
+		hdr := <-got
+		if !tt.want {
+			if len(hdr) != 0 {
+				t.Errorf("proxyHandle(%v) sent %q, want nothing", tt.name, hdr)
+			}
+			continue
+		}
+		var want []byte
+		want = append(want, "\r\n\r\n\x00\r\nQUIT\n\x21\x11"...)
+		want = binary.BigEndian.AppendUint16(want, uint16(12+3+len(sid)))
+		want = append(want, 192, 0, 2, 1, 198, 51, 100, 1, 0x9c, 0x40, 0x1f, 0x56)
+		want = append(want, 0x05)
+		want = binary.BigEndian.AppendUint16(want, uint16(len(sid)))
+		want = append(want, sid...)
+		if !bytes.Equal(hdr, want) {
+			t.Errorf("proxyHandle(%v) header = %q, want %q", tt.name, hdr, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hazaelsan/ssh-relay/duration"
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+	"github.com/hazaelsan/ssh-relay/relay/session/manager"
+
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+// DefaultRetryAfter is how long clients over a session quota are asked to wait if retry_after is unset.
+const DefaultRetryAfter = 30 * time.Second
+
+// destinationQuota caps the sessions to each destination matching m.
+type destinationQuota struct {
+	m   *policy.Matcher
+	max int
+}
+
+// quotas builds manager.Quotas from a proto config message, along with the Retry-After delay for rejections.
+func quotas(pb *configpb.Config_SessionQuotas) (manager.Quotas, time.Duration, error) {
+	q := manager.Quotas{
+		PerOrigin:   int(pb.GetMaxPerOrigin()),
+		PerClientIP: int(pb.GetMaxPerClientIp()),
+		PerIdentity: int(pb.GetMaxPerIdentity()),
+	}
+	retryAfter := DefaultRetryAfter
+	if err := duration.FromProto(&retryAfter, pb.GetRetryAfter()); err != nil {
+		return q, 0, fmt.Errorf("duration.FromProto(%v) error: %w", pb.GetRetryAfter(), err)
+	}
+	var dqs []destinationQuota
+	for i, dpb := range pb.GetDestinationQuotas() {
+		if dpb.GetMaxSessions() <= 0 {
+			return q, 0, fmt.Errorf("destination_quotas %v: max_sessions %v <= 0", i, dpb.GetMaxSessions())
+		}
+		m, err := policy.NewMatcher(dpb.GetMatch())
+		if err != nil {
+			return q, 0, fmt.Errorf("destination_quotas %v: policy.NewMatcher() error: %w", i, err)
+		}
+		dqs = append(dqs, destinationQuota{m: m, max: int(dpb.GetMaxSessions())})
+	}
+	if len(dqs) > 0 {
+		q.PerDestination = func(md manager.Metadata) int {
+			host, port, err := net.SplitHostPort(md.Destination)
+			if err != nil {
+				return 0
+			}
+			d := policy.Destination{Host: host, Port: port, Origin: md.Origin, Identity: md.Identity}
+			for _, dq := range dqs {
+				if dq.m.Match(d) {
+					return dq.max
+				}
+			}
+			return 0
+		}
+	}
+	return q, retryAfter, nil
+}
+
+// sessionStatus returns the HTTP status code for an error admitting or creating a session.
+// Quota rejections get 429 and a Retry-After header, any other error 503.
+func (r *Runner) sessionStatus(w http.ResponseWriter, err error) int {
+	if !errors.Is(err, manager.ErrQuota) {
+		return http.StatusServiceUnavailable
+	}
+	secs := math.Ceil(r.current().retryAfter.Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(int(secs)))
+	return http.StatusTooManyRequests
+}
@@ -0,0 +1,139 @@
+package runner
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/ssh-relay/relay/session/manager"
+	"github.com/hazaelsan/ssh-relay/session"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/destinationpb"
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+func TestQuotas(t *testing.T) {
+	testdata := []struct {
+		name       string
+		pb         *configpb.Config_SessionQuotas
+		md         manager.Metadata
+		maxDst     int
+		retryAfter time.Duration
+		ok         bool
+	}{
+		{
+			name:       "unset",
+			md:         manager.Metadata{Destination: "example.org:22"},
+			retryAfter: DefaultRetryAfter,
+			ok:         true,
+		},
+		{
+			name: "destination quotas",
+			pb: &configpb.Config_SessionQuotas{
+				DestinationQuotas: []*configpb.Config_SessionQuotas_DestinationQuota{
+					{
+						Match:       &destinationpb.DestinationMatcher{Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "*.example.com"}},
+						MaxSessions: 1,
+					},
+					{
+						Match:       &destinationpb.DestinationMatcher{Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "*.example.org"}},
+						MaxSessions: 2,
+					},
+				},
+				RetryAfter: &durationpb.Duration{Seconds: 5},
+			},
+			md:         manager.Metadata{Destination: "foo.example.org:22"},
+			maxDst:     2,
+			retryAfter: 5 * time.Second,
+			ok:         true,
+		},
+		{
+			name: "no matching destination quota",
+			pb: &configpb.Config_SessionQuotas{
+				DestinationQuotas: []*configpb.Config_SessionQuotas_DestinationQuota{
+					{
+						Match:       &destinationpb.DestinationMatcher{Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "*.example.com"}},
+						MaxSessions: 1,
+					},
+				},
+			},
+			md:         manager.Metadata{Destination: "foo.example.org:22"},
+			retryAfter: DefaultRetryAfter,
+			ok:         true,
+		},
+		{
+			name: "bad max_sessions",
+			pb: &configpb.Config_SessionQuotas{
+				DestinationQuotas: []*configpb.Config_SessionQuotas_DestinationQuota{{}},
+			},
+		},
+		{
+			name: "bad matcher",
+			pb: &configpb.Config_SessionQuotas{
+				DestinationQuotas: []*configpb.Config_SessionQuotas_DestinationQuota{
+					{
+						Match:       &destinationpb.DestinationMatcher{Host: &destinationpb.DestinationMatcher_HostGlob{HostGlob: "["}},
+						MaxSessions: 1,
+					},
+				},
+			},
+		},
+		{
+			name: "bad retry_after",
+			pb: &configpb.Config_SessionQuotas{
+				RetryAfter: &durationpb.Duration{Seconds: -1},
+			},
+		},
+	}
+	for _, tt := range testdata {
+		q, retryAfter, err := quotas(tt.pb)
+		if err != nil {
+			if tt.ok {
+				t.Errorf("quotas(%v) error = %v", tt.name, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("quotas(%v) error = nil", tt.name)
+		}
+		if retryAfter != tt.retryAfter {
+			t.Errorf("quotas(%v) retryAfter = %v, want %v", tt.name, retryAfter, tt.retryAfter)
+		}
+		maxDst := 0
+		if q.PerDestination != nil {
+			maxDst = q.PerDestination(tt.md)
+		}
+		if maxDst != tt.maxDst {
+			t.Errorf("quotas(%v) PerDestination(%v) = %v, want %v", tt.name, tt.md.Destination, maxDst, tt.maxDst)
+		}
+	}
+}
+
+func TestProxyHandle_Quota(t *testing.T) {
+	p, _ := net.Pipe()
+	defer p.Close()
+	done := make(chan struct{})
+	port := listener(t, done)
+	defer close(done)
+	r := newRunner()
+	r.mgr.SetLimits(0, time.Minute, 0)
+	r.mgr.SetQuotas(manager.Quotas{PerOrigin: 1})
+	r.current().retryAfter = 1500 * time.Millisecond
+	md := manager.Metadata{Destination: "example.org:22", Origin: originCookie.Value}
+	if _, err := r.mgr.New(p, session.CorpRelay, md); err != nil {
+		t.Fatalf("mgr.New() error = %v", err)
+	}
+	req := httptest.NewRequest("GET", fmt.Sprintf("/proxy?host=localhost&port=%v", port), nil)
+	req.AddCookie(originCookie)
+	resp := testProxyHandle(r, req)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("proxyHandle() status code = %v, want %v", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "2" {
+		t.Errorf("proxyHandle() Retry-After = %q, want %q", got, "2")
+	}
+}
@@ -0,0 +1,138 @@
+package runner
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hazaelsan/ssh-relay/duration"
+	"github.com/hazaelsan/ssh-relay/relay/dialer"
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+	"github.com/hazaelsan/ssh-relay/relay/session/manager"
+	"github.com/hazaelsan/ssh-relay/reload"
+	"github.com/hazaelsan/ssh-relay/session"
+	"github.com/hazaelsan/ssh-relay/token"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/protocolversionpb"
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+// restartFields are the config fields that only take effect after a restart.
+var restartFields = []protoreflect.Name{"server_options", "admin_options", "metrics_options"}
+
+// settings is the reloadable state of a Runner, derived from its config.
+type settings struct {
+	cfg             *configpb.Config
+	policy          *policy.Policy
+	dialer          *dialer.Dialer
+	verifier        *token.Verifier
+	proxyProtocol   []*policy.Matcher
+	identitySources []configpb.Config_ClientIdentity_Source
+	maxAge          time.Duration
+	gracePeriod     time.Duration
+	drainTimeout    time.Duration
+	attachTimeout   time.Duration
+	idleTimeout     time.Duration
+	keepalive       session.Keepalive
+	quotas          manager.Quotas
+	retryAfter      time.Duration
+	admission       manager.Admission
+	load            *loadShedder
+}
+
+// newSettings validates a config and creates the state derived from it.
+func newSettings(cfg *configpb.Config) (*settings, error) {
+	s := &settings{cfg: cfg}
+	for dst, src := range map[*time.Duration]*durationpb.Duration{
+		&s.maxAge:        cfg.GetMaxSessionAge(),
+		&s.gracePeriod:   cfg.GetReconnectGracePeriod(),
+		&s.drainTimeout:  cfg.GetDrainTimeout(),
+		&s.attachTimeout: cfg.GetAttachTimeout(),
+		&s.idleTimeout:   cfg.GetIdleTimeout(),
+	} {
+		if err := duration.FromProto(dst, src); err != nil {
+			return nil, fmt.Errorf("duration.FromProto(%v) error = %w", src, err)
+		}
+	}
+	var err error
+	if s.policy, err = policy.New(cfg.GetDestinationPolicy()); err != nil {
+		return nil, fmt.Errorf("policy.New() error = %w", err)
+	}
+	if s.dialer, err = dialer.New(cfg.GetClientOptions()); err != nil {
+		return nil, fmt.Errorf("dialer.New() error = %w", err)
+	}
+	for i, pb := range cfg.GetClientOptions().GetProxyProtocol() {
+		m, err := policy.NewMatcher(pb)
+		if err != nil {
+			return nil, fmt.Errorf("proxy_protocol %v: policy.NewMatcher() error = %w", i, err)
+		}
+		s.proxyProtocol = append(s.proxyProtocol, m)
+	}
+	if s.keepalive, err = session.NewKeepalive(cfg.GetWebsocketOptions()); err != nil {
+		return nil, fmt.Errorf("session.NewKeepalive() error = %w", err)
+	}
+	if s.quotas, s.retryAfter, err = quotas(cfg.GetSessionQuotas()); err != nil {
+		return nil, fmt.Errorf("quotas() error = %w", err)
+	}
+	if s.admission, err = admission(cfg.GetAdmission(), int(cfg.GetMaxSessions()), cfg.GetAuthToken() != nil); err != nil {
+		return nil, fmt.Errorf("admission() error = %w", err)
+	}
+	if s.load, err = newLoadShedder(cfg.GetAdmission().GetLoadShedding()); err != nil {
+		return nil, fmt.Errorf("newLoadShedder() error = %w", err)
+	}
+	if s.identitySources, err = identitySources(cfg.GetClientIdentity()); err != nil {
+		return nil, fmt.Errorf("identitySources() error = %w", err)
+	}
+	if cfg.GetAuthToken() != nil {
+		if s.verifier, err = token.NewVerifier(cfg.GetAuthToken()); err != nil {
+			return nil, fmt.Errorf("token.NewVerifier() error = %w", err)
+		}
+	}
+	return s, nil
+}
+
+// current returns the current settings.
+func (r *Runner) current() *settings {
+	return r.settings.Load()
+}
+
+// Reload validates a new config and applies it atomically, new requests use it right away.
+// Sessions keep the limits they were created with; changes to listener settings need a restart.
+// The current config is kept on error.
+func (r *Runner) Reload(cfg *configpb.Config) error {
+	s, err := newSettings(cfg)
+	if err != nil {
+		return err
+	}
+	old := r.settings.Swap(s)
+	r.mgr.SetLimits(int(cfg.GetMaxSessions()), s.maxAge, s.gracePeriod)
+	r.mgr.SetIdleTimeout(s.idleTimeout)
+	r.mgr.SetKeepalive(s.keepalive)
+	r.mgr.SetQuotas(s.quotas)
+	r.mgr.SetAdmission(s.admission)
+	reload.LogRestartFields(old.cfg, cfg, restartFields...)
+	return nil
+}
+
+// protocolEnabled returns whether a protocol version is enabled in the current config.
+func (r *Runner) protocolEnabled(pv protocolversionpb.ProtocolVersion) bool {
+	for _, v := range r.current().cfg.GetProtocolVersions() {
+		if v == pv {
+			return true
+		}
+	}
+	return false
+}
+
+// withProtocol wraps a handler, responding with a 404 if the protocol version is disabled in the current config.
+func (r *Runner) withProtocol(pv protocolversionpb.ProtocolVersion, h func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !r.protocolEnabled(pv) {
+			http.NotFound(w, req)
+			return
+		}
+		h(w, req)
+	}
+}
@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/protocolversionpb"
+	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
+)
+
+func TestReload_Error(t *testing.T) {
+	testdata := []struct {
+		name string
+		cfg  *configpb.Config
+	}{
+		{
+			name: "bad max_session_age",
+			cfg:  &configpb.Config{MaxSessionAge: &durationpb.Duration{Seconds: -1}},
+		},
+		{
+			name: "bad drain_timeout",
+			cfg:  &configpb.Config{DrainTimeout: &durationpb.Duration{Seconds: -1}},
+		},
+		{
+			name: "bad session_quotas",
+			cfg: &configpb.Config{
+				SessionQuotas: &configpb.Config_SessionQuotas{
+					DestinationQuotas: []*configpb.Config_SessionQuotas_DestinationQuota{{MaxSessions: -1}},
+				},
+			},
+		},
+		{
+			name: "bad reserved_sessions",
+			cfg: &configpb.Config{
+				MaxSessions: 1,
+				Admission:   &configpb.Config_Admission{ReservedSessions: 1},
+			},
+		},
+		{
+			name: "bad source address",
+			cfg: &configpb.Config{
+				ClientOptions: &configpb.Config_ClientOptions{SourceAddress: "bad"},
+			},
+		},
+	}
+	for _, tt := range testdata {
+		r := newRunner()
+		old := r.current()
+		if err := r.Reload(tt.cfg); err == nil {
+			t.Errorf("Reload(%v) error = nil", tt.name)
+		}
+		if r.current() != old {
+			t.Errorf("Reload(%v) replaced the current settings", tt.name)
+		}
+	}
+}
+
+func TestWithProtocol(t *testing.T) {
+	testdata := []struct {
+		versions []protocolversionpb.ProtocolVersion
+		wantCode int
+	}{
+		{
+			versions: []protocolversionpb.ProtocolVersion{protocolversionpb.ProtocolVersion_CORP_RELAY},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			versions: []protocolversionpb.ProtocolVersion{protocolversionpb.ProtocolVersion_CORP_RELAY_V4},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			wantCode: http.StatusNotFound,
+		},
+	}
+	h := func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	for _, tt := range testdata {
+		r := newRunner()
+		r.current().cfg.ProtocolVersions = tt.versions
+		w := httptest.NewRecorder()
+		r.withProtocol(protocolversionpb.ProtocolVersion_CORP_RELAY, h)(w, httptest.NewRequest("GET", "/proxy", nil))
+		if got := w.Result().StatusCode; got != tt.wantCode {
+			t.Errorf("withProtocol(%v) status code = %v, want %v", tt.versions, got, tt.wantCode)
+		}
+	}
+}
@@ -2,60 +2,122 @@ package runner
 
 import (
 	"fmt"
-	"time"
+	"net"
+	"sync"
+	"sync/atomic"
 
-	"github.com/hazaelsan/ssh-relay/duration"
+	"github.com/golang/glog"
+	"github.com/google/uuid"
 	"github.com/hazaelsan/ssh-relay/http"
+	"github.com/hazaelsan/ssh-relay/metrics"
+	"github.com/hazaelsan/ssh-relay/relay/policy"
 	"github.com/hazaelsan/ssh-relay/relay/session/manager"
+	"github.com/hazaelsan/ssh-relay/token"
+	"google.golang.org/grpc"
 
 	"github.com/hazaelsan/ssh-relay/proto/v1/protocolversionpb"
 	"github.com/hazaelsan/ssh-relay/relay/proto/v1/configpb"
 )
 
-func protocolEnabled(cfg *configpb.Config, pv protocolversionpb.ProtocolVersion) bool {
-	for _, v := range cfg.GetProtocolVersions() {
-		if v == pv {
-			return true
-		}
-	}
-	return false
-}
-
 // New instantiates a Runner with a *configpb.Config.
 func New(cfg *configpb.Config) (*Runner, error) {
 	s, err := http.NewServer(cfg.ServerOptions)
 	if err != nil {
 		return nil, fmt.Errorf("http.NewServer() error = %w", err)
 	}
-	var maxAge time.Duration
-	if err := duration.FromProto(&maxAge, cfg.MaxSessionAge); err != nil {
-		return nil, fmt.Errorf("duration.FromProto(%v) error = %w", cfg.MaxSessionAge, err)
+	st, err := newSettings(cfg)
+	if err != nil {
+		return nil, err
 	}
 	r := &Runner{
-		cfg:    cfg,
-		mgr:    manager.New(int(cfg.MaxSessions), maxAge),
+		mgr:    manager.New(int(cfg.GetMaxSessions()), st.maxAge, st.gracePeriod),
 		server: s,
 	}
-
-	if protocolEnabled(cfg, protocolversionpb.ProtocolVersion_CORP_RELAY) {
-		s.HandleFunc("/connect", r.connectHandle)
-		s.HandleFunc("/proxy", r.proxyHandle)
+	r.settings.Store(st)
+	r.mgr.SetIdleTimeout(st.idleTimeout)
+	r.mgr.SetKeepalive(st.keepalive)
+	r.mgr.SetQuotas(st.quotas)
+	r.mgr.SetAdmission(st.admission)
+	if cfg.GetAdminOptions() != nil {
+		if r.admin, err = newAdminServer(cfg.GetAdminOptions(), r.mgr, r); err != nil {
+			return nil, fmt.Errorf("newAdminServer() error = %w", err)
+		}
 	}
-	if protocolEnabled(cfg, protocolversionpb.ProtocolVersion_CORP_RELAY_V4) {
-		s.HandleFunc("/v4/connect", r.connectHandleV4)
+	if cfg.GetMetricsOptions() != nil {
+		if r.metrics, err = http.NewServer(cfg.GetMetricsOptions()); err != nil {
+			return nil, fmt.Errorf("http.NewServer() error = %w", err)
+		}
+		r.metrics.HandleFunc("/metrics", metrics.DefaultRegistry.ServeHTTP)
 	}
 
+	// Handlers are registered for all protocol versions, which are enabled per the current config.
+	s.HandleFunc("/readyz", r.readyHandle)
+	s.HandleFunc("/connect", r.withProtocol(protocolversionpb.ProtocolVersion_CORP_RELAY, r.connectHandle))
+	s.HandleFunc("/proxy", r.withProtocol(protocolversionpb.ProtocolVersion_CORP_RELAY, r.proxyHandle))
+	s.HandleFunc("/v4/connect", r.withProtocol(protocolversionpb.ProtocolVersion_CORP_RELAY_V4, r.connectHandleV4))
+	s.HandleFunc("/v4/reconnect", r.withProtocol(protocolversionpb.ProtocolVersion_CORP_RELAY_V4, r.reconnectHandleV4))
+
 	return r, nil
 }
 
 // Runner is the main SSH-over-WebSocket Relay connection handler.
 type Runner struct {
-	cfg    *configpb.Config
-	mgr    *manager.Manager
-	server *http.Server
+	settings atomic.Pointer[settings]
+	mgr      *manager.Manager
+	bindings sync.Map // Session ID -> *connect.Binding, for corp-relay@google.com sessions.
+	server   *http.Server
+	admin    *grpc.Server
+	metrics  *http.Server
+
+	stateMu        sync.RWMutex
+	draining       bool
+	maintenance    bool
+	maintenanceMsg string
+}
+
+// checkDestination enforces the destination policy and the destinations allowed by the auth token,
+// rejections are always logged.
+func (r *Runner) checkDestination(d policy.Destination, c *token.Claims) error {
+	if err := r.current().policy.Check(d); err != nil {
+		glog.Warningf("Rejected connection request: %v", err)
+		return err
+	}
+	if err := checkClaims(c, d); err != nil {
+		glog.Warningf("Rejected connection request: %v", err)
+		return err
+	}
+	return nil
+}
+
+// checkSessionDestination checks the destination of an existing Session is still allowed for a client,
+// per the current policy and the client's auth token, see checkDestination.
+func (r *Runner) checkSessionDestination(sid uuid.UUID, origin, id string, c *token.Claims) error {
+	info, err := r.mgr.Info(sid)
+	if err != nil {
+		return fmt.Errorf("mgr.Info(%v) error: %w", sid, err)
+	}
+	host, port, err := net.SplitHostPort(info.Destination)
+	if err != nil {
+		return fmt.Errorf("net.SplitHostPort(%v) error: %w", info.Destination, err)
+	}
+	return r.checkDestination(policy.Destination{Host: host, Port: port, Origin: origin, Identity: id}, c)
 }
 
 // Run executes the runner, listens for incoming client connections.
+// The admin and metrics servers, if enabled, are served alongside; Run returns as soon as any of them fails.
 func (r *Runner) Run() error {
-	return r.server.Run()
+	runners := []func() error{r.server.Run}
+	if r.admin != nil {
+		runners = append(runners, r.runAdmin)
+	}
+	if r.metrics != nil {
+		runners = append(runners, r.metrics.Run)
+	}
+	errc := make(chan error, len(runners))
+	for _, run := range runners {
+		go func() {
+			errc <- run()
+		}()
+	}
+	return <-errc
 }
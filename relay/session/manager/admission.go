@@ -0,0 +1,194 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hazaelsan/ssh-relay/session"
+)
+
+var (
+	// ErrQueueTimeout is returned when a request times out waiting for a Session slot in the admission queue.
+	ErrQueueTimeout = fmt.Errorf("%w: timed out in admission queue", ErrSessionLimit)
+
+	// ErrReleased is returned when using a Reservation after it has been released.
+	ErrReleased = errors.New("reservation released")
+)
+
+// Admission controls how new Sessions are admitted once the session limit is reached.
+type Admission struct {
+	// QueueSize is the maximum number of requests waiting for a Session slot, requests are not queued if <= 0.
+	QueueSize int
+
+	// QueueTimeout is how long a request may wait in the queue, requests are not queued if <= 0.
+	QueueTimeout time.Duration
+
+	// Reserved is the number of Session slots only privileged Sessions may use.
+	Reserved int
+
+	// Privileged returns whether a Session with md may use reserved slots, may be nil.
+	Privileged func(md Metadata) bool
+}
+
+// A Reservation holds a Session slot for a client, see Manager.Reserve.
+type Reservation struct {
+	m  *Manager
+	md Metadata
+}
+
+// A waiter is a request waiting in the admission queue.
+type waiter struct {
+	md    Metadata
+	ready chan struct{} // Closed once r or err is set.
+	r     *Reservation
+	err   error
+}
+
+// Reserve holds a Session slot for md, allowing requests to be rejected before connecting to the SSH server.
+// If the session limit is reached the request waits in the admission queue, if any, until a slot is granted to it,
+// ctx is done, or the queue timeout expires; freed slots are granted to queued requests in arrival order.
+// Returns ErrSessionLimit, ErrQueueTimeout or an error wrapping ErrQuota if no slot is available.
+// The returned Reservation MUST be released once it's no longer needed.
+func (m *Manager) Reserve(ctx context.Context, md Metadata) (*Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped != nil {
+		return nil, m.stopped
+	}
+	// Queued requests are granted slots as soon as they're freed, so newcomers can only get slots
+	// none of them can use (e.g., reserved ones).
+	err := m.admit(md)
+	if err == nil {
+		return m.reserve(md), nil
+	}
+	if !errors.Is(err, ErrSessionLimit) {
+		return nil, err
+	}
+	a := m.admission
+	if a.QueueSize <= 0 || a.QueueTimeout <= 0 || len(m.waiters) >= a.QueueSize {
+		limitRejections.With().Inc()
+		return nil, err
+	}
+	w := &waiter{md: md, ready: make(chan struct{})}
+	m.waiters = append(m.waiters, w)
+	queuedRequests.With().Inc()
+	glog.V(2).Infof("Session limit reached, queueing request for up to %v", a.QueueTimeout)
+	t := time.NewTimer(a.QueueTimeout)
+	defer t.Stop()
+	m.mu.Unlock()
+	select {
+	case <-w.ready:
+		m.mu.Lock()
+	case <-t.C:
+		m.mu.Lock()
+		if m.dequeue(w) {
+			queueTimeouts.With().Inc()
+			return nil, ErrQueueTimeout
+		}
+	case <-ctx.Done():
+		m.mu.Lock()
+		if !m.dequeue(w) && w.r != nil {
+			m.release(w.r)
+		}
+		return nil, ctx.Err()
+	}
+	return w.r, w.err
+}
+
+// StopAdmission rejects all queued requests with err, as well as any later Reserve calls, e.g., while draining.
+// Reservations already granted are not affected.
+func (m *Manager) StopAdmission(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = err
+	for _, w := range m.waiters {
+		w.err = err
+		close(w.ready)
+		queuedRequests.With().Dec()
+	}
+	clear(m.waiters)
+	m.waiters = nil
+}
+
+// reserve holds a Session slot for md, m.mu MUST be held.
+func (m *Manager) reserve(md Metadata) *Reservation {
+	r := &Reservation{m: m, md: md}
+	m.reservations[r] = struct{}{}
+	return r
+}
+
+// dequeue removes a waiter from the admission queue, returns false if it was already granted a slot or rejected.
+// m.mu MUST be held.
+func (m *Manager) dequeue(w *waiter) bool {
+	i := slices.Index(m.waiters, w)
+	if i < 0 {
+		return false
+	}
+	m.waiters = slices.Delete(m.waiters, i, i+1)
+	queuedRequests.With().Dec()
+	return true
+}
+
+// New creates and registers a Session from an SSH connection using the reserved slot, releasing the Reservation.
+func (r *Reservation) New(ssh net.Conn, v session.ProtocolVersion) (session.Session, error) {
+	m := r.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.reservations[r]; !ok {
+		return nil, ErrReleased
+	}
+	delete(m.reservations, r)
+	s, err := m.create(ssh, v, r.md)
+	if err != nil {
+		m.free()
+	}
+	return s, err
+}
+
+// Release frees the reserved slot, it's a no-op once the Reservation is used or released.
+func (r *Reservation) Release() {
+	m := r.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.release(r)
+}
+
+// release frees a reserved slot if it's still held, m.mu MUST be held.
+func (m *Manager) release(r *Reservation) {
+	if _, ok := m.reservations[r]; ok {
+		delete(m.reservations, r)
+		m.free()
+	}
+}
+
+// privileged returns whether a Session with md may use reserved slots, m.mu MUST be held.
+func (m *Manager) privileged(md Metadata) bool {
+	return m.admission.Privileged != nil && m.admission.Privileged(md)
+}
+
+// free grants available Session slots to queued requests in arrival order, m.mu MUST be held.
+// Requests that can't use any available slot (e.g., only reserved ones are left) keep their place in the queue,
+// requests over a quota are rejected.
+func (m *Manager) free() {
+	waiters := m.waiters[:0]
+	for _, w := range m.waiters {
+		err := m.admit(w.md)
+		if errors.Is(err, ErrSessionLimit) {
+			waiters = append(waiters, w)
+			continue
+		}
+		if err == nil {
+			w.r = m.reserve(w.md)
+		}
+		w.err = err
+		close(w.ready)
+		queuedRequests.With().Dec()
+	}
+	clear(m.waiters[len(waiters):])
+	m.waiters = waiters
+}
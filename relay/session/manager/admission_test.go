@@ -0,0 +1,223 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/ssh-relay/session"
+)
+
+func TestReserve(t *testing.T) {
+	privileged := Metadata{Origin: "https://admin.example.org"}
+	testdata := []struct {
+		name   string
+		a      Admission
+		md     Metadata
+		delete bool // Delete the existing Session while waiting.
+		want   error
+	}{
+		{
+			name: "no queue",
+			want: ErrSessionLimit,
+		},
+		{
+			name:   "queued",
+			a:      Admission{QueueSize: 1, QueueTimeout: time.Second},
+			delete: true,
+		},
+		{
+			name: "queue timeout",
+			a:    Admission{QueueSize: 1, QueueTimeout: 10 * time.Millisecond},
+			want: ErrQueueTimeout,
+		},
+		{
+			name: "reserved",
+			a: Admission{
+				Reserved:   1,
+				Privileged: func(md Metadata) bool { return md == privileged },
+			},
+			want: ErrSessionLimit,
+		},
+		{
+			name: "privileged",
+			a: Admission{
+				Reserved:   1,
+				Privileged: func(md Metadata) bool { return md == privileged },
+			},
+			md: privileged,
+		},
+	}
+	for _, tt := range testdata {
+		p, _ := net.Pipe()
+		m := New(2, time.Minute, 0)
+		m.SetAdmission(tt.a)
+		s, err := m.New(p, session.CorpRelay, Metadata{})
+		if err != nil {
+			t.Fatalf("New(%v) error = %v", tt.name, err)
+		}
+		if tt.a.Reserved == 0 {
+			if _, err := m.New(p, session.CorpRelay, Metadata{}); err != nil {
+				t.Fatalf("New(%v) error = %v", tt.name, err)
+			}
+		}
+		if tt.delete {
+			time.AfterFunc(10*time.Millisecond, func() { m.Delete(s.SID()) })
+		}
+		res, err := m.Reserve(context.Background(), tt.md)
+		if err != tt.want {
+			t.Errorf("Reserve(%v) error = %v, want %v", tt.name, err, tt.want)
+		}
+		if err != nil && !errors.Is(err, ErrSessionLimit) {
+			t.Errorf("Reserve(%v) error = %v, want %v", tt.name, err, ErrSessionLimit)
+		}
+		if res != nil {
+			res.Release()
+		}
+		m.CloseAll()
+		p.Close()
+	}
+}
+
+func TestReserve_QueueFull(t *testing.T) {
+	p, _ := net.Pipe()
+	defer p.Close()
+	m := New(1, time.Minute, 0)
+	m.SetAdmission(Admission{QueueSize: 1, QueueTimeout: time.Second})
+	if _, err := m.New(p, session.CorpRelay, Metadata{}); err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := m.Reserve(ctx, Metadata{})
+		errc <- err
+	}()
+	// Wait for the first request to be queued.
+	waitQueued(m, 1)
+	start := time.Now()
+	if _, err := m.Reserve(context.Background(), Metadata{}); err != ErrSessionLimit {
+		t.Errorf("Reserve() error = %v, want %v", err, ErrSessionLimit)
+	}
+	if d := time.Since(start); d >= time.Second {
+		t.Errorf("Reserve() took %v, want immediate rejection", d)
+	}
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("Reserve() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestReserve_FIFO(t *testing.T) {
+	p, _ := net.Pipe()
+	defer p.Close()
+	m := New(1, time.Minute, 0)
+	m.SetAdmission(Admission{QueueSize: 2, QueueTimeout: time.Minute})
+	s, err := m.New(p, session.CorpRelay, Metadata{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	type result struct {
+		origin string
+		r      *Reservation
+		err    error
+	}
+	results := make(chan result, 3)
+	reserve := func(origin string) {
+		r, err := m.Reserve(context.Background(), Metadata{Origin: origin})
+		results <- result{origin, r, err}
+	}
+	go reserve("first")
+	waitQueued(m, 1)
+	go reserve("second")
+	waitQueued(m, 2)
+	// The freed slot is granted to the first queued request, newcomers queue behind the rest.
+	m.Delete(s.SID())
+	waitQueued(m, 1)
+	go reserve("third")
+	waitQueued(m, 2)
+	for _, want := range []string{"first", "second", "third"} {
+		got := <-results
+		if got.err != nil || got.origin != want {
+			t.Fatalf("Reserve() = %v, %v, want %v", got.origin, got.err, want)
+		}
+		got.r.Release()
+	}
+}
+
+func TestStopAdmission(t *testing.T) {
+	p, _ := net.Pipe()
+	defer p.Close()
+	m := New(1, time.Minute, 0)
+	m.SetAdmission(Admission{QueueSize: 1, QueueTimeout: time.Minute})
+	s, err := m.New(p, session.CorpRelay, Metadata{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := m.Reserve(context.Background(), Metadata{})
+		errc <- err
+	}()
+	waitQueued(m, 1)
+	stop := errors.New("stopped")
+	m.StopAdmission(stop)
+	if err := <-errc; err != stop {
+		t.Errorf("Reserve(queued) error = %v, want %v", err, stop)
+	}
+	// Freed slots are not granted anymore.
+	m.Delete(s.SID())
+	if _, err := m.Reserve(context.Background(), Metadata{}); err != stop {
+		t.Errorf("Reserve() error = %v, want %v", err, stop)
+	}
+}
+
+// waitQueued waits for n requests to be in the admission queue.
+func waitQueued(m *Manager, n int) {
+	for {
+		m.mu.Lock()
+		queued := len(m.waiters)
+		m.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReservation(t *testing.T) {
+	p, _ := net.Pipe()
+	defer p.Close()
+	m := New(1, time.Minute, 0)
+	res, err := m.Reserve(context.Background(), Metadata{})
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	// The reserved slot counts towards the session limit.
+	if _, err := m.New(p, session.CorpRelay, Metadata{}); err != ErrSessionLimit {
+		t.Errorf("New() error = %v, want %v", err, ErrSessionLimit)
+	}
+	res.Release()
+	if _, err := res.New(p, session.CorpRelay); err != ErrReleased {
+		t.Errorf("Reservation.New() error = %v, want %v", err, ErrReleased)
+	}
+	res, err = m.Reserve(context.Background(), Metadata{})
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	s, err := res.New(p, session.CorpRelay)
+	if err != nil {
+		t.Fatalf("Reservation.New() error = %v", err)
+	}
+	defer s.Close()
+	// Releasing a used Reservation is a no-op.
+	res.Release()
+	if n := m.Len(); n != 1 {
+		t.Errorf("Len() = %v, want 1", n)
+	}
+	if _, err := m.Reserve(context.Background(), Metadata{}); err != ErrSessionLimit {
+		t.Errorf("Reserve() error = %v, want %v", err, ErrSessionLimit)
+	}
+}
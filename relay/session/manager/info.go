@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hazaelsan/ssh-relay/metrics"
+	"github.com/hazaelsan/ssh-relay/session"
+)
+
+// Metadata describes where a Session connects from and to.
+type Metadata struct {
+	// Destination is the SSH server address, in host:port format.
+	Destination string
+
+	// Origin is the client origin, e.g., chrome-extension://<ext-id>.
+	Origin string
+
+	// Identity is the client identity from its TLS certificate, if any.
+	Identity string
+
+	// ClientIP is the client IP address.
+	ClientIP string
+}
+
+// Info is a point-in-time snapshot of a Session.
+type Info struct {
+	Metadata
+
+	// SID is the Session ID.
+	SID uuid.UUID
+
+	// Version is the protocol version in use for the Session.
+	Version session.ProtocolVersion
+
+	// Start is the time the Session was created.
+	Start time.Time
+
+	// BytesToClient is the amount of data read from the SSH server.
+	BytesToClient uint64
+
+	// BytesToServer is the amount of data written to the SSH server.
+	BytesToServer uint64
+
+	// Suspended is true if the Session is disconnected, waiting to be resumed.
+	Suspended bool
+}
+
+// newCounter wraps an SSH connection in a *counter, v is used for exporting metrics.
+func newCounter(conn net.Conn, v session.ProtocolVersion) *counter {
+	return &counter{
+		Conn:     conn,
+		toClient: sessionBytes.With(v.String(), "to_client"),
+		toServer: sessionBytes.With(v.String(), "to_server"),
+	}
+}
+
+// counter is a net.Conn keeping track of the amount of data read/written.
+type counter struct {
+	net.Conn
+	read     atomic.Uint64
+	written  atomic.Uint64
+	toClient metrics.Counter
+	toServer metrics.Counter
+}
+
+func (c *counter) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(uint64(n))
+	c.toClient.Add(float64(n))
+	return n, err
+}
+
+func (c *counter) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(uint64(n))
+	c.toServer.Add(float64(n))
+	return n, err
+}
+
+// entry is a registered Session.
+type entry struct {
+	s     session.Session
+	md    Metadata
+	start time.Time
+	conn  *counter
+}
+
+// info returns a snapshot of the entry, suspended indicates whether the Session is suspended.
+func (e *entry) info(suspended bool) Info {
+	return Info{
+		Metadata:      e.md,
+		SID:           e.s.SID(),
+		Version:       e.s.Version(),
+		Start:         e.start,
+		BytesToClient: e.conn.read.Load(),
+		BytesToServer: e.conn.written.Load(),
+		Suspended:     suspended,
+	}
+}
@@ -3,7 +3,9 @@ package manager
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"slices"
 	"sync"
 	"time"
 
@@ -20,14 +22,25 @@ var (
 
 	// ErrSessionLimit is returned when the maximum session limit is reached.
 	ErrSessionLimit = errors.New("session limit reached")
+
+	// ErrNotSuspended is returned when resuming a Session that can't be resumed.
+	ErrNotSuspended = errors.New("session not suspended")
+
+	// ErrPrincipalMismatch is returned when resuming a Session created by a different client origin or identity.
+	ErrPrincipalMismatch = errors.New("session principal mismatch")
 )
 
-// New instantiates a *Manager with a limit of sessions and individual session age.
-func New(maxSessions int, maxAge time.Duration) *Manager {
+// New instantiates a *Manager with a limit of sessions, individual session age,
+// and the grace period for resuming disconnected sessions.
+func New(maxSessions int, maxAge, gracePeriod time.Duration) *Manager {
 	return &Manager{
-		maxSessions: maxSessions,
-		maxAge:      maxAge,
-		sessions:    make(map[uuid.UUID]session.Session),
+		maxSessions:  maxSessions,
+		maxAge:       maxAge,
+		gracePeriod:  gracePeriod,
+		sessions:     make(map[uuid.UUID]*entry),
+		suspended:    make(map[uuid.UUID]*time.Timer),
+		watchers:     make(map[chan Event]struct{}),
+		reservations: make(map[*Reservation]struct{}),
 	}
 }
 
@@ -35,33 +48,86 @@ func New(maxSessions int, maxAge time.Duration) *Manager {
 // It enforces a session limit as well as individual session lifetimes.
 type Manager struct {
 	maxAge      time.Duration
+	idleTimeout time.Duration
+	keepalive   session.Keepalive
+	quotas      Quotas
+	admission   Admission
+	gracePeriod time.Duration
 	maxSessions int
-	sessions    map[uuid.UUID]session.Session
+	sessions    map[uuid.UUID]*entry
+	suspended   map[uuid.UUID]*time.Timer
+	watchers    map[chan Event]struct{}
 	mu          sync.RWMutex
+
+	reservations map[*Reservation]struct{}
+	waiters      []*waiter // Requests waiting in the admission queue, in arrival order.
+	stopped      error     // Returned to all Reserve calls once set, see StopAdmission.
+}
+
+// admit checks the session limit and quotas for a new Session with md, m.mu MUST be held.
+// Reserved slots only count towards the session limit of privileged Sessions.
+func (m *Manager) admit(md Metadata) error {
+	if m.maxSessions > 0 {
+		limit := m.maxSessions
+		if !m.privileged(md) {
+			limit -= m.admission.Reserved
+		}
+		if len(m.sessions)+len(m.reservations) >= limit {
+			return ErrSessionLimit
+		}
+	}
+	return m.checkQuotas(md)
 }
 
-// New creates and registers a Session from an SSH connection.
-func (m *Manager) New(ssh net.Conn, v session.ProtocolVersion) (session.Session, error) {
+// New creates and registers a Session from an SSH connection, without queueing.
+// Returns ErrSessionLimit or an error wrapping ErrQuota if the Session is not admitted, ssh is not closed.
+func (m *Manager) New(ssh net.Conn, v session.ProtocolVersion, md Metadata) (session.Session, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if m.maxSessions > 0 && len(m.sessions) >= m.maxSessions {
-		return nil, ErrSessionLimit
+	if err := m.admit(md); err != nil {
+		if errors.Is(err, ErrSessionLimit) {
+			limitRejections.With().Inc()
+		}
+		return nil, err
 	}
+	return m.create(ssh, v, md)
+}
+
+// create creates and registers a Session from an SSH connection, m.mu MUST be held.
+func (m *Manager) create(ssh net.Conn, v session.ProtocolVersion, md Metadata) (session.Session, error) {
+	conn := newCounter(ssh, v)
 	var s session.Session
 	switch v {
 	case session.CorpRelay:
-		s = corprelay.New(ssh)
+		cs := corprelay.New(conn)
+		cs.SetIdentity(md.Identity)
+		cs.SetKeepalive(m.keepalive)
+		s = cs
 	case session.CorpRelayV4:
-		s = corprelayv4.New(ssh, session.Server)
+		cs := corprelayv4.New(conn, session.Server)
+		cs.SetIdentity(md.Identity)
+		cs.SetKeepalive(m.keepalive)
+		s = cs
 	default:
 		return nil, session.ErrBadProtocolVersion
 	}
 	session.SetDeadline(s, m.maxAge)
+	if i, ok := s.(session.Idler); ok && m.idleTimeout > 0 {
+		session.SetIdleTimeout(i, m.idleTimeout)
+	}
 	go func() {
 		<-s.Done()
 		m.Delete(s.SID())
 	}()
-	m.sessions[s.SID()] = s
+	e := &entry{
+		s:     s,
+		md:    md,
+		start: time.Now(),
+		conn:  conn,
+	}
+	m.sessions[s.SID()] = e
+	activeSessions.With(v.String()).Inc()
+	m.notify(Created, e)
 	glog.V(1).Infof("%v/%v active sessions", len(m.sessions), m.maxSessions)
 	return s, nil
 }
@@ -70,22 +136,206 @@ func (m *Manager) New(ssh net.Conn, v session.ProtocolVersion) (session.Session,
 func (m *Manager) Get(sid uuid.UUID) (session.Session, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	s, ok := m.sessions[sid]
+	e, ok := m.sessions[sid]
 	if !ok {
 		return nil, ErrNoSuchSID
 	}
-	return s, nil
+	return e.s, nil
+}
+
+// Info returns a snapshot of the Session with the given UUID.
+func (m *Manager) Info(sid uuid.UUID) (Info, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.sessions[sid]
+	if !ok {
+		return Info{}, ErrNoSuchSID
+	}
+	_, suspended := m.suspended[sid]
+	return e.info(suspended), nil
+}
+
+// List returns a snapshot of all Sessions, oldest first.
+func (m *Manager) List() []Info {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	infos := make([]Info, 0, len(m.sessions))
+	for sid, e := range m.sessions {
+		_, suspended := m.suspended[sid]
+		infos = append(infos, e.info(suspended))
+	}
+	slices.SortFunc(infos, func(a, b Info) int {
+		return a.Start.Compare(b.Start)
+	})
+	return infos
+}
+
+// Len returns the number of registered Sessions, including suspended ones.
+func (m *Manager) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.sessions)
+}
+
+// SetLimits changes the session limit, session age and grace period, as in New.
+// Existing sessions keep the limits they were created or suspended with.
+func (m *Manager) SetLimits(maxSessions int, maxAge, gracePeriod time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxSessions = maxSessions
+	m.maxAge = maxAge
+	m.gracePeriod = gracePeriod
+	m.free()
+}
+
+// SetIdleTimeout sets how long new sessions may go without traffic before being closed, 0 disables it.
+// Existing sessions keep the idle timeout they were created with.
+func (m *Manager) SetIdleTimeout(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.idleTimeout = d
+}
+
+// SetKeepalive sets how new sessions detect dead WebSocket peers.
+// Existing sessions keep the settings they were created with.
+func (m *Manager) SetKeepalive(k session.Keepalive) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keepalive = k
+}
+
+// SetQuotas changes the session quotas, existing sessions are not affected.
+func (m *Manager) SetQuotas(q Quotas) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quotas = q
+}
+
+// SetAdmission changes how new Sessions are admitted once the session limit is reached.
+func (m *Manager) SetAdmission(a Admission) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.admission = a
+	m.free()
+}
+
+// CloseAll closes all Sessions, they're de-registered once closed.
+func (m *Manager) CloseAll() {
+	m.mu.RLock()
+	sessions := make([]session.Session, 0, len(m.sessions))
+	for _, e := range m.sessions {
+		sessions = append(sessions, e.s)
+	}
+	m.mu.RUnlock()
+	for _, s := range sessions {
+		if err := s.Close(); err != nil {
+			glog.Errorf("%v: Close() error: %v", s, err)
+		}
+	}
+}
+
+// Terminate closes the Session with the given UUID, it's de-registered once closed.
+func (m *Manager) Terminate(sid uuid.UUID) error {
+	s, err := m.Get(sid)
+	if err != nil {
+		return err
+	}
+	glog.Infof("%v: Terminating session", s)
+	return s.Close()
 }
 
 // Delete terminates the Session with the given UUID and de-registers it.
 func (m *Manager) Delete(sid uuid.UUID) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	_, ok := m.sessions[sid]
+	e, ok := m.sessions[sid]
 	if !ok {
 		return ErrNoSuchSID
 	}
+	if t, ok := m.suspended[sid]; ok {
+		t.Stop()
+		delete(m.suspended, sid)
+	}
 	delete(m.sessions, sid)
+	m.free()
+	activeSessions.With(e.s.Version().String()).Dec()
+	sessionDurations.With(e.s.Version().String()).Observe(time.Since(e.start).Seconds())
+	m.notify(Terminated, e)
 	glog.V(4).Infof("%v: Session terminated", sid)
 	return nil
 }
+
+// Suspend keeps a disconnected Session around for the grace period, waiting for it to be resumed.
+// Sessions that can't be resumed, or if there's no grace period, are closed right away.
+// Sessions already resumed over a new WebSocket are left alone.
+func (m *Manager) Suspend(sid uuid.UUID) error {
+	m.mu.Lock()
+	e, ok := m.sessions[sid]
+	if !ok {
+		m.mu.Unlock()
+		return ErrNoSuchSID
+	}
+	s, ok := e.s.(session.Resumer)
+	if !ok || m.gracePeriod <= 0 {
+		m.mu.Unlock()
+		return e.s.Close()
+	}
+	defer m.mu.Unlock()
+	if _, ok := m.suspended[sid]; ok || s.Attached() {
+		return nil
+	}
+	grace := m.gracePeriod
+	m.suspended[sid] = time.AfterFunc(grace, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.suspended[sid]; !ok {
+			return
+		}
+		delete(m.suspended, sid)
+		if s.Attached() {
+			// Resumed while being suspended, see Resume.
+			m.notify(Resumed, e)
+			return
+		}
+		glog.V(1).Infof("%v: Session not resumed after %v", s, grace)
+		s.Close()
+	})
+	m.notify(Suspended, e)
+	glog.V(2).Infof("%v: Session suspended for %v", s, m.gracePeriod)
+	return nil
+}
+
+// Resume retrieves a Session for a client to resume it over a new WebSocket, cancelling any pending termination.
+// Only the client origin and identity that created the Session may resume it, ErrPrincipalMismatch is returned otherwise.
+// Sessions still attached to a WebSocket may be resumed too, clients often reconnect before the loss is noticed;
+// Resumer.Resume then replaces the stale WebSocket.
+func (m *Manager) Resume(sid uuid.UUID, md Metadata) (session.Resumer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.sessions[sid]
+	if !ok {
+		return nil, ErrNoSuchSID
+	}
+	s, ok := e.s.(session.Resumer)
+	if !ok {
+		return nil, ErrNotSuspended
+	}
+	select {
+	case <-s.Done():
+		// Closed, about to be deleted.
+		return nil, ErrNoSuchSID
+	default:
+	}
+	if md.Origin != e.md.Origin || md.Identity != e.md.Identity {
+		return nil, fmt.Errorf("%w: origin %v, identity %q, want %v, %q", ErrPrincipalMismatch, md.Origin, md.Identity, e.md.Origin, e.md.Identity)
+	}
+	if t, ok := m.suspended[sid]; ok {
+		if !t.Stop() {
+			// The grace period expired, the Session is being closed.
+			return nil, ErrNoSuchSID
+		}
+		delete(m.suspended, sid)
+	}
+	m.notify(Resumed, e)
+	return s, nil
+}
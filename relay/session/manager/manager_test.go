@@ -1,12 +1,14 @@
 package manager
 
 import (
+	"errors"
 	"net"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/hazaelsan/ssh-relay/session"
+	"github.com/kylelemons/godebug/pretty"
 )
 
 func TestNew(t *testing.T) {
@@ -40,19 +42,15 @@ func TestNew(t *testing.T) {
 	}
 	p, _ := net.Pipe()
 	for i, tt := range testdata {
-		m := &Manager{
-			maxAge:      tt.maxAge,
-			maxSessions: tt.maxSessions,
-			sessions:    make(map[uuid.UUID]session.Session),
-		}
+		m := New(tt.maxSessions, tt.maxAge, 0)
 		// Test up to session limits.
 		for j := 0; j < tt.sessions; j++ {
-			if _, err := m.New(p, session.CorpRelay); err != nil {
+			if _, err := m.New(p, session.CorpRelay, Metadata{}); err != nil {
 				t.Errorf("New(%v, %v) error = %v", i, j, err)
 			}
 		}
 		// Test one past the session limit.
-		if _, err := m.New(p, session.CorpRelay); err != nil {
+		if _, err := m.New(p, session.CorpRelay, Metadata{}); err != nil {
 			if !tt.hasLimit {
 				t.Errorf("New(%v, %v) error = %v", i, tt.sessions, err)
 			}
@@ -62,9 +60,233 @@ func TestNew(t *testing.T) {
 		// Test limits after sessions have expired.
 		if tt.hasLimit {
 			time.Sleep(2 * tt.maxAge)
-			if _, err := m.New(p, session.CorpRelay); err != nil {
+			if _, err := m.New(p, session.CorpRelay, Metadata{}); err != nil {
 				t.Errorf("New(%v) error = %v", i, err)
 			}
 		}
 	}
 }
+
+func TestSuspend(t *testing.T) {
+	testdata := []struct {
+		name        string
+		v           session.ProtocolVersion
+		gracePeriod time.Duration
+		resume      bool
+		ok          bool
+	}{
+		{
+			name:        "resumed",
+			v:           session.CorpRelayV4,
+			gracePeriod: time.Second,
+			resume:      true,
+			ok:          true,
+		},
+		{
+			name:        "grace period expired",
+			v:           session.CorpRelayV4,
+			gracePeriod: 10 * time.Millisecond,
+		},
+		{
+			name:   "no grace period",
+			v:      session.CorpRelayV4,
+			resume: true,
+		},
+		{
+			name:        "not resumable",
+			v:           session.CorpRelay,
+			gracePeriod: time.Second,
+			resume:      true,
+		},
+	}
+	for _, tt := range testdata {
+		p, _ := net.Pipe()
+		m := New(0, time.Minute, tt.gracePeriod)
+		s, err := m.New(p, tt.v, Metadata{})
+		if err != nil {
+			t.Fatalf("New(%v) error = %v", tt.name, err)
+		}
+		if _, err := m.Resume(s.SID(), Metadata{Identity: "other"}); err == nil {
+			t.Errorf("Resume(%v) error = nil", tt.name)
+		}
+		if err := m.Suspend(s.SID()); err != nil {
+			t.Errorf("Suspend(%v) error = %v", tt.name, err)
+		}
+		if !tt.resume {
+			time.Sleep(10 * tt.gracePeriod)
+		}
+		_, err = m.Resume(s.SID(), Metadata{})
+		if err != nil {
+			if tt.ok {
+				t.Errorf("Resume(%v) error = %v", tt.name, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("Resume(%v) error = nil", tt.name)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	md := Metadata{Destination: "example.org:22", Origin: "chrome-extension://foo", Identity: "user@example.org"}
+	testdata := []struct {
+		name string
+		md   Metadata
+		want error
+	}{
+		{
+			name: "same principal",
+			md:   Metadata{Origin: md.Origin, Identity: md.Identity},
+		},
+		{
+			name: "other identity",
+			md:   Metadata{Origin: md.Origin, Identity: "user@example.com"},
+			want: ErrPrincipalMismatch,
+		},
+		{
+			name: "no identity",
+			md:   Metadata{Origin: md.Origin},
+			want: ErrPrincipalMismatch,
+		},
+		{
+			name: "other origin",
+			md:   Metadata{Origin: "chrome-extension://bar", Identity: md.Identity},
+			want: ErrPrincipalMismatch,
+		},
+	}
+	for _, tt := range testdata {
+		p, _ := net.Pipe()
+		m := New(0, time.Minute, time.Minute)
+		s, err := m.New(p, session.CorpRelayV4, md)
+		if err != nil {
+			t.Fatalf("New(%v) error = %v", tt.name, err)
+		}
+		if err := m.Suspend(s.SID()); err != nil {
+			t.Fatalf("Suspend(%v) error = %v", tt.name, err)
+		}
+		if _, err := m.Resume(s.SID(), tt.md); !errors.Is(err, tt.want) {
+			t.Errorf("Resume(%v) error = %v, want %v", tt.name, err, tt.want)
+		}
+		// Only resumed sessions stop being suspended.
+		info, err := m.Info(s.SID())
+		if err != nil {
+			t.Fatalf("Info(%v) error = %v", tt.name, err)
+		}
+		if info.Suspended != (tt.want != nil) {
+			t.Errorf("Info(%v).Suspended = %v, want %v", tt.name, info.Suspended, tt.want != nil)
+		}
+		// Sessions not yet noticed as disconnected can be resumed too.
+		if tt.want == nil {
+			if _, err := m.Resume(s.SID(), tt.md); err != nil {
+				t.Errorf("Resume(%v) error = %v", tt.name, err)
+			}
+		}
+		s.Close()
+		p.Close()
+	}
+}
+
+func TestInfo(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	m := New(0, time.Minute, 0)
+	md := Metadata{Destination: "example.org:22", Origin: "chrome-extension://foo", Identity: "user@example.org"}
+	s, err := m.New(b, session.CorpRelay, md)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if want := s.SID().String() + " (user@example.org)"; s.String() != want {
+		t.Errorf("String() = %v, want %v", s, want)
+	}
+	go a.Read(make([]byte, 3))
+	if _, err := m.sessions[s.SID()].conn.Write([]byte{1, 2, 3}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got, err := m.Info(s.SID())
+	if err != nil {
+		t.Fatalf("Info(%v) error = %v", s, err)
+	}
+	want := Info{
+		Metadata:      md,
+		SID:           s.SID(),
+		Version:       session.CorpRelay,
+		Start:         got.Start,
+		BytesToServer: 3,
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("Info(%v) diff (-got +want):\n%v", s, diff)
+	}
+	if diff := pretty.Compare(m.List(), []Info{want}); diff != "" {
+		t.Errorf("List() diff (-got +want):\n%v", diff)
+	}
+	if _, err := m.Info(uuid.New()); err != ErrNoSuchSID {
+		t.Errorf("Info() error = %v, want %v", err, ErrNoSuchSID)
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	p, _ := net.Pipe()
+	m := New(0, time.Minute, 0)
+	s, err := m.New(p, session.CorpRelay, Metadata{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := m.Terminate(uuid.New()); err != ErrNoSuchSID {
+		t.Errorf("Terminate() error = %v, want %v", err, ErrNoSuchSID)
+	}
+	if err := m.Terminate(s.SID()); err != nil {
+		t.Errorf("Terminate(%v) error = %v", s, err)
+	}
+	// Sessions are de-registered asynchronously once closed.
+	for i := 0; i < 100; i++ {
+		if _, err = m.Get(s.SID()); err == ErrNoSuchSID {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Get(%v) error = %v, want %v", s, err, ErrNoSuchSID)
+}
+
+func TestSetLimits(t *testing.T) {
+	p, _ := net.Pipe()
+	m := New(1, time.Minute, 0)
+	if _, err := m.New(p, session.CorpRelay, Metadata{}); err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := m.New(p, session.CorpRelay, Metadata{}); err == nil {
+		t.Error("New() error = nil")
+	}
+	m.SetLimits(2, time.Minute, 0)
+	if _, err := m.New(p, session.CorpRelay, Metadata{}); err != nil {
+		t.Errorf("New() after SetLimits() error = %v", err)
+	}
+	if _, err := m.New(p, session.CorpRelay, Metadata{}); err == nil {
+		t.Error("New() after SetLimits() error = nil")
+	}
+}
+
+func TestSetIdleTimeout(t *testing.T) {
+	p, _ := net.Pipe()
+	m := New(0, time.Minute, 0)
+	s, err := m.New(p, session.CorpRelayV4, Metadata{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	m.SetIdleTimeout(10 * time.Millisecond)
+	idle, err := m.New(p, session.CorpRelay, Metadata{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	select {
+	case <-idle.Done():
+	case <-time.After(time.Second):
+		t.Errorf("%v: Session not closed after idle timeout", idle)
+	}
+	// Existing sessions keep the idle timeout they were created with.
+	select {
+	case <-s.Done():
+		t.Errorf("%v: Session closed after SetIdleTimeout()", s)
+	default:
+	}
+}
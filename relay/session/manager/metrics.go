@@ -0,0 +1,13 @@
+package manager
+
+import "github.com/hazaelsan/ssh-relay/metrics"
+
+var (
+	activeSessions   = metrics.NewGaugeVec("ssh_relay_sessions", "Active sessions, including suspended ones.", "protocol")
+	limitRejections  = metrics.NewCounterVec("ssh_relay_session_limit_rejections_total", "Sessions rejected due to max_sessions.")
+	quotaRejections  = metrics.NewCounterVec("ssh_relay_session_quota_rejections_total", "Sessions rejected due to session_quotas.", "quota")
+	queuedRequests   = metrics.NewGaugeVec("ssh_relay_admission_queue_length", "Requests waiting in the admission queue for a session slot.")
+	queueTimeouts    = metrics.NewCounterVec("ssh_relay_admission_queue_timeouts_total", "Requests rejected after timing out in the admission queue.")
+	sessionDurations = metrics.NewHistogramVec("ssh_relay_session_duration_seconds", "Session lifetimes.", []float64{1, 10, 60, 300, 900, 1800, 3600, 7200, 14400, 28800, 86400}, "protocol")
+	sessionBytes     = metrics.NewCounterVec("ssh_relay_session_bytes_total", "Data relayed between clients and SSH servers.", "protocol", "direction")
+)
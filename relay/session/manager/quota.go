@@ -0,0 +1,117 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/hazaelsan/ssh-relay/relay/policy"
+)
+
+var (
+	// ErrQuota is wrapped by all errors returned when a session quota is reached.
+	ErrQuota = errors.New("session quota reached")
+
+	// ErrOriginQuota is returned when a client origin reaches its session quota.
+	ErrOriginQuota = fmt.Errorf("%w: origin", ErrQuota)
+
+	// ErrClientIPQuota is returned when a client IP address reaches its session quota.
+	ErrClientIPQuota = fmt.Errorf("%w: client IP", ErrQuota)
+
+	// ErrIdentityQuota is returned when a client identity reaches its session quota.
+	ErrIdentityQuota = fmt.Errorf("%w: identity", ErrQuota)
+
+	// ErrDestinationQuota is returned when a destination reaches its session quota.
+	ErrDestinationQuota = fmt.Errorf("%w: destination", ErrQuota)
+)
+
+// Quotas limits the number of concurrent sessions sharing a client origin, IP address, identity or destination,
+// on top of the overall session limit. Limits <= 0 are disabled.
+type Quotas struct {
+	// PerOrigin is the maximum number of sessions per client origin.
+	PerOrigin int
+
+	// PerClientIP is the maximum number of sessions per client IP address.
+	PerClientIP int
+
+	// PerIdentity is the maximum number of sessions per client identity,
+	// sessions without an identity are not limited.
+	PerIdentity int
+
+	// PerDestination returns the maximum number of sessions to the destination of a session, may be nil.
+	PerDestination func(md Metadata) int
+}
+
+// checkQuotas returns an error wrapping ErrQuota if a new session with md would exceed a quota, m.mu MUST be held.
+func (m *Manager) checkQuotas(md Metadata) error {
+	q := m.quotas
+	maxDst := 0
+	if q.PerDestination != nil {
+		maxDst = q.PerDestination(md)
+	}
+	if q.PerOrigin <= 0 && q.PerClientIP <= 0 && q.PerIdentity <= 0 && maxDst <= 0 {
+		return nil
+	}
+	var origin, ip, identity, dst int
+	key := destinationKey(md.Destination)
+	count := func(o Metadata) {
+		if o.Origin == md.Origin {
+			origin++
+		}
+		if o.ClientIP == md.ClientIP {
+			ip++
+		}
+		if md.Identity != "" && o.Identity == md.Identity {
+			identity++
+		}
+		if maxDst > 0 && destinationKey(o.Destination) == key {
+			dst++
+		}
+	}
+	// Reserved slots count too, they're about to become sessions.
+	for _, e := range m.sessions {
+		count(e.md)
+	}
+	for r := range m.reservations {
+		count(r.md)
+	}
+	var err error
+	switch {
+	case q.PerOrigin > 0 && origin >= q.PerOrigin:
+		err = ErrOriginQuota
+	case q.PerClientIP > 0 && ip >= q.PerClientIP:
+		err = ErrClientIPQuota
+	case q.PerIdentity > 0 && md.Identity != "" && identity >= q.PerIdentity:
+		err = ErrIdentityQuota
+	case maxDst > 0 && dst >= maxDst:
+		err = ErrDestinationQuota
+	default:
+		return nil
+	}
+	quotaRejections.With(quotaName(err)).Inc()
+	return err
+}
+
+// destinationKey returns the form of a host:port destination sessions are counted by,
+// with the host normalized the same way destination policies match it.
+func destinationKey(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return net.JoinHostPort(policy.NormalizeHost(host), port)
+}
+
+// quotaName returns the name of the quota behind a quota error, used as a metric label.
+func quotaName(err error) string {
+	switch err {
+	case ErrOriginQuota:
+		return "origin"
+	case ErrClientIPQuota:
+		return "client_ip"
+	case ErrIdentityQuota:
+		return "identity"
+	default:
+		return "destination"
+	}
+}
@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/ssh-relay/session"
+)
+
+func TestQuotas(t *testing.T) {
+	md := Metadata{
+		Destination: "example.org:22",
+		Origin:      "chrome-extension://foo",
+		Identity:    "user@example.org",
+		ClientIP:    "192.0.2.1",
+	}
+	testdata := []struct {
+		name string
+		q    Quotas
+		md   Metadata
+		want error
+	}{
+		{
+			name: "no quotas",
+			md:   md,
+		},
+		{
+			name: "origin",
+			q:    Quotas{PerOrigin: 1},
+			md:   md,
+			want: ErrOriginQuota,
+		},
+		{
+			name: "other origin",
+			q:    Quotas{PerOrigin: 1},
+			md:   Metadata{Destination: md.Destination, Origin: "chrome-extension://bar", Identity: md.Identity, ClientIP: md.ClientIP},
+		},
+		{
+			name: "client IP",
+			q:    Quotas{PerClientIP: 1},
+			md:   Metadata{Destination: md.Destination, Origin: "chrome-extension://bar", ClientIP: md.ClientIP},
+			want: ErrClientIPQuota,
+		},
+		{
+			name: "identity",
+			q:    Quotas{PerIdentity: 1},
+			md:   md,
+			want: ErrIdentityQuota,
+		},
+		{
+			name: "no identity",
+			q:    Quotas{PerIdentity: 1},
+			md:   Metadata{Destination: md.Destination, Origin: md.Origin, ClientIP: md.ClientIP},
+		},
+		{
+			name: "destination",
+			q:    Quotas{PerDestination: func(Metadata) int { return 1 }},
+			md:   Metadata{Destination: md.Destination, Origin: "chrome-extension://bar", ClientIP: "192.0.2.2"},
+			want: ErrDestinationQuota,
+		},
+		{
+			name: "same destination, fully-qualified",
+			q:    Quotas{PerDestination: func(Metadata) int { return 1 }},
+			md:   Metadata{Destination: "Example.org.:22", Origin: "chrome-extension://bar", ClientIP: "192.0.2.2"},
+			want: ErrDestinationQuota,
+		},
+		{
+			name: "other port",
+			q:    Quotas{PerDestination: func(Metadata) int { return 1 }},
+			md:   Metadata{Destination: "example.org:2222", Origin: md.Origin, ClientIP: md.ClientIP},
+		},
+		{
+			name: "other destination",
+			q:    Quotas{PerDestination: func(Metadata) int { return 1 }},
+			md:   Metadata{Destination: "example.com:22", Origin: md.Origin, ClientIP: md.ClientIP},
+		},
+		{
+			name: "under quota",
+			q:    Quotas{PerOrigin: 2, PerClientIP: 2, PerIdentity: 2},
+			md:   md,
+		},
+	}
+	for _, tt := range testdata {
+		p, _ := net.Pipe()
+		m := New(0, time.Minute, 0)
+		if _, err := m.New(p, session.CorpRelay, md); err != nil {
+			t.Fatalf("New(%v) error = %v", tt.name, err)
+		}
+		m.SetQuotas(tt.q)
+		res, err := m.Reserve(context.Background(), tt.md)
+		if err != tt.want {
+			t.Errorf("Reserve(%v) error = %v, want %v", tt.name, err, tt.want)
+		}
+		if res != nil {
+			res.Release()
+		}
+		s, err := m.New(p, session.CorpRelay, tt.md)
+		if err != tt.want {
+			t.Errorf("New(%v) error = %v, want %v", tt.name, err, tt.want)
+		}
+		if err != nil && !errors.Is(err, ErrQuota) {
+			t.Errorf("New(%v) error = %v, want %v", tt.name, err, ErrQuota)
+		}
+		if s != nil {
+			s.Close()
+		}
+		p.Close()
+	}
+}
@@ -0,0 +1,77 @@
+package manager
+
+import "github.com/golang/glog"
+
+// watchBuffer is the number of Events buffered for each watcher.
+const watchBuffer = 64
+
+// EventType is the type of a Session Event.
+type EventType int
+
+const (
+	// Created is sent when a Session is registered.
+	Created EventType = iota
+
+	// Suspended is sent when a Session is disconnected, waiting to be resumed.
+	Suspended
+
+	// Resumed is sent when a suspended Session is resumed.
+	Resumed
+
+	// Terminated is sent when a Session is de-registered.
+	Terminated
+)
+
+// An Event is a change in the state of a Session.
+type Event struct {
+	Type EventType
+	Info Info
+}
+
+// Watch subscribes to Session Events, the returned function MUST be called to unsubscribe.
+// Events are dropped for watchers that don't keep up.
+func (m *Manager) Watch() (<-chan Event, func()) {
+	ch := make(chan Event, watchBuffer)
+	m.mu.Lock()
+	m.watchers[ch] = struct{}{}
+	m.mu.Unlock()
+	return ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.watchers[ch]; ok {
+			delete(m.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// notify sends an Event to all watchers, m.mu MUST be held.
+func (m *Manager) notify(t EventType, e *entry) {
+	if len(m.watchers) == 0 {
+		return
+	}
+	_, suspended := m.suspended[e.s.SID()]
+	ev := Event{Type: t, Info: e.info(suspended)}
+	for ch := range m.watchers {
+		select {
+		case ch <- ev:
+		default:
+			glog.Warningf("%v: Dropped %v event for slow watcher", e.s, t)
+		}
+	}
+}
+
+func (t EventType) String() string {
+	switch t {
+	case Created:
+		return "created"
+	case Suspended:
+		return "suspended"
+	case Resumed:
+		return "resumed"
+	case Terminated:
+		return "terminated"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,47 @@
+package manager
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/ssh-relay/session"
+)
+
+func TestWatch(t *testing.T) {
+	m := New(0, time.Minute, time.Minute)
+	ch, cancel := m.Watch()
+	p, _ := net.Pipe()
+	s, err := m.New(p, session.CorpRelayV4, Metadata{Destination: "example.org:22"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := m.Suspend(s.SID()); err != nil {
+		t.Fatalf("Suspend(%v) error = %v", s, err)
+	}
+	if _, err := m.Resume(s.SID(), Metadata{}); err != nil {
+		t.Fatalf("Resume(%v) error = %v", s, err)
+	}
+	if err := m.Terminate(s.SID()); err != nil {
+		t.Fatalf("Terminate(%v) error = %v", s, err)
+	}
+	for _, want := range []EventType{Created, Suspended, Resumed, Terminated} {
+		select {
+		case ev := <-ch:
+			if ev.Type != want {
+				t.Errorf("Watch() event = %v, want %v", ev.Type, want)
+			}
+			if ev.Info.SID != s.SID() || ev.Info.Destination != "example.org:22" {
+				t.Errorf("Watch() event info = %+v", ev.Info)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Watch() timed out waiting for %v", want)
+		}
+	}
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Error("Watch() channel not closed after cancel")
+	}
+	// Cancelling twice is a no-op.
+	cancel()
+}
@@ -0,0 +1,65 @@
+// Package reload provides helpers for reloading configuration files on SIGHUP.
+package reload
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/golang/glog"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// OnSIGHUP calls f every time the process receives a SIGHUP, in a separate goroutine.
+// Errors are logged, f is expected to keep the current configuration on failure.
+// The returned function stops handling SIGHUP.
+func OnSIGHUP(f func() error) func() {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sigc:
+				glog.Info("Received SIGHUP, reloading config")
+				if err := f(); err != nil {
+					glog.Errorf("Config reload failed, keeping the current config: %v", err)
+					continue
+				}
+				glog.Info("Config reloaded")
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigc)
+		close(done)
+	}
+}
+
+// ChangedFields returns the names of the given top-level fields that differ between two messages of the same type.
+// Unknown field names are ignored.
+func ChangedFields(old, cur proto.Message, names ...protoreflect.Name) []protoreflect.Name {
+	om, cm := old.ProtoReflect(), cur.ProtoReflect()
+	fields := cm.Descriptor().Fields()
+	var changed []protoreflect.Name
+	for _, n := range names {
+		fd := fields.ByName(n)
+		if fd == nil {
+			continue
+		}
+		if om.Has(fd) != cm.Has(fd) || !om.Get(fd).Equal(cm.Get(fd)) {
+			changed = append(changed, n)
+		}
+	}
+	return changed
+}
+
+// LogRestartFields logs the given top-level fields that changed between two configs, as they need a restart to take effect.
+func LogRestartFields(old, cur proto.Message, names ...protoreflect.Name) {
+	for _, n := range ChangedFields(old, cur, names...) {
+		glog.Warningf("Config field %v changed, restart to apply", n)
+	}
+}
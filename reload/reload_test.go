@@ -0,0 +1,74 @@
+package reload
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/apipb"
+	"google.golang.org/protobuf/types/known/sourcecontextpb"
+)
+
+func TestChangedFields(t *testing.T) {
+	old := &apipb.Api{
+		Name:          "foo",
+		Version:       "v1",
+		SourceContext: &sourcecontextpb.SourceContext{FileName: "foo.proto"},
+	}
+	testdata := []struct {
+		name string
+		cur  *apipb.Api
+		want []protoreflect.Name
+	}{
+		{
+			name: "unchanged",
+			cur: &apipb.Api{
+				Name:          "foo",
+				Version:       "v1",
+				SourceContext: &sourcecontextpb.SourceContext{FileName: "foo.proto"},
+			},
+		},
+		{
+			name: "changed",
+			cur: &apipb.Api{
+				Name:          "bar",
+				Version:       "v1",
+				SourceContext: &sourcecontextpb.SourceContext{FileName: "bar.proto"},
+			},
+			want: []protoreflect.Name{"name", "source_context"},
+		},
+		{
+			name: "unset",
+			cur: &apipb.Api{
+				Name:    "foo",
+				Version: "v1",
+			},
+			want: []protoreflect.Name{"source_context"},
+		},
+	}
+	for _, tt := range testdata {
+		got := ChangedFields(old, tt.cur, "name", "version", "source_context", "no_such_field")
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("ChangedFields(%v) diff (-got +want):\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestOnSIGHUP(t *testing.T) {
+	called := make(chan struct{}, 1)
+	stop := OnSIGHUP(func() error {
+		called <- struct{}{}
+		return nil
+	})
+	defer stop()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Error("OnSIGHUP() reload function not called")
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/google/uuid"
@@ -35,28 +37,50 @@ var (
 
 // New creates a *Session from a plain SSH connection.
 func New(ssh io.ReadWriteCloser) *Session {
-	return &Session{
+	s := &Session{
 		sid:  uuid.New(),
 		ssh:  ssh,
 		done: make(chan struct{}),
 	}
+	s.touch()
+	return s
 }
 
 // A Session is an SSH-over-WebSocket Relay session.
 // One leg of the session is a WebSocket, the other is an io.Reader/io.Writer pair that talks plain SSH.
 type Session struct {
-	sid  uuid.UUID
-	ssh  io.ReadWriteCloser
-	ws   *websocket.Conn
-	c    uint32
-	mu   sync.RWMutex
-	done chan struct{}
+	sid      uuid.UUID
+	identity string
+	ssh      io.ReadWriteCloser
+	ka       session.Keepalive
+	ws       *websocket.Conn
+	c        uint32
+	mu       sync.RWMutex
+	done     chan struct{}
+	active   atomic.Int64 // Time of the last DATA traffic, in Unix nanoseconds.
+
+	closeOnce sync.Once
 }
 
 func (s *Session) String() string {
+	if s.identity != "" {
+		return fmt.Sprintf("%v (%v)", s.sid, s.identity)
+	}
 	return s.sid.String()
 }
 
+// SetIdentity sets the client identity, included in String().
+// It must be called before the Session is used.
+func (s *Session) SetIdentity(identity string) {
+	s.identity = identity
+}
+
+// SetKeepalive sets how to detect a dead WebSocket peer.
+// It must be called before the Session is used.
+func (s *Session) SetKeepalive(ka session.Keepalive) {
+	s.ka = ka
+}
+
 // SID returns the Session ID.
 func (s *Session) SID() uuid.UUID {
 	return s.sid
@@ -67,10 +91,24 @@ func (s *Session) Version() session.ProtocolVersion {
 	return session.CorpRelay
 }
 
+// Idle returns the time since the last DATA traffic in either direction.
+func (s *Session) Idle() time.Duration {
+	return time.Since(time.Unix(0, s.active.Load()))
+}
+
+// touch records DATA traffic, resetting the idle time.
+func (s *Session) touch() {
+	s.active.Store(time.Now().UnixNano())
+}
+
 // Close closes the SSH connection, causing the Session to be invalid.
+// It's safe to call Close multiple times.
 func (s *Session) Close() error {
-	err := s.ssh.Close()
-	s.done <- struct{}{}
+	var err error
+	s.closeOnce.Do(func() {
+		err = s.ssh.Close()
+		close(s.done)
+	})
 	return err
 }
 
@@ -92,13 +130,15 @@ func (s *Session) Run(ws *websocket.Conn) error {
 		s.Close()
 	}()
 	s.ws = ws
+	stop := s.ka.Start(ws)
+	defer stop()
 	errc := make(chan error)
 	go s.runSSH(errc)
 	go s.runWS(errc)
 
 	// From here on, we can't do anything about failures, and we want to report the original error.
-	err := <-errc
-	w, wErr := s.ws.NextWriter(websocket.BinaryMessage)
+	err := session.PeerTimeout(s, <-errc)
+	w, wErr := s.ka.NextWriter(s.ws, websocket.BinaryMessage)
 	if wErr != nil {
 		return err
 	}
@@ -125,7 +165,7 @@ func (s *Session) runSSH(errc chan<- error) {
 				return err
 			}
 
-			w, err := s.ws.NextWriter(websocket.BinaryMessage)
+			w, err := s.ka.NextWriter(s.ws, websocket.BinaryMessage)
 			if err != nil {
 				return fmt.Errorf("NextWriter() error: %w", err)
 			}
@@ -146,6 +186,7 @@ func (s *Session) runSSH(errc chan<- error) {
 func (s *Session) copySSH(w io.Writer, b []byte) error {
 	n, err := w.Write(b)
 	glog.V(5).Infof("ssh->ws wrote %v bytes", n)
+	s.touch()
 	return err
 }
 
@@ -208,6 +249,7 @@ func (s *Session) copyWS(r io.Reader) error {
 		return err
 	}
 	s.incCounter(int(n))
+	s.touch()
 	glog.V(5).Infof("ws->ssh read %v bytes", n)
 	_, err = s.ssh.Write(b.Bytes())
 	return err
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/kylelemons/godebug/pretty"
 )
@@ -107,6 +108,27 @@ func TestParseBinary(t *testing.T) {
 	}
 }
 
+func TestIdle(t *testing.T) {
+	s := New(&rwc{new(bytes.Buffer)})
+	s.active.Store(time.Now().Add(-time.Hour).UnixNano())
+	if got := s.Idle(); got < time.Hour {
+		t.Errorf("Idle() = %v, want >= %v", got, time.Hour)
+	}
+	if err := s.parseBinary(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0xaa})); err != nil {
+		t.Fatalf("parseBinary() error = %v", err)
+	}
+	if got := s.Idle(); got >= time.Hour {
+		t.Errorf("Idle() after ws->ssh data = %v, want < %v", got, time.Hour)
+	}
+	s.active.Store(time.Now().Add(-time.Hour).UnixNano())
+	if err := s.copySSH(new(bytes.Buffer), []byte{0xaa}); err != nil {
+		t.Fatalf("copySSH() error = %v", err)
+	}
+	if got := s.Idle(); got >= time.Hour {
+		t.Errorf("Idle() after ssh->ws data = %v, want < %v", got, time.Hour)
+	}
+}
+
 func TestReadAck(t *testing.T) {
 	testdata := []struct {
 		data []byte
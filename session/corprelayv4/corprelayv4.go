@@ -3,12 +3,13 @@
 package corprelayv4
 
 import (
-	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/google/uuid"
@@ -17,21 +18,36 @@ import (
 	"github.com/hazaelsan/ssh-relay/session/corprelayv4/command"
 )
 
+const (
+	// MaxUnackedBytes is the maximum amount of ssh->ws data kept for replaying on reconnects.
+	// Reads from SSH are paused until the peer acknowledges some of the buffered data.
+	MaxUnackedBytes = 1024 * 1024
+)
+
 // newWriter defines a function to get a WebSocket writer, used for ease of testing.
 type newWriter func(int) (io.WriteCloser, error)
 
 var (
 	// ErrInvalidSession is returned when a session is in an invalid state.
 	ErrInvalidSession = errors.New("invalid session")
+
+	// ErrBadAck is returned when an ack falls outside of the buffered data range.
+	ErrBadAck = errors.New("bad ack")
+
+	// ErrNotConnected is returned when writing to a session without a WebSocket.
+	ErrNotConnected = errors.New("not connected")
 )
 
 // New creates a *Session from a given SSH connection.
 func New(ssh io.ReadWriteCloser, role session.Role) *Session {
 	s := &Session{
-		ssh:  ssh,
-		role: role,
-		done: make(chan struct{}),
+		ssh:    ssh,
+		role:   role,
+		sshErr: make(chan error, 1),
+		done:   make(chan struct{}),
 	}
+	s.cond = sync.NewCond(&s.mu)
+	s.touch()
 	if s.role == session.Server {
 		s.sid = uuid.New()
 	}
@@ -39,25 +55,54 @@ func New(ssh io.ReadWriteCloser, role session.Role) *Session {
 }
 
 // A Session is a V4 SSH-over-Websocket Relay session.
-// TODO: Implement reconnect logic.
+// Data sent to the peer is buffered until acknowledged, allowing the session to be resumed over a new WebSocket.
 type Session struct {
-	sid    uuid.UUID
-	ssh    io.ReadWriteCloser
-	ws     *websocket.Conn
-	rCount uint64
-	wCount uint64
-	mu     sync.RWMutex
-	wFunc  newWriter
-	role   session.Role
-	done   chan struct{}
+	sid       uuid.UUID
+	sidMu     sync.RWMutex // Guards sid, set by clients once connected.
+	identity  string
+	ssh       io.ReadWriteCloser
+	ka        session.Keepalive
+	ws        *websocket.Conn
+	reader    chan struct{} // Closed once the reader of ws exits.
+	sshDone   error         // Why SSH reads ended, if they did.
+	rCount    uint64        // ws->ssh bytes received.
+	wCount    uint64        // ssh->ws bytes acknowledged by the peer.
+	wBuf      []byte        // ssh->ws bytes not yet acknowledged by the peer.
+	ready     bool          // Whether DATA commands can be sent over the WebSocket.
+	mu        sync.Mutex
+	cond      *sync.Cond
+	wFunc     newWriter
+	role      session.Role
+	sshOnce   sync.Once
+	sshErr    chan error
+	closeOnce sync.Once
+	done      chan struct{}
+	active    atomic.Int64 // Time of the last DATA/ACK traffic, in Unix nanoseconds.
 }
 
 func (s *Session) String() string {
-	return s.sid.String()
+	if s.identity != "" {
+		return fmt.Sprintf("%v (%v)", s.SID(), s.identity)
+	}
+	return s.SID().String()
+}
+
+// SetIdentity sets the client identity, included in String().
+// It must be called before the Session is used.
+func (s *Session) SetIdentity(identity string) {
+	s.identity = identity
+}
+
+// SetKeepalive sets how to detect a dead WebSocket peer.
+// It must be called before the Session is used.
+func (s *Session) SetKeepalive(ka session.Keepalive) {
+	s.ka = ka
 }
 
 // SID returns the Session ID.
 func (s *Session) SID() uuid.UUID {
+	s.sidMu.RLock()
+	defer s.sidMu.RUnlock()
 	return s.sid
 }
 
@@ -66,10 +111,40 @@ func (s *Session) Version() session.ProtocolVersion {
 	return session.CorpRelayV4
 }
 
+// Ack returns the amount of data received from the peer.
+func (s *Session) Ack() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rCount
+}
+
+// Attached returns whether a WebSocket is in use by the Session.
+func (s *Session) Attached() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ws != nil
+}
+
+// Idle returns the time since the last DATA or ACK traffic in either direction.
+func (s *Session) Idle() time.Duration {
+	return time.Since(time.Unix(0, s.active.Load()))
+}
+
+// touch records DATA/ACK traffic, resetting the idle time.
+func (s *Session) touch() {
+	s.active.Store(time.Now().UnixNano())
+}
+
 // Close closes the SSH connection, causing the Session to be invalid.
 func (s *Session) Close() error {
-	err := s.ssh.Close()
-	s.done <- struct{}{}
+	var err error
+	s.closeOnce.Do(func() {
+		err = s.ssh.Close()
+		close(s.done)
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
 	return err
 }
 
@@ -79,31 +154,168 @@ func (s *Session) Done() <-chan struct{} {
 }
 
 // Run starts a new session between the WebSocket and SSH connections.
+// If the WebSocket is disconnected the returned error wraps session.ErrDisconnected,
+// the session is then kept open so it can be resumed; on any other error the session is closed.
 func (s *Session) Run(ws *websocket.Conn) error {
-	defer s.Close()
-	s.ws = ws
-	s.wFunc = s.ws.NextWriter
-	errc := make(chan error)
-	go s.runWS(errc)
-	go s.runSSH(errc)
-	return <-errc
+	s.mu.Lock()
+	s.attach(ws)
+	reader := s.reader
+	if s.role == session.Server {
+		if err := s.sendConnect(); err != nil {
+			s.mu.Unlock()
+			close(reader)
+			s.Close()
+			return err
+		}
+		s.ready = true
+	}
+	s.mu.Unlock()
+	return s.run(ws, reader)
 }
 
-// wsWriter is a wrapper to a websocket writer.
-// Returns a writer and a cancel function to release the lock.
-// Needed because gorilla doesn't support concurrent writes.
-func (s *Session) wsWriter(t int) (io.WriteCloser, func(), error) {
+// Resume continues a disconnected session over a new WebSocket.
+// Servers send a RECONNECT_SUCCESS command and replay all data after ack,
+// clients replay data once they receive a RECONNECT_SUCCESS command.
+// A still attached WebSocket, e.g., one whose peer moved networks before the loss was noticed, is closed;
+// the new one is only used once the reader of the old one exits.
+func (s *Session) Resume(ws *websocket.Conn, ack uint64) error {
+	s.mu.Lock()
+	prev := s.reader
+	if s.ws != nil {
+		glog.V(1).Infof("%v: Replacing attached WebSocket", s)
+		s.ws.Close()
+	}
+	s.attach(ws)
+	reader, wFunc := s.reader, s.wFunc
+	// The replaced reader may still be acking data or writing to SSH, hold off writes until it's done.
+	s.wFunc = nil
+	s.mu.Unlock()
+	if prev != nil {
+		<-prev
+	}
 	s.mu.Lock()
-	w, err := s.wFunc(t)
+	if s.ws != ws {
+		s.mu.Unlock()
+		close(reader)
+		return fmt.Errorf("%w: replaced while resuming", session.ErrReplaced)
+	}
+	s.wFunc = wFunc
+	if s.role == session.Server {
+		if err := s.sendReconnect(ack); err != nil {
+			s.detach()
+			sshDone := s.sshDone != nil
+			s.mu.Unlock()
+			close(reader)
+			if errors.Is(err, ErrBadAck) || sshDone {
+				// Data the client needs is gone, or there's nothing left to resume.
+				s.Close()
+				return err
+			}
+			return fmt.Errorf("%w: %w", session.ErrDisconnected, err)
+		}
+		s.ready = true
+	}
+	s.mu.Unlock()
+	return s.run(ws, reader)
+}
+
+// run handles bidirectional traffic until either leg of the session fails, reader is closed once ws is no longer read.
+func (s *Session) run(ws *websocket.Conn, reader chan<- struct{}) error {
+	s.sshOnce.Do(func() {
+		go s.runSSH()
+	})
+	stop := s.ka.Start(ws)
+	defer stop()
+	errc := make(chan error, 1)
+	go s.runWS(ws, errc, reader)
+	select {
+	case err := <-errc:
+		s.mu.Lock()
+		if s.ws != nil && s.ws != ws {
+			// Resumed over a new WebSocket, which now owns the Session.
+			s.mu.Unlock()
+			return fmt.Errorf("%w: %w", session.ErrReplaced, err)
+		}
+		if s.sshDone != nil {
+			// SSH is gone too, there's nothing to resume.
+			err = s.sshDone
+			s.mu.Unlock()
+			s.Close()
+			return err
+		}
+		err = session.PeerTimeout(s, err)
+		if errors.Is(err, session.ErrDisconnected) {
+			s.detach()
+			s.mu.Unlock()
+			return err
+		}
+		s.mu.Unlock()
+		s.Close()
+		return err
+	case err := <-s.sshErr:
+		s.Close()
+		return err
+	}
+}
+
+// attach sets the WebSocket to use for the session, s.mu MUST be held.
+// The new reader channel MUST be passed to run, or closed if the WebSocket isn't run.
+func (s *Session) attach(ws *websocket.Conn) {
+	s.ws = ws
+	s.reader = make(chan struct{})
+	// Writers may be used without s.mu, gorilla doesn't support concurrent writes.
+	wmu := new(sync.Mutex)
+	s.wFunc = func(messageType int) (io.WriteCloser, error) {
+		wmu.Lock()
+		w, err := s.ka.NextWriter(ws, messageType)
+		if err != nil {
+			wmu.Unlock()
+			return nil, err
+		}
+		return &lockedWriter{WriteCloser: w, mu: wmu}, nil
+	}
+	s.ready = false
+}
+
+// detach clears the WebSocket in use for the session, s.mu MUST be held.
+func (s *Session) detach() {
+	s.ws = nil
+	s.wFunc = nil
+	s.ready = false
+}
+
+// A lockedWriter holds a lock on its WebSocket until closed.
+type lockedWriter struct {
+	io.WriteCloser
+	mu   *sync.Mutex
+	once sync.Once
+}
+
+func (w *lockedWriter) Close() error {
+	err := w.WriteCloser.Close()
+	w.once.Do(w.mu.Unlock)
+	return err
+}
+
+// writeCmd writes a command to the WebSocket, s.mu MUST be held.
+func (s *Session) writeCmd(c command.Command) error {
+	return writeCmd(s.wFunc, c)
+}
+
+// writeCmd writes a command to a WebSocket using wFunc.
+func writeCmd(wFunc newWriter, c command.Command) error {
+	if wFunc == nil {
+		return ErrNotConnected
+	}
+	w, err := wFunc(websocket.BinaryMessage)
 	if err != nil {
-		defer s.mu.Unlock()
-		return nil, nil, err
+		return fmt.Errorf("wFunc() error: %w", err)
 	}
-	done := func() {
+	if err := c.Write(w); err != nil {
 		w.Close()
-		s.mu.Unlock()
+		return err
 	}
-	return w, done, nil
+	return w.Close()
 }
 
 // recvCmd parses an in-band command from a WebSocket stream.
@@ -116,141 +328,252 @@ func (s *Session) recvCmd(b []byte) error {
 	case command.TagConnectSuccess:
 		b := c.(command.ConnectSuccess).SID()
 		// CONNECT_SUCCESS can only be sent to a client as the first command.
-		if s.role != session.Client || !bytes.Equal(s.sid[:], uuid.Nil[:]) {
+		if s.role != session.Client || s.SID() != uuid.Nil {
 			break
 		}
-		s.sid, err = uuid.Parse(b)
+		sid, err := uuid.Parse(b)
 		if err != nil {
 			return fmt.Errorf("uuid.Parse(%v) error: %w", b, err)
 		}
-		return nil
+		s.sidMu.Lock()
+		s.sid = sid
+		s.sidMu.Unlock()
+		return s.readConnect()
 	case command.TagReconnectSuccess:
-		return errors.New("not implemented")
+		// RECONNECT_SUCCESS can only be sent to a client resuming a session.
+		if s.role != session.Client || s.SID() == uuid.Nil {
+			break
+		}
+		return s.readReconnect(c.(command.ReconnectSuccess))
 	case command.TagData:
+		s.touch()
 		if err := s.readData(c.(command.Data)); err != nil {
 			return err
 		}
 		return s.sendAck()
 	case command.TagAck:
+		s.touch()
 		return s.readAck(c.(command.Ack))
 	}
 	return fmt.Errorf("%w: %v", command.ErrBadCommand, c.Tag())
 }
 
-// establishConn sends the initial CONNECT_SUCCESS/RECONNECT_SUCCESS command to establish a connection.
-func (s *Session) establishConn(ack uint64) error {
-	if ack > 0 {
-		return s.sendReconnect(ack)
-	}
-	return s.sendConnect()
-}
-
-// sendConnect sends a CONNECT_SUCCESS command.
+// sendConnect sends a CONNECT_SUCCESS command, s.mu MUST be held.
 func (s *Session) sendConnect() error {
-	sid, err := s.sid.MarshalText()
+	sid, err := s.SID().MarshalText()
 	if err != nil {
 		return err
 	}
-	w, cancel, err := s.wsWriter(websocket.BinaryMessage)
-	if err != nil {
-		return fmt.Errorf("wFunc() error: %w", err)
-	}
-	defer cancel()
 	cs, err := command.NewConnectSuccess(sid)
 	if err != nil {
 		return err
 	}
-	return cs.Write(w)
+	return s.writeCmd(cs)
+}
+
+// sendReconnect sends a RECONNECT_SUCCESS command and replays all data after ack, s.mu MUST be held.
+func (s *Session) sendReconnect(ack uint64) error {
+	if err := s.trim(ack); err != nil {
+		return err
+	}
+	glog.V(2).Infof("%v: Resuming session, ack %v, pos %v", s, ack, s.rCount)
+	if err := s.writeCmd(command.NewReconnectSuccess(s.rCount)); err != nil {
+		return err
+	}
+	return s.replay()
+}
+
+// readConnect processes an incoming CONNECT_SUCCESS command, sending any data read before the session was established.
+func (s *Session) readConnect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.replay(); err != nil {
+		return fmt.Errorf("%w: %w", session.ErrDisconnected, err)
+	}
+	s.ready = true
+	return nil
+}
+
+// readReconnect processes an incoming RECONNECT_SUCCESS command, replaying all data after its ack.
+func (s *Session) readReconnect(rs command.ReconnectSuccess) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ready {
+		return fmt.Errorf("%w: %v", command.ErrBadCommand, rs.Tag())
+	}
+	ack := uint64(rs)
+	if err := s.trim(ack); err != nil {
+		return err
+	}
+	glog.V(2).Infof("%v: Resuming session, ack %v, pos %v", s, ack, s.rCount)
+	if err := s.replay(); err != nil {
+		return fmt.Errorf("%w: %w", session.ErrDisconnected, err)
+	}
+	s.ready = true
+	return nil
 }
 
-// sendReconnect sends a RECONNECT_SUCCESS command.
-func (s *Session) sendReconnect(_ uint64) error {
-	return errors.New("not implemented")
+// replay re-sends all unacknowledged data, s.mu MUST be held.
+func (s *Session) replay() error {
+	for b := s.wBuf; len(b) > 0; {
+		n := min(len(b), command.MaxArrayLen)
+		if err := s.writeData(b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
 }
 
 // readData processes an incoming DATA command.
 func (s *Session) readData(d command.Data) error {
 	data := d.Data()
-	s.rCount += uint64(len(data))
 	glog.V(5).Infof("%v: ws->ssh read %v bytes", s, len(data))
 	if _, err := s.ssh.Write(data); err != nil {
 		return err
 	}
+	s.mu.Lock()
+	s.rCount += uint64(len(data))
+	s.mu.Unlock()
 	return nil
 }
 
 // sendAck sends an ACK command in response to received data from one or more DATA commands.
 func (s *Session) sendAck() error {
-	w, cancel, err := s.wsWriter(websocket.BinaryMessage)
-	if err != nil {
-		return fmt.Errorf("wFunc() error: %w", err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writeCmd(command.NewAck(s.rCount)); err != nil {
+		return fmt.Errorf("%w: %w", session.ErrDisconnected, err)
 	}
-	defer cancel()
-	a := command.NewAck(s.rCount)
-	return a.Write(w)
+	return nil
 }
 
 // readAck processes an incoming ACK command.
 func (s *Session) readAck(a command.Ack) error {
-	ack := a.Ack()
-	diff := int(ack - s.wCount)
-	if diff == 0 {
-		return nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.trim(a.Ack())
+}
+
+// trim discards all buffered data up to ack, s.mu MUST be held.
+func (s *Session) trim(ack uint64) error {
+	if ack < s.wCount {
+		return fmt.Errorf("%w: reverse ack %v -> %v", ErrBadAck, s.wCount, ack)
 	}
-	if diff < 0 {
-		return fmt.Errorf("reverse ack %v -> %v", s.wCount, ack)
+	n := ack - s.wCount
+	if n > uint64(len(s.wBuf)) {
+		return fmt.Errorf("%w: ack %v past written data %v", ErrBadAck, ack, s.wCount+uint64(len(s.wBuf)))
 	}
+	s.wBuf = s.wBuf[n:]
 	s.wCount = ack
+	s.cond.Broadcast()
 	return nil
 }
 
-// runSSH handles reads from SSH, as well as the initial session connection establishment.
-func (s *Session) runSSH(errc chan<- error) {
-	if s.role == session.Server {
-		if err := s.establishConn(0); err != nil {
-			errc <- err
-			return
+// waitBuf blocks until there is room in the replay buffer.
+func (s *Session) waitBuf() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.wBuf) >= MaxUnackedBytes {
+		select {
+		case <-s.done:
+			return ErrInvalidSession
+		default:
 		}
+		s.cond.Wait()
 	}
+	return nil
+}
+
+// runSSH handles reads from SSH for the lifetime of the session.
+func (s *Session) runSSH() {
+	b := make([]byte, command.MaxArrayLen)
 	for {
-		err := func() error {
-			r := bufio.NewReader(s.ssh)
-			b := make([]byte, command.MaxArrayLen)
-			n, err := r.Read(b)
-			data := b[0:n]
-			glog.V(5).Infof("%v: ssh->ws read %v bytes", s, n)
-			if err != nil {
-				return err
-			}
-			w, cancel, err := s.wsWriter(websocket.BinaryMessage)
-			if err != nil {
-				return fmt.Errorf("wFunc() error: %w", err)
-			}
-			defer cancel()
-			return s.copySSH(w, data)
-		}()
+		if err := s.waitBuf(); err != nil {
+			s.endSSH(err)
+			return
+		}
+		n, err := s.ssh.Read(b)
+		glog.V(5).Infof("%v: ssh->ws read %v bytes", s, n)
+		if n > 0 {
+			s.sendData(b[:n])
+		}
 		if err != nil {
-			errc <- err
+			s.endSSH(err)
 			return
 		}
 	}
 }
 
-// copySSH copies the actual data bytes from ssh->ws.
-func (s *Session) copySSH(w io.Writer, b []byte) error {
+// endSSH handles the end of SSH reads, handing err to the active run if any;
+// otherwise (e.g., while suspended) the Session is closed right away.
+func (s *Session) endSSH(err error) {
+	s.mu.Lock()
+	s.sshDone = err
+	attached := s.ws != nil
+	s.mu.Unlock()
+	if attached {
+		s.sshErr <- err
+		return
+	}
+	glog.V(1).Infof("%v: SSH connection ended while detached: %v", s, err)
+	s.Close()
+}
+
+// sendData buffers data for the peer, sending it if the WebSocket is ready.
+// The WebSocket write happens without s.mu held, so a slow peer doesn't block the rest of the Session.
+// Data stays buffered on write errors, it is replayed if the session is resumed.
+func (s *Session) sendData(b []byte) {
+	s.mu.Lock()
+	s.wBuf = append(s.wBuf, b...)
+	if !s.ready {
+		s.mu.Unlock()
+		return
+	}
+	d, err := command.NewData(bytes.Clone(b))
+	ws, wFunc := s.ws, s.wFunc
+	s.mu.Unlock()
+	if err == nil {
+		if err = writeCmd(wFunc, d); err == nil {
+			s.touch()
+			return
+		}
+	}
+	err = session.PeerTimeout(s, err)
+	glog.V(2).Infof("%v: writeData() error: %v", s, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ws != ws {
+		// Replaced in the meantime, the data is replayed over the new WebSocket.
+		return
+	}
+	s.ready = false
+	if s.ws != nil {
+		// Unblock the WebSocket reader so the disconnection is noticed.
+		s.ws.Close()
+	}
+}
+
+// writeData writes a DATA command, s.mu MUST be held.
+func (s *Session) writeData(b []byte) error {
 	d, err := command.NewData(b)
 	if err != nil {
 		return err
 	}
-	return d.Write(w)
+	if err := s.writeCmd(d); err != nil {
+		return err
+	}
+	s.touch()
+	return nil
 }
 
-// runWS handles reads from the WebSocket.
-func (s *Session) runWS(errc chan<- error) {
+// runWS handles reads from the WebSocket, closing reader once done.
+func (s *Session) runWS(ws *websocket.Conn, errc chan<- error, reader chan<- struct{}) {
+	defer close(reader)
 	for {
-		t, r, err := s.ws.NextReader()
+		t, r, err := ws.NextReader()
 		if err != nil {
-			errc <- fmt.Errorf("NextReader() error: %w", err)
+			errc <- fmt.Errorf("%w: NextReader() error: %w", session.ErrDisconnected, err)
 			return
 		}
 
@@ -273,7 +596,7 @@ func (s *Session) runWS(errc chan<- error) {
 func (s *Session) parseBinary(r io.Reader) error {
 	b := new(bytes.Buffer)
 	if _, err := b.ReadFrom(r); err != nil {
-		return err
+		return fmt.Errorf("%w: %w", session.ErrDisconnected, err)
 	}
 	return s.recvCmd(b.Bytes())
 }
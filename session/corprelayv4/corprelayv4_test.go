@@ -5,8 +5,11 @@ import (
 	"io"
 	"net"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/hazaelsan/ssh-relay/session"
+	"github.com/hazaelsan/ssh-relay/session/corprelayv4/command"
 	"github.com/kylelemons/godebug/pretty"
 )
 
@@ -233,3 +236,261 @@ func TestParseBinary(t *testing.T) {
 		}
 	}
 }
+
+func TestIdle(t *testing.T) {
+	ws := &rwc{new(bytes.Buffer)}
+	s := New(&rwc{new(bytes.Buffer)}, session.Server)
+	s.wFunc = func(int) (io.WriteCloser, error) { return ws, nil }
+	for _, tt := range []struct {
+		name string
+		f    func() error
+	}{
+		{
+			name: "DATA",
+			f:    func() error { return s.parseBinary(bytes.NewBuffer(goodData())) },
+		},
+		{
+			name: "ACK",
+			f:    func() error { return s.parseBinary(bytes.NewBuffer(zeroAck())) },
+		},
+		{
+			name: "writeData",
+			f:    func() error { return s.writeData([]byte{0xaa}) },
+		},
+	} {
+		s.active.Store(time.Now().Add(-time.Hour).UnixNano())
+		if got := s.Idle(); got < time.Hour {
+			t.Errorf("Idle() = %v, want >= %v", got, time.Hour)
+		}
+		if err := tt.f(); err != nil {
+			t.Fatalf("%v error = %v", tt.name, err)
+		}
+		if got := s.Idle(); got >= time.Hour {
+			t.Errorf("Idle() after %v = %v, want < %v", tt.name, got, time.Hour)
+		}
+	}
+}
+
+func TestRunSSH_Detached(t *testing.T) {
+	a, b := net.Pipe()
+	s := New(b, session.Server)
+	go s.runSSH()
+	// The SSH server goes away while no WebSocket is attached, e.g., while the session is suspended.
+	a.Close()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Error("Session not closed after SSH connection ended while detached")
+	}
+}
+
+// blockingWriter blocks writes until unblocked.
+type blockingWriter struct {
+	*bytes.Buffer
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(b []byte) (int, error) {
+	<-w.unblock
+	return w.Buffer.Write(b)
+}
+
+func (w *blockingWriter) Close() error {
+	return nil
+}
+
+func TestSendData_SlowPeer(t *testing.T) {
+	ws := &blockingWriter{Buffer: new(bytes.Buffer), unblock: make(chan struct{})}
+	s := New(&rwc{new(bytes.Buffer)}, session.Server)
+	s.wFunc = func(int) (io.WriteCloser, error) { return ws, nil }
+	s.ready = true
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.sendData([]byte{0xaa})
+	}()
+	// The Session stays usable while the peer is slow to take the data.
+	acked := make(chan struct{})
+	go func() {
+		defer close(acked)
+		s.Ack()
+		s.Attached()
+	}()
+	select {
+	case <-acked:
+	case <-time.After(time.Second):
+		t.Error("Session locked while writing to the WebSocket")
+	}
+	close(ws.unblock)
+	<-done
+	want := []byte{
+		0x00, 0x04, // tag
+		0x00, 0x00, 0x00, 0x01, // length
+		0xaa, // data
+	}
+	if diff := pretty.Compare(ws.Bytes(), want); diff != "" {
+		t.Errorf("sendData() diff (-got +want):\n%v", diff)
+	}
+}
+
+func TestReadAck(t *testing.T) {
+	testdata := []struct {
+		wCount  uint64
+		wBuf    []byte
+		ack     uint64
+		want    []byte
+		wantAck uint64
+		ok      bool
+	}{
+		{
+			wBuf:    []byte{0x01, 0x02, 0x03},
+			ack:     0,
+			want:    []byte{0x01, 0x02, 0x03},
+			wantAck: 0,
+			ok:      true,
+		},
+		{
+			wBuf:    []byte{0x01, 0x02, 0x03},
+			ack:     2,
+			want:    []byte{0x03},
+			wantAck: 2,
+			ok:      true,
+		},
+		{
+			wCount:  10,
+			wBuf:    []byte{0x01, 0x02, 0x03},
+			ack:     13,
+			want:    []byte{},
+			wantAck: 13,
+			ok:      true,
+		},
+		// Reverse ack.
+		{
+			wCount: 10,
+			wBuf:   []byte{0x01, 0x02, 0x03},
+			ack:    9,
+		},
+		// Ack past written data.
+		{
+			wCount: 10,
+			wBuf:   []byte{0x01, 0x02, 0x03},
+			ack:    14,
+		},
+	}
+	for i, tt := range testdata {
+		s := New(&rwc{new(bytes.Buffer)}, session.Server)
+		s.wCount = tt.wCount
+		s.wBuf = tt.wBuf
+		if err := s.readAck(command.NewAck(tt.ack)); err != nil {
+			if tt.ok {
+				t.Errorf("readAck(%v) error = %v", i, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("readAck(%v) error = nil", i)
+			continue
+		}
+		if s.wCount != tt.wantAck {
+			t.Errorf("readAck(%v) wCount = %v, want %v", i, s.wCount, tt.wantAck)
+		}
+		if diff := pretty.Compare(s.wBuf, tt.want); diff != "" {
+			t.Errorf("readAck(%v) diff (-got +want):\n%v", i, diff)
+		}
+	}
+}
+
+func TestSendReconnect(t *testing.T) {
+	testdata := []struct {
+		wCount uint64
+		rCount uint64
+		wBuf   []byte
+		ack    uint64
+		want   []byte
+		ok     bool
+	}{
+		{
+			wCount: 2,
+			rCount: 0x10,
+			wBuf:   []byte{0xaa, 0xbb, 0xcc},
+			ack:    3,
+			want: []byte{
+				0x00, 0x02, // tag
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, // ack
+				0x00, 0x04, // tag
+				0x00, 0x00, 0x00, 0x02, // length
+				0xbb, 0xcc, // data
+			},
+			ok: true,
+		},
+		// Nothing to replay.
+		{
+			wCount: 2,
+			wBuf:   []byte{0xaa},
+			ack:    3,
+			want: []byte{
+				0x00, 0x02, // tag
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // ack
+			},
+			ok: true,
+		},
+		// Acked data is gone.
+		{
+			wCount: 2,
+			wBuf:   []byte{0xaa},
+			ack:    1,
+		},
+	}
+	for i, tt := range testdata {
+		ws := &rwc{new(bytes.Buffer)}
+		s := New(&rwc{new(bytes.Buffer)}, session.Server)
+		s.wFunc = func(int) (io.WriteCloser, error) { return ws, nil }
+		s.wCount = tt.wCount
+		s.rCount = tt.rCount
+		s.wBuf = tt.wBuf
+		if err := s.sendReconnect(tt.ack); err != nil {
+			if tt.ok {
+				t.Errorf("sendReconnect(%v) error = %v", i, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("sendReconnect(%v) error = nil", i)
+			continue
+		}
+		if diff := pretty.Compare(ws.Bytes(), tt.want); diff != "" {
+			t.Errorf("sendReconnect(%v) diff (-got +want):\n%v", i, diff)
+		}
+	}
+}
+
+func TestReadReconnect(t *testing.T) {
+	ws := &rwc{new(bytes.Buffer)}
+	s := New(&rwc{new(bytes.Buffer)}, session.Client)
+	s.sid = uuid.New()
+	s.wFunc = func(int) (io.WriteCloser, error) { return ws, nil }
+	s.wBuf = []byte{0xaa, 0xbb, 0xcc}
+	rs := []byte{
+		0x00, 0x02, // tag
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, // ack
+	}
+	if err := s.parseBinary(bytes.NewBuffer(rs)); err != nil {
+		t.Fatalf("parseBinary() error = %v", err)
+	}
+	want := []byte{
+		0x00, 0x04, // tag
+		0x00, 0x00, 0x00, 0x02, // length
+		0xbb, 0xcc, // data
+	}
+	if diff := pretty.Compare(ws.Bytes(), want); diff != "" {
+		t.Errorf("parseBinary() diff (-got +want):\n%v", diff)
+	}
+	if !s.ready {
+		t.Error("parseBinary() ready = false")
+	}
+
+	// A second RECONNECT_SUCCESS is not allowed.
+	if err := s.parseBinary(bytes.NewBuffer(rs)); err == nil {
+		t.Error("parseBinary() error = nil")
+	}
+}
@@ -0,0 +1,131 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/websocket"
+	"github.com/hazaelsan/ssh-relay/duration"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/websocketpb"
+)
+
+const (
+	// DefaultPingInterval is the default interval between WebSocket pings.
+	DefaultPingInterval = 30 * time.Second
+
+	// DefaultPongTimeout is the default time to wait for a pong after a WebSocket ping.
+	DefaultPongTimeout = 30 * time.Second
+
+	// DefaultWriteTimeout is the default time allowed for writing a WebSocket message.
+	DefaultWriteTimeout = 30 * time.Second
+)
+
+var (
+	// ErrPeerTimeout is returned when the WebSocket peer stops answering pings or accepting writes.
+	ErrPeerTimeout = errors.New("websocket peer timeout")
+)
+
+// Keepalive specifies how to detect dead WebSocket peers, the zero value disables detection.
+type Keepalive struct {
+	// PingInterval is how often to ping the peer, no pings are sent if <= 0.
+	PingInterval time.Duration
+
+	// PongTimeout is how long to wait for a pong after each ping, reads fail after it.
+	// Peers are not timed out if <= 0.
+	PongTimeout time.Duration
+
+	// WriteTimeout is how long each message write may take, writes fail after it.
+	// Writes never time out if <= 0.
+	WriteTimeout time.Duration
+}
+
+// NewKeepalive builds a Keepalive from a proto config message, unset fields take their defaults.
+func NewKeepalive(pb *websocketpb.WebSocketOptions) (Keepalive, error) {
+	k := Keepalive{
+		PingInterval: DefaultPingInterval,
+		PongTimeout:  DefaultPongTimeout,
+		WriteTimeout: DefaultWriteTimeout,
+	}
+	for dst, src := range map[*time.Duration]*durationpb.Duration{
+		&k.PingInterval: pb.GetPingInterval(),
+		&k.PongTimeout:  pb.GetPongTimeout(),
+		&k.WriteTimeout: pb.GetWriteTimeout(),
+	} {
+		if err := duration.FromProto(dst, src); err != nil {
+			return k, fmt.Errorf("duration.FromProto(%v) error: %w", src, err)
+		}
+	}
+	return k, nil
+}
+
+// Start pings the peer over ws every PingInterval until the returned func is called or a ping fails.
+// Reads from ws fail if no pong is received within PongTimeout of a ping.
+func (k Keepalive) Start(ws *websocket.Conn) func() {
+	if k.PingInterval <= 0 {
+		return func() {}
+	}
+	if k.PongTimeout > 0 {
+		extend := func(string) error {
+			return ws.SetReadDeadline(time.Now().Add(k.PingInterval + k.PongTimeout))
+		}
+		extend("")
+		ws.SetPongHandler(extend)
+	}
+	stop := make(chan struct{})
+	go func() {
+		t := time.NewTicker(k.PingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := ws.WriteControl(websocket.PingMessage, nil, k.writeDeadline()); err != nil {
+					glog.V(2).Infof("WriteControl(%v) error: %v", ws.RemoteAddr(), err)
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stop) })
+	}
+}
+
+// NextWriter returns a writer for the next message of the given type on ws,
+// the message must be written and closed within WriteTimeout.
+func (k Keepalive) NextWriter(ws *websocket.Conn, messageType int) (io.WriteCloser, error) {
+	if err := ws.SetWriteDeadline(k.writeDeadline()); err != nil {
+		return nil, err
+	}
+	return ws.NextWriter(messageType)
+}
+
+// writeDeadline returns the deadline for a write starting now, the zero time if writes don't time out.
+func (k Keepalive) writeDeadline() time.Time {
+	if k.WriteTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(k.WriteTimeout)
+}
+
+// PeerTimeout checks whether err is caused by the WebSocket peer of s timing out.
+// If so the timeout is logged and counted, and the returned error wraps both ErrPeerTimeout and err;
+// otherwise err is returned as is.
+func PeerTimeout(s Session, err error) error {
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() || errors.Is(err, ErrPeerTimeout) {
+		return err
+	}
+	glog.V(1).Infof("%v: WebSocket peer timed out: %v", s, err)
+	peerTimeouts.With(s.Version().String()).Inc()
+	return fmt.Errorf("%w: %w", ErrPeerTimeout, err)
+}
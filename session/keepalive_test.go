@@ -0,0 +1,159 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/websocketpb"
+)
+
+// fakeSession is a Session for testing PeerTimeout.
+type fakeSession struct {
+	Session
+}
+
+func (fakeSession) String() string {
+	return "fake"
+}
+
+func (fakeSession) Version() ProtocolVersion {
+	return CorpRelayV4
+}
+
+// wsPair returns a WebSocket served by ws, connected to a client that only reads if read is set.
+func wsPair(t *testing.T, read bool) *websocket.Conn {
+	t.Helper()
+	srvc := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := new(websocket.Upgrader).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade() error = %v", err)
+			return
+		}
+		srvc <- ws
+	}))
+	t.Cleanup(srv.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if read {
+		go func() {
+			for {
+				if _, _, err := client.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	ws := <-srvc
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func TestNewKeepalive(t *testing.T) {
+	testdata := []struct {
+		pb   *websocketpb.WebSocketOptions
+		want Keepalive
+		ok   bool
+	}{
+		{
+			want: Keepalive{
+				PingInterval: DefaultPingInterval,
+				PongTimeout:  DefaultPongTimeout,
+				WriteTimeout: DefaultWriteTimeout,
+			},
+			ok: true,
+		},
+		{
+			pb: &websocketpb.WebSocketOptions{
+				PingInterval: &durationpb.Duration{Seconds: 10},
+				PongTimeout:  &durationpb.Duration{},
+			},
+			want: Keepalive{
+				PingInterval: 10 * time.Second,
+				WriteTimeout: DefaultWriteTimeout,
+			},
+			ok: true,
+		},
+		{
+			pb: &websocketpb.WebSocketOptions{
+				WriteTimeout: &durationpb.Duration{Seconds: -1},
+			},
+		},
+	}
+	for i, tt := range testdata {
+		got, err := NewKeepalive(tt.pb)
+		if err != nil {
+			if tt.ok {
+				t.Errorf("NewKeepalive(%v) error = %v", i, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("NewKeepalive(%v) error = nil", i)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("NewKeepalive(%v) diff (-got +want):\n%v", i, diff)
+		}
+	}
+}
+
+func TestKeepalive_Start(t *testing.T) {
+	k := Keepalive{PingInterval: 10 * time.Millisecond, PongTimeout: 50 * time.Millisecond}
+	for _, tt := range []struct {
+		name string
+		read bool
+		ok   bool
+	}{
+		{
+			name: "answers pings",
+			read: true,
+			ok:   true,
+		},
+		{
+			name: "dead peer",
+		},
+	} {
+		ws := wsPair(t, tt.read)
+		stop := k.Start(ws)
+		errc := make(chan error, 1)
+		go func() {
+			_, _, err := ws.NextReader()
+			errc <- err
+		}()
+		select {
+		case err := <-errc:
+			if tt.ok {
+				t.Errorf("%v: NextReader() error = %v", tt.name, err)
+			}
+			if err = PeerTimeout(fakeSession{}, err); !errors.Is(err, ErrPeerTimeout) {
+				t.Errorf("%v: PeerTimeout() error = %v, want %v", tt.name, err, ErrPeerTimeout)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if !tt.ok {
+				t.Errorf("%v: NextReader() did not time out", tt.name)
+			}
+		}
+		stop()
+	}
+}
+
+func TestPeerTimeout(t *testing.T) {
+	err := errors.New("other error")
+	if got := PeerTimeout(fakeSession{}, err); got != err {
+		t.Errorf("PeerTimeout(%v) = %v, want %v", err, got, err)
+	}
+	if got := PeerTimeout(fakeSession{}, nil); got != nil {
+		t.Errorf("PeerTimeout(nil) = %v, want nil", got)
+	}
+}
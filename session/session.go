@@ -8,6 +8,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/hazaelsan/ssh-relay/metrics"
 )
 
 // ProtocolVersion is the version of the SSH relay protocol to use in a session.
@@ -39,11 +40,25 @@ const (
 var (
 	// ErrBadProtocolVersion is returned when a bad protocol version is requested.
 	ErrBadProtocolVersion = errors.New("bad protocol version")
+
+	// ErrDisconnected is returned when the WebSocket leg of a session is lost.
+	// A Session that implements Resumer may be resumed after this error.
+	ErrDisconnected = errors.New("websocket disconnected")
+
+	// ErrReplaced is returned when a Session is resumed over a new WebSocket while the previous one was still attached,
+	// the Session carries on over the new WebSocket.
+	ErrReplaced = errors.New("websocket replaced")
+)
+
+var (
+	expirations  = metrics.NewCounterVec("ssh_relay_session_expirations_total", "Sessions closed after reaching their maximum age.", "protocol")
+	idleTimeouts = metrics.NewCounterVec("ssh_relay_session_idle_timeouts_total", "Sessions closed after being idle for too long.", "protocol")
+	peerTimeouts = metrics.NewCounterVec("ssh_relay_session_peer_timeouts_total", "WebSockets disconnected after the peer stopped responding.", "protocol")
 )
 
 // A Session handles SSH-over-WebSocket Relay sessions.
 type Session interface {
-	// String returns the Session ID as a string, used for logging.
+	// String returns the Session ID as a string, followed by the client identity if known, used for logging.
 	String() string
 
 	// SID returns the Session ID.
@@ -62,6 +77,29 @@ type Session interface {
 	Done() <-chan struct{}
 }
 
+// A Resumer is a Session that can be resumed over a new WebSocket after being disconnected.
+type Resumer interface {
+	Session
+
+	// Resume continues a disconnected session over a new WebSocket.
+	// For servers, ack is the amount of data the client has acknowledged,
+	// any data sent after it is replayed.
+	// Clients ignore ack, replaying data from the position sent by the server instead.
+	// If a WebSocket is still attached it is closed, its pending Run or Resume call returns ErrReplaced.
+	Resume(ws *websocket.Conn, ack uint64) error
+
+	// Attached returns whether a WebSocket is in use by the Session.
+	Attached() bool
+}
+
+// An Idler is a Session that tracks its traffic, so it can be closed when idle.
+type Idler interface {
+	Session
+
+	// Idle returns the time since the last DATA or ack traffic in either direction.
+	Idle() time.Duration
+}
+
 // SetDeadline sets a maximum session deadline, after which the session will be terminated.
 func SetDeadline(s Session, t time.Duration) {
 	glog.V(2).Infof("%v: %v session expires in %v", s, s.Version(), t)
@@ -69,6 +107,7 @@ func SetDeadline(s Session, t time.Duration) {
 		select {
 		case <-time.After(t):
 			glog.V(1).Infof("%v: Session expired", s)
+			expirations.With(s.Version().String()).Inc()
 			s.Close()
 		case <-s.Done():
 			return
@@ -76,6 +115,32 @@ func SetDeadline(s Session, t time.Duration) {
 	}()
 }
 
+// SetIdleTimeout terminates a session once it has been idle for t.
+// Unlike SetDeadline, the timeout is pushed back whenever there is traffic.
+func SetIdleTimeout(s Idler, t time.Duration) {
+	glog.V(2).Infof("%v: %v session idle timeout is %v", s, s.Version(), t)
+	go func() {
+		timer := time.NewTimer(t)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				idle := s.Idle()
+				if idle < t {
+					timer.Reset(t - idle)
+					continue
+				}
+				glog.V(1).Infof("%v: Session idle for %v, closing", s, idle.Round(time.Second))
+				idleTimeouts.With(s.Version().String()).Inc()
+				s.Close()
+				return
+			case <-s.Done():
+				return
+			}
+		}
+	}()
+}
+
 func (v ProtocolVersion) String() string {
 	switch v {
 	case CorpRelay:
@@ -0,0 +1,38 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ErrOCSP is returned when an OCSP response is malformed, or doesn't apply to a certificate.
+var ErrOCSP = errors.New("bad OCSP response")
+
+// checkOCSP parses a DER-encoded OCSP response for cert, issued by issuer, returning the certificate status
+// (ocsp.Good, ocsp.Revoked or ocsp.Unknown).
+// The response must be signed by the issuer (or a responder it delegated to) and be current as of now.
+func checkOCSP(der []byte, cert, issuer *x509.Certificate, now time.Time) (int, error) {
+	resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrOCSP, err)
+	}
+	// The responder certificate is known to be issued by issuer, it must also be authorized and current.
+	if r := resp.Certificate; r != nil && !bytes.Equal(r.Raw, issuer.Raw) {
+		if !slices.Contains(r.ExtKeyUsage, x509.ExtKeyUsageOCSPSigning) {
+			return 0, fmt.Errorf("%w: responder certificate is not authorized for OCSP signing", ErrOCSP)
+		}
+		if now.Before(r.NotBefore) || now.After(r.NotAfter) {
+			return 0, fmt.Errorf("%w: responder certificate expired", ErrOCSP)
+		}
+	}
+	if now.Before(resp.ThisUpdate) || (!resp.NextUpdate.IsZero() && now.After(resp.NextUpdate)) {
+		return 0, fmt.Errorf("%w: response is not current", ErrOCSP)
+	}
+	return resp.Status, nil
+}
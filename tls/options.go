@@ -0,0 +1,127 @@
+package tls
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/tlspb"
+)
+
+var (
+	// ErrBadVersion is returned if min_version/max_version are invalid.
+	ErrBadVersion = errors.New("bad TLS version")
+
+	// ErrBadCipherSuite is returned if a cipher suite is unknown, insecure or not configurable.
+	ErrBadCipherSuite = errors.New("bad cipher suite")
+
+	// ErrBadCurve is returned if a curve is unknown.
+	ErrBadCurve = errors.New("bad curve")
+
+	// ErrBadSessionCacheSize is returned if client_session_cache_size is negative.
+	ErrBadSessionCacheSize = errors.New("bad client session cache size")
+)
+
+var (
+	versionMap = map[tlspb.TlsConfig_TlsVersion]uint16{
+		tlspb.TlsConfig_TLS_1_2: tls.VersionTLS12,
+		tlspb.TlsConfig_TLS_1_3: tls.VersionTLS13,
+	}
+
+	curveMap = map[string]tls.CurveID{
+		"X25519": tls.X25519,
+		"P256":   tls.CurveP256,
+		"P384":   tls.CurveP384,
+		"P521":   tls.CurveP521,
+	}
+)
+
+// Version converts a proto TlsVersion to its tls package equivalent, 0 if unspecified.
+func Version(v tlspb.TlsConfig_TlsVersion) (uint16, error) {
+	if v == tlspb.TlsConfig_TLS_VERSION_UNSPECIFIED {
+		return 0, nil
+	}
+	if val, ok := versionMap[v]; ok {
+		return val, nil
+	}
+	return 0, fmt.Errorf("%w: %v", ErrBadVersion, v)
+}
+
+// applyOptions validates the TLS policy options in a proto message and sets them in a *tls.Config.
+func applyOptions(c *tls.Config, cfg *tlspb.TlsConfig) error {
+	minVersion, err := Version(cfg.GetMinVersion())
+	if err != nil {
+		return fmt.Errorf("min_version: %w", err)
+	}
+	if minVersion != 0 {
+		c.MinVersion = minVersion
+	}
+	if c.MaxVersion, err = Version(cfg.GetMaxVersion()); err != nil {
+		return fmt.Errorf("max_version: %w", err)
+	}
+	if c.MaxVersion != 0 && c.MaxVersion < c.MinVersion {
+		return fmt.Errorf("%w: max_version %v is lower than min_version %v", ErrBadVersion, tls.VersionName(c.MaxVersion), tls.VersionName(c.MinVersion))
+	}
+	if c.CipherSuites, err = cipherSuites(cfg.GetCipherSuites()); err != nil {
+		return err
+	}
+	if len(c.CipherSuites) > 0 && c.MinVersion >= tls.VersionTLS13 {
+		return fmt.Errorf("%w: cipher_suites only apply to TLS 1.2, min_version is %v", ErrBadCipherSuite, tls.VersionName(c.MinVersion))
+	}
+	for _, name := range cfg.GetCurvePreferences() {
+		id, ok := curveMap[name]
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrBadCurve, name)
+		}
+		if slices.Contains(c.CurvePreferences, id) {
+			return fmt.Errorf("%w: %q listed more than once", ErrBadCurve, name)
+		}
+		c.CurvePreferences = append(c.CurvePreferences, id)
+	}
+	c.NextProtos = cfg.GetAlpnProtocols()
+	c.ServerName = cfg.GetServerName()
+	c.SessionTicketsDisabled = cfg.GetDisableSessionTickets()
+	switch n := cfg.GetClientSessionCacheSize(); {
+	case n < 0:
+		return fmt.Errorf("%w: %v", ErrBadSessionCacheSize, n)
+	case n > 0:
+		c.ClientSessionCache = tls.NewLRUClientSessionCache(int(n))
+	}
+	return nil
+}
+
+// cipherSuites converts cipher suite names to their IDs, only secure TLS 1.2 cipher suites are allowed.
+func cipherSuites(names []string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range names {
+		id, err := cipherSuite(name)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(ids, id) {
+			return nil, fmt.Errorf("%w: %v listed more than once", ErrBadCipherSuite, name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// cipherSuite returns the ID of a secure TLS 1.2 cipher suite.
+func cipherSuite(name string) (uint16, error) {
+	for _, cs := range tls.InsecureCipherSuites() {
+		if cs.Name == name {
+			return 0, fmt.Errorf("%w: %v is insecure", ErrBadCipherSuite, name)
+		}
+	}
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name != name {
+			continue
+		}
+		if !slices.Contains(cs.SupportedVersions, tls.VersionTLS12) {
+			return 0, fmt.Errorf("%w: %v is not configurable", ErrBadCipherSuite, name)
+		}
+		return cs.ID, nil
+	}
+	return 0, fmt.Errorf("%w: unknown cipher suite %q", ErrBadCipherSuite, name)
+}
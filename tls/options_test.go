@@ -0,0 +1,170 @@
+package tls
+
+import (
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/tlspb"
+)
+
+func TestApplyOptions(t *testing.T) {
+	testdata := []struct {
+		name    string
+		cfg     *tlspb.TlsConfig
+		want    *tls.Config
+		wantErr error
+	}{
+		{
+			name: "defaults",
+			cfg:  new(tlspb.TlsConfig),
+			want: &tls.Config{MinVersion: tls.VersionTLS12},
+		},
+		{
+			name: "tls 1.3 only",
+			cfg: &tlspb.TlsConfig{
+				MinVersion: tlspb.TlsConfig_TLS_1_3,
+				MaxVersion: tlspb.TlsConfig_TLS_1_3,
+			},
+			want: &tls.Config{
+				MinVersion: tls.VersionTLS13,
+				MaxVersion: tls.VersionTLS13,
+			},
+		},
+		{
+			name: "all options",
+			cfg: &tlspb.TlsConfig{
+				CipherSuites: []string{
+					"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+					"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+				},
+				CurvePreferences:      []string{"X25519", "P256"},
+				AlpnProtocols:         []string{"h2", "http/1.1"},
+				ServerName:            "relay.example.org",
+				DisableSessionTickets: true,
+			},
+			want: &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				CipherSuites: []uint16{
+					tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+					tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				},
+				CurvePreferences:       []tls.CurveID{tls.X25519, tls.CurveP256},
+				NextProtos:             []string{"h2", "http/1.1"},
+				ServerName:             "relay.example.org",
+				SessionTicketsDisabled: true,
+			},
+		},
+		{
+			name:    "bad min version",
+			cfg:     &tlspb.TlsConfig{MinVersion: 100},
+			wantErr: ErrBadVersion,
+		},
+		{
+			name:    "bad max version",
+			cfg:     &tlspb.TlsConfig{MaxVersion: 100},
+			wantErr: ErrBadVersion,
+		},
+		{
+			name: "max version below min version",
+			cfg: &tlspb.TlsConfig{
+				MinVersion: tlspb.TlsConfig_TLS_1_3,
+				MaxVersion: tlspb.TlsConfig_TLS_1_2,
+			},
+			wantErr: ErrBadVersion,
+		},
+		{
+			name:    "unknown cipher suite",
+			cfg:     &tlspb.TlsConfig{CipherSuites: []string{"TLS_FOO"}},
+			wantErr: ErrBadCipherSuite,
+		},
+		{
+			name:    "insecure cipher suite",
+			cfg:     &tlspb.TlsConfig{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+			wantErr: ErrBadCipherSuite,
+		},
+		{
+			name:    "tls 1.3 cipher suite",
+			cfg:     &tlspb.TlsConfig{CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+			wantErr: ErrBadCipherSuite,
+		},
+		{
+			name: "duplicate cipher suite",
+			cfg: &tlspb.TlsConfig{CipherSuites: []string{
+				"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+				"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+			}},
+			wantErr: ErrBadCipherSuite,
+		},
+		{
+			name: "cipher suites with tls 1.3 only",
+			cfg: &tlspb.TlsConfig{
+				MinVersion:   tlspb.TlsConfig_TLS_1_3,
+				CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+			},
+			wantErr: ErrBadCipherSuite,
+		},
+		{
+			name:    "unknown curve",
+			cfg:     &tlspb.TlsConfig{CurvePreferences: []string{"P224"}},
+			wantErr: ErrBadCurve,
+		},
+		{
+			name:    "duplicate curve",
+			cfg:     &tlspb.TlsConfig{CurvePreferences: []string{"X25519", "X25519"}},
+			wantErr: ErrBadCurve,
+		},
+		{
+			name:    "negative session cache size",
+			cfg:     &tlspb.TlsConfig{ClientSessionCacheSize: -1},
+			wantErr: ErrBadSessionCacheSize,
+		},
+	}
+	for _, tt := range testdata {
+		got := &tls.Config{MinVersion: TLSMinVersion}
+		if err := applyOptions(got, tt.cfg); err != nil {
+			if tt.wantErr == nil || !errors.Is(err, tt.wantErr) {
+				t.Errorf("applyOptions(%v) error = %v, want %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if tt.wantErr != nil {
+			t.Errorf("applyOptions(%v) error = nil, want %v", tt.name, tt.wantErr)
+			continue
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("applyOptions(%v) diff (-got +want):\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestApplyOptions_ClientSessionCache(t *testing.T) {
+	c := new(tls.Config)
+	if err := applyOptions(c, &tlspb.TlsConfig{ClientSessionCacheSize: 10}); err != nil {
+		t.Fatalf("applyOptions() error = %v", err)
+	}
+	if c.ClientSessionCache == nil {
+		t.Error("applyOptions() ClientSessionCache = nil")
+	}
+}
+
+func TestCertConfig_Options(t *testing.T) {
+	cfg := &tlspb.TlsConfig{
+		CertFile:   "../testdata/test.crt",
+		KeyFile:    "../testdata/test.key",
+		MinVersion: tlspb.TlsConfig_TLS_1_3,
+	}
+	c, err := CertConfig(cfg)
+	if err != nil {
+		t.Fatalf("CertConfig() error = %v", err)
+	}
+	if c.MinVersion != tls.VersionTLS13 {
+		t.Errorf("CertConfig() MinVersion = %v, want %v", tls.VersionName(c.MinVersion), tls.VersionName(tls.VersionTLS13))
+	}
+	cfg.MaxVersion = tlspb.TlsConfig_TLS_1_2
+	if _, err := TransportCreds(cfg); !errors.Is(err, ErrBadVersion) {
+		t.Errorf("TransportCreds() error = %v, want %v", err, ErrBadVersion)
+	}
+}
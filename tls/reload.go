@@ -0,0 +1,298 @@
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hazaelsan/ssh-relay/duration"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/tlspb"
+)
+
+// DefaultReloadInterval is how often files are checked for changes if reload_interval is unset.
+const DefaultReloadInterval = time.Minute
+
+// ErrNoServerName is returned by client handshakes verified against reloaded root CAs if the server name is unknown,
+// e.g., when connecting to an IP address.
+var ErrNoServerName = errors.New("no server name to verify")
+
+// NewReloader creates a *Reloader from a proto message, loading the cert/key pair if cert_file is set.
+// Returns nil if TLS is disabled in the config.
+func NewReloader(cfg *tlspb.TlsConfig) (*Reloader, error) {
+	return newReloader(cfg, cfg.GetCertFile() != "")
+}
+
+// newReloader creates a *Reloader, loading the cert/key pair if withCert is set.
+func newReloader(cfg *tlspb.TlsConfig, withCert bool) (*Reloader, error) {
+	if cfg.GetTlsMode() == tlspb.TlsConfig_TLS_MODE_DISABLED {
+		return nil, nil
+	}
+	cat, err := ClientAuthType(cfg.GetClientAuthType())
+	if err != nil {
+		return nil, err
+	}
+	r := &Reloader{
+		cfg:                cfg,
+		withCert:           withCert,
+		requireCurrentCRLs: cfg.GetRequireCurrentCrls(),
+		interval:           DefaultReloadInterval,
+		base: &tls.Config{
+			ClientAuth: cat,
+			MinVersion: TLSMinVersion,
+		},
+	}
+	if err := applyOptions(r.base, cfg); err != nil {
+		return nil, err
+	}
+	if r.failOpen, err = failOpen(cfg.GetRevocationFailureMode()); err != nil {
+		return nil, err
+	}
+	if cfg.GetReloadInterval() != nil {
+		if err := duration.FromProto(&r.interval, cfg.GetReloadInterval()); err != nil {
+			return nil, fmt.Errorf("duration.FromProto(%v) error: %w", cfg.GetReloadInterval(), err)
+		}
+	}
+	r.stamps = r.stat()
+	files, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.files.Store(files)
+	r.checked.Store(time.Now().UnixNano())
+	r.base.RootCAs = files.rootCAs
+	r.base.ClientCAs = files.clientCAs
+	if withCert {
+		r.base.GetCertificate = r.GetCertificate
+		r.base.GetClientCertificate = r.GetClientCertificate
+	}
+	if r.interval > 0 && len(cfg.GetClientCaCerts()) > 0 {
+		r.base.GetConfigForClient = r.getConfigForClient
+	}
+	if len(cfg.GetCrlFiles()) > 0 {
+		r.base.VerifyPeerCertificate = r.verifyPeerCertificate
+	}
+	if cfg.GetCheckOcspStaples() {
+		r.base.VerifyConnection = r.verifyConnection
+	}
+	r.client = r.base
+	if r.interval > 0 && len(cfg.GetRootCaCerts()) > 0 {
+		// crypto/tls only verifies servers against the static RootCAs, verify them against the current pool instead.
+		r.client = r.base.Clone()
+		r.client.InsecureSkipVerify = true
+		r.client.VerifyPeerCertificate = nil
+		r.client.VerifyConnection = r.verifyServer
+	}
+	return r, nil
+}
+
+// A Reloader keeps the certificate/key pair, the CA pools and the CRLs from a TlsConfig up to date.
+// Files are checked for changes (modification time or size) on new connections, at most once per
+// reload_interval; a file that fails to load is logged and the previous version kept.
+type Reloader struct {
+	cfg                *tlspb.TlsConfig
+	withCert           bool
+	failOpen           bool
+	requireCurrentCRLs bool
+	interval           time.Duration
+	base               *tls.Config
+	client             *tls.Config
+
+	files   atomic.Pointer[loadedFiles]
+	checked atomic.Int64 // Unix time in nanoseconds.
+
+	// reloading is held while files are checked for changes, stamps is only accessed with it held.
+	reloading sync.Mutex
+	stamps    []fileStamp
+}
+
+// loadedFiles holds the contents of the files referenced by a TlsConfig.
+type loadedFiles struct {
+	cert      *tls.Certificate
+	rootCAs   *x509.CertPool
+	clientCAs *x509.CertPool
+	crls      []*crl
+}
+
+// A fileStamp identifies a version of a file, the zero value is used for missing files.
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+// Config returns the *tls.Config backed by the Reloader, for use by servers.
+// Certificates and client CA pools are always current, RootCAs is only loaded once; use ClientConfig for clients.
+// Changes made to the returned *tls.Config apply to all future connections.
+func (r *Reloader) Config() *tls.Config {
+	return r.base
+}
+
+// ClientConfig returns the *tls.Config backed by the Reloader, for use by clients.
+// Certificates are always current and servers are verified against the current root CA pool;
+// if root_ca_certs are reloaded, servers must be addressed by hostname, see ErrNoServerName.
+// Changes made to the returned *tls.Config apply to all future connections.
+func (r *Reloader) ClientConfig() *tls.Config {
+	return r.client
+}
+
+// GetCertificate returns the current certificate, for use as tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.current().cert, nil
+}
+
+// GetClientCertificate returns the current certificate, for use as tls.Config.GetClientCertificate.
+func (r *Reloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.current().cert, nil
+}
+
+// verifyServer verifies the server certificate chain against the current root CA pool,
+// then checks the verified chains for revocation as configured.
+func (r *Reloader) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no server certificate")
+	}
+	if cs.ServerName == "" {
+		// crypto/tls does not send (nor report) IP addresses as server names.
+		return ErrNoServerName
+	}
+	opts := x509.VerifyOptions{
+		Roots:         r.current().rootCAs,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := cs.PeerCertificates[0].Verify(opts)
+	if err != nil {
+		return &tls.CertificateVerificationError{UnverifiedCertificates: cs.PeerCertificates, Err: err}
+	}
+	if r.base.VerifyPeerCertificate != nil {
+		if err := r.base.VerifyPeerCertificate(nil, chains); err != nil {
+			return err
+		}
+	}
+	if r.base.VerifyConnection != nil {
+		cs.VerifiedChains = chains
+		return r.base.VerifyConnection(cs)
+	}
+	return nil
+}
+
+// getConfigForClient returns a *tls.Config using the current client CA pool, nil if unchanged.
+func (r *Reloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	pool := r.current().clientCAs
+	if pool == r.base.ClientCAs {
+		return nil, nil
+	}
+	c := r.base.Clone()
+	c.ClientCAs = pool
+	return c, nil
+}
+
+// current returns the loaded files, reloading them if the reload interval elapsed and they changed on disk.
+// Only one caller checks the files at a time, concurrent callers keep using the current ones meanwhile.
+func (r *Reloader) current() *loadedFiles {
+	files := r.files.Load()
+	if !r.due() || !r.reloading.TryLock() {
+		return files
+	}
+	defer r.reloading.Unlock()
+	if !r.due() {
+		// Checked by another caller since.
+		return r.files.Load()
+	}
+	r.checked.Store(time.Now().UnixNano())
+	stamps := r.stat()
+	if slices.Equal(stamps, r.stamps) {
+		return files
+	}
+	loaded, err := r.load()
+	if err != nil {
+		glog.Errorf("TLS files changed but could not be loaded, keeping the current ones: %v", err)
+		return files
+	}
+	glog.Infof("Reloaded TLS files for %v", r.cfg.GetCertFile())
+	r.files.Store(loaded)
+	r.stamps = stamps
+	return loaded
+}
+
+// due returns whether the reload interval elapsed since files were last checked.
+func (r *Reloader) due() bool {
+	return r.interval > 0 && time.Since(time.Unix(0, r.checked.Load())) >= r.interval
+}
+
+// paths returns the paths of all the files referenced by the config.
+func (r *Reloader) paths() []string {
+	var paths []string
+	if r.withCert {
+		paths = append(paths, r.cfg.GetCertFile(), r.cfg.GetKeyFile())
+	}
+	paths = append(paths, r.cfg.GetRootCaCerts()...)
+	paths = append(paths, r.cfg.GetClientCaCerts()...)
+	return append(paths, r.cfg.GetCrlFiles()...)
+}
+
+// stat returns the current fileStamp for all the files referenced by the config.
+func (r *Reloader) stat() []fileStamp {
+	paths := r.paths()
+	stamps := make([]fileStamp, len(paths))
+	for i, p := range paths {
+		if fi, err := os.Stat(p); err == nil {
+			stamps[i] = fileStamp{modTime: fi.ModTime().UnixNano(), size: fi.Size()}
+		}
+	}
+	return stamps
+}
+
+// load loads all the files referenced by the config.
+func (r *Reloader) load() (*loadedFiles, error) {
+	f := new(loadedFiles)
+	var err error
+	if f.clientCAs, err = loadCerts(r.cfg.GetClientCaCerts()); err != nil {
+		return nil, err
+	}
+	if f.rootCAs, err = loadCerts(r.cfg.GetRootCaCerts()); err != nil {
+		return nil, err
+	}
+	if f.crls, err = loadCRLs(r.cfg.GetCrlFiles()); err != nil {
+		return nil, err
+	}
+	if r.withCert {
+		cert, err := tls.LoadX509KeyPair(r.cfg.GetCertFile(), r.cfg.GetKeyFile())
+		if err != nil {
+			return nil, err
+		}
+		f.cert = &cert
+	}
+	return f, nil
+}
+
+// reloadingCreds are gRPC TLS credentials using ClientConfig for client handshakes,
+// server handshakes use the current certificate and client CA pool via Config.
+type reloadingCreds struct {
+	credentials.TransportCredentials
+	r *Reloader
+}
+
+func (c *reloadingCreds) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return credentials.NewTLS(c.r.ClientConfig()).ClientHandshake(ctx, authority, conn)
+}
+
+func (c *reloadingCreds) Clone() credentials.TransportCredentials {
+	return &reloadingCreds{
+		TransportCredentials: c.TransportCredentials.Clone(),
+		r:                    c.r,
+	}
+}
@@ -0,0 +1,283 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/tlspb"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1 and its key to dir, bumping their modification time.
+func writeCert(t *testing.T, dir, cn string, bump time.Duration) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), bump)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), bump)
+	return certFile, keyFile
+}
+
+// writeFile writes a file, setting its modification time to now+bump.
+func writeFile(t *testing.T, name string, b []byte, bump time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(name, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(bump)
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// commonName returns the CN of a certificate's leaf.
+func commonName(t *testing.T, c *tls.Certificate) string {
+	t.Helper()
+	cert, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Subject.CommonName
+}
+
+func TestReloader_Certificate(t *testing.T) {
+	testdata := []struct {
+		name     string
+		interval *durationpb.Duration
+		want     string
+	}{
+		{
+			name:     "reload",
+			interval: &durationpb.Duration{Nanos: 1},
+			want:     "new",
+		},
+		{
+			name:     "disabled",
+			interval: new(durationpb.Duration),
+			want:     "old",
+		},
+		{
+			name: "default interval",
+			want: "old",
+		},
+	}
+	for _, tt := range testdata {
+		dir := t.TempDir()
+		certFile, keyFile := writeCert(t, dir, "old", 0)
+		r, err := NewReloader(&tlspb.TlsConfig{
+			CertFile:       certFile,
+			KeyFile:        keyFile,
+			ReloadInterval: tt.interval,
+		})
+		if err != nil {
+			t.Fatalf("NewReloader(%v) error = %v", tt.name, err)
+		}
+		writeCert(t, dir, "new", time.Hour)
+		time.Sleep(time.Millisecond)
+		c, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetCertificate(%v) error = %v", tt.name, err)
+		}
+		if got := commonName(t, c); got != tt.want {
+			t.Errorf("GetCertificate(%v) CN = %v, want %v", tt.name, got, tt.want)
+		}
+		c, err = r.GetClientCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetClientCertificate(%v) error = %v", tt.name, err)
+		}
+		if got := commonName(t, c); got != tt.want {
+			t.Errorf("GetClientCertificate(%v) CN = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReloader_BadFile(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "old", 0)
+	r, err := NewReloader(&tlspb.TlsConfig{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ReloadInterval: &durationpb.Duration{Nanos: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, certFile, []byte("invalid"), time.Hour)
+	time.Sleep(time.Millisecond)
+	c, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	if got, want := commonName(t, c), "old"; got != want {
+		t.Errorf("GetCertificate() CN = %v, want %v", got, want)
+	}
+}
+
+func TestReloader_Reloading(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "old", 0)
+	r, err := NewReloader(&tlspb.TlsConfig{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ReloadInterval: &durationpb.Duration{Nanos: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeCert(t, dir, "new", time.Hour)
+	time.Sleep(time.Millisecond)
+
+	// Handshakes don't wait for a reload in progress, they keep using the current files.
+	r.reloading.Lock()
+	c, err := r.GetCertificate(nil)
+	r.reloading.Unlock()
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	if got, want := commonName(t, c), "old"; got != want {
+		t.Errorf("GetCertificate() while reloading CN = %v, want %v", got, want)
+	}
+	if c, err = r.GetCertificate(nil); err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	if got, want := commonName(t, c), "new"; got != want {
+		t.Errorf("GetCertificate() CN = %v, want %v", got, want)
+	}
+}
+
+func TestReloader_ClientCAs(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "old", 0)
+	r, err := NewReloader(&tlspb.TlsConfig{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ClientCaCerts:  []string{certFile},
+		ReloadInterval: &durationpb.Duration{Nanos: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := r.Config()
+	c, err := base.GetConfigForClient(nil)
+	if err != nil || c != nil {
+		t.Errorf("GetConfigForClient() = %v, %v, want nil, nil", c, err)
+	}
+	writeCert(t, dir, "new", time.Hour)
+	time.Sleep(time.Millisecond)
+	c, err = base.GetConfigForClient(nil)
+	if err != nil {
+		t.Fatalf("GetConfigForClient() error = %v", err)
+	}
+	if c == nil || c.ClientCAs == nil || c.ClientCAs == base.ClientCAs {
+		t.Errorf("GetConfigForClient() did not use the reloaded client CA pool")
+	}
+}
+
+func TestReloader_ClientConfig(t *testing.T) {
+	serverDir, clientDir := t.TempDir(), t.TempDir()
+	certFile, keyFile := writeCert(t, serverDir, "server", 0)
+	srv, err := CertConfig(&tlspb.TlsConfig{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ClientAuthType: tlspb.TlsConfig_NO_CLIENT_CERT,
+		ReloadInterval: &durationpb.Duration{Nanos: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	rootFile := filepath.Join(clientDir, "ca.pem")
+	copyFile := func(bump time.Duration) {
+		b, err := os.ReadFile(certFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, rootFile, b, bump)
+	}
+	copyFile(0)
+	r, err := NewReloader(&tlspb.TlsConfig{
+		RootCaCerts:    []string{rootFile},
+		ReloadInterval: &durationpb.Duration{Nanos: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := r.ClientConfig().Clone()
+	c.ServerName = "localhost"
+	dial := func() error {
+		conn, err := tls.Dial("tcp", l.Addr().String(), c)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	if err := dial(); err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	// Rotate the server certificate, the client only trusts the new one after reloading its roots.
+	writeCert(t, serverDir, "server", time.Hour)
+	time.Sleep(time.Millisecond)
+	if err := dial(); err == nil {
+		t.Error("Dial() with stale roots error = nil")
+	}
+	copyFile(time.Hour)
+	time.Sleep(time.Millisecond)
+	if err := dial(); err != nil {
+		t.Errorf("Dial() after reload error = %v", err)
+	}
+	if _, err := tls.Dial("tcp", l.Addr().String(), r.ClientConfig()); !errors.Is(err, ErrNoServerName) {
+		t.Errorf("Dial(IP address) error = %v, want %v", err, ErrNoServerName)
+	}
+}
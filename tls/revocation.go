@@ -0,0 +1,204 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hazaelsan/ssh-relay/metrics"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/tlspb"
+)
+
+var (
+	// ErrRevoked is returned when a peer certificate has been revoked.
+	ErrRevoked = errors.New("certificate revoked")
+
+	// ErrRevocationUnknown is returned when the revocation status of a peer certificate can't be determined
+	// and revocation_failure_mode is FAIL_CLOSED.
+	ErrRevocationUnknown = errors.New("certificate revocation status unknown")
+
+	// ErrBadRevocationFailureMode is returned if the corresponding revocation failure mode is unknown.
+	ErrBadRevocationFailureMode = errors.New("bad revocation failure mode")
+)
+
+var (
+	revocationRejections = metrics.NewCounterVec("tls_revocation_rejections_total", "Peer certificates rejected by revocation checks.", "reason")
+	revocationSoftFails  = metrics.NewCounterVec("tls_revocation_soft_failures_total", "Peer certificates allowed despite an unknown revocation status (fail-open).", "reason")
+)
+
+// Revocation check results, used as metric reasons.
+const (
+	reasonCRLRevoked     = "crl_revoked"
+	reasonCRLUnavailable = "crl_unavailable"
+	reasonOCSPRevoked    = "ocsp_revoked"
+	reasonOCSPUnknown    = "ocsp_unknown"
+	reasonOCSPInvalid    = "ocsp_invalid"
+)
+
+// failOpen returns whether connections are allowed when the revocation status is unknown.
+func failOpen(m tlspb.TlsConfig_RevocationFailureMode) (bool, error) {
+	switch m {
+	case tlspb.TlsConfig_REVOCATION_FAILURE_MODE_UNSPECIFIED, tlspb.TlsConfig_FAIL_CLOSED:
+		return false, nil
+	case tlspb.TlsConfig_FAIL_OPEN:
+		return true, nil
+	}
+	return false, fmt.Errorf("%w: %v", ErrBadRevocationFailureMode, m)
+}
+
+// A crl is a parsed Certificate Revocation List.
+type crl struct {
+	list    *x509.RevocationList
+	revoked map[string]bool // Keyed by serial number.
+}
+
+// loadCRLs loads CRLs from PEM or DER files.
+func loadCRLs(files []string) ([]*crl, error) {
+	var crls []*crl
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("ReadFile(%v) error: %w", f, err)
+		}
+		ders := [][]byte{b}
+		if blocks := pemBlocks(b, "X509 CRL"); len(blocks) > 0 {
+			ders = blocks
+		}
+		for _, der := range ders {
+			l, err := x509.ParseRevocationList(der)
+			if err != nil {
+				return nil, fmt.Errorf("ParseRevocationList(%v) error: %w", f, err)
+			}
+			c := &crl{list: l, revoked: make(map[string]bool)}
+			for _, e := range l.RevokedCertificateEntries {
+				c.revoked[e.SerialNumber.String()] = true
+			}
+			crls = append(crls, c)
+		}
+	}
+	return crls, nil
+}
+
+// pemBlocks returns the contents of all the PEM blocks of a given type.
+func pemBlocks(b []byte, typ string) [][]byte {
+	var ders [][]byte
+	for {
+		var block *pem.Block
+		if block, b = pem.Decode(b); block == nil {
+			return ders
+		}
+		if block.Type == typ {
+			ders = append(ders, block.Bytes)
+		}
+	}
+}
+
+// checkCRLs checks a certificate against the CRLs from its issuer, certificates from issuers without a CRL aren't checked.
+// Returns ErrRevoked if it's listed, ErrRevocationUnknown if none of the issuer's CRLs is validly signed,
+// or none is current and requireCurrent is set; expired CRLs are still used otherwise.
+func checkCRLs(crls []*crl, cert, issuer *x509.Certificate, now time.Time, requireCurrent bool) error {
+	found, valid, current := false, false, false
+	for _, c := range crls {
+		if !bytes.Equal(c.list.RawIssuer, issuer.RawSubject) {
+			continue
+		}
+		found = true
+		if c.list.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		valid = true
+		if c.revoked[cert.SerialNumber.String()] {
+			return fmt.Errorf("%w: %v (serial %v)", ErrRevoked, cert.Subject, cert.SerialNumber)
+		}
+		if c.list.NextUpdate.IsZero() || now.Before(c.list.NextUpdate) {
+			current = true
+		}
+	}
+	switch {
+	case !found:
+		return nil
+	case !valid:
+		return fmt.Errorf("%w: no validly signed CRL from %v", ErrRevocationUnknown, issuer.Subject)
+	case requireCurrent && !current:
+		return fmt.Errorf("%w: no current CRL from %v", ErrRevocationUnknown, issuer.Subject)
+	}
+	return nil
+}
+
+// verifyPeerCertificate checks verified peer chains against the current CRLs, for use as
+// tls.Config.VerifyPeerCertificate; a connection is allowed if any of the chains passes.
+func (r *Reloader) verifyPeerCertificate(_ [][]byte, chains [][]*x509.Certificate) error {
+	if len(chains) == 0 {
+		// Nothing was verified (e.g., REQUIRE_ANY_CLIENT_CERT), so there's no issuer to check against.
+		return nil
+	}
+	crls := r.current().crls
+	now := time.Now()
+	var err error
+	for _, chain := range chains {
+		if err = checkChain(crls, chain, now, r.requireCurrentCRLs); err == nil {
+			return nil
+		}
+	}
+	if errors.Is(err, ErrRevoked) {
+		return r.reject(reasonCRLRevoked, err)
+	}
+	return r.unknown(reasonCRLUnavailable, err)
+}
+
+// checkChain checks every certificate in a verified chain against the CRLs of its issuer, see checkCRLs.
+func checkChain(crls []*crl, chain []*x509.Certificate, now time.Time, requireCurrent bool) error {
+	for i := 0; i+1 < len(chain); i++ {
+		if err := checkCRLs(crls, chain[i], chain[i+1], now, requireCurrent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyConnection checks a stapled OCSP response for the peer's leaf certificate if present,
+// for use as tls.Config.VerifyConnection.
+func (r *Reloader) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.OCSPResponse) == 0 || len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) < 2 {
+		return nil
+	}
+	leaf, issuer := cs.VerifiedChains[0][0], cs.VerifiedChains[0][1]
+	status, err := checkOCSP(cs.OCSPResponse, leaf, issuer, time.Now())
+	switch {
+	case err != nil:
+		return r.unknown(reasonOCSPInvalid, err)
+	case status == ocsp.Revoked:
+		return r.reject(reasonOCSPRevoked, fmt.Errorf("%w: %v (serial %v) per OCSP", ErrRevoked, leaf.Subject, leaf.SerialNumber))
+	case status == ocsp.Unknown:
+		return r.unknown(reasonOCSPUnknown, fmt.Errorf("%w: OCSP status unknown for %v", ErrRevocationUnknown, leaf.Subject))
+	}
+	return nil
+}
+
+// reject records and returns a revocation error.
+func (r *Reloader) reject(reason string, err error) error {
+	revocationRejections.With(reason).Inc()
+	glog.Warningf("Rejected peer certificate: %v", err)
+	return err
+}
+
+// unknown handles a certificate whose revocation status is unknown per the failure mode.
+func (r *Reloader) unknown(reason string, err error) error {
+	if r.failOpen {
+		revocationSoftFails.With(reason).Inc()
+		glog.Warningf("Allowing peer certificate (fail-open): %v", err)
+		return nil
+	}
+	if !errors.Is(err, ErrRevocationUnknown) {
+		err = fmt.Errorf("%w: %w", ErrRevocationUnknown, err)
+	}
+	return r.reject(reason, err)
+}
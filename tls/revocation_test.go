@@ -0,0 +1,586 @@
+package tls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/tlspb"
+)
+
+// testCA is a CA issuing test certificates, CRLs and OCSP responses.
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newTestCA(t *testing.T, cn string) *testCA {
+	t.Helper()
+	cert, key := issue(t, nil, cn, 1, true, nil)
+	return &testCA{cert: cert, key: key}
+}
+
+// newRSATestCA creates a self-signed CA with an RSA key.
+func newRSATestCA(t *testing.T, cn string) *testCA {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issue creates a certificate signed by ca, self-signed if ca is nil.
+func issue(t *testing.T, ca *testCA, cn string, serial int64, isCA bool, eku []x509.ExtKeyUsage) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           eku,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if isCA {
+		tmpl.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+	parent, signer := tmpl, crypto.Signer(key)
+	if ca != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// crl creates a DER-encoded CRL revoking serials, valid until nextUpdate.
+func (ca *testCA) crl(t *testing.T, nextUpdate time.Time, serials ...int64) []byte {
+	t.Helper()
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: nextUpdate,
+	}
+	for _, s := range serials {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(s),
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// ocsp creates a DER-encoded OCSP response for cert, signed by signer (the CA if nil) using algo (the default if 0).
+func (ca *testCA) ocsp(t *testing.T, cert *x509.Certificate, status int, nextUpdate time.Time, signer *testCA, algo x509.SignatureAlgorithm) []byte {
+	t.Helper()
+	tmpl := ocsp.Response{
+		Status:             status,
+		SerialNumber:       cert.SerialNumber,
+		ThisUpdate:         time.Now().Add(-time.Hour),
+		NextUpdate:         nextUpdate,
+		RevokedAt:          time.Now().Add(-time.Minute),
+		SignatureAlgorithm: algo,
+	}
+	responder, key := ca.cert, ca.key
+	if signer != nil {
+		responder, key = signer.cert, signer.key
+		tmpl.Certificate = signer.cert
+	}
+	der, err := ocsp.CreateResponse(ca.cert, responder, tmpl, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestLoadCRLs(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	der := ca.crl(t, time.Now().Add(time.Hour), 5)
+	dir := t.TempDir()
+	derFile := filepath.Join(dir, "crl.der")
+	pemFile := filepath.Join(dir, "crl.pem")
+	badFile := filepath.Join(dir, "bad.crl")
+	for name, b := range map[string][]byte{
+		derFile: der,
+		pemFile: pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}),
+		badFile: []byte("invalid"),
+	} {
+		if err := os.WriteFile(name, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testdata := []struct {
+		files []string
+		want  int
+		ok    bool
+	}{
+		{
+			files: []string{derFile, pemFile},
+			want:  2,
+			ok:    true,
+		},
+		{
+			files: []string{badFile},
+		},
+		{
+			files: []string{filepath.Join(dir, "missing.crl")},
+		},
+	}
+	for _, tt := range testdata {
+		got, err := loadCRLs(tt.files)
+		if err != nil {
+			if tt.ok {
+				t.Errorf("loadCRLs(%v) error = %v", tt.files, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("loadCRLs(%v) error = nil", tt.files)
+		}
+		if len(got) != tt.want {
+			t.Errorf("len(loadCRLs(%v)) = %v, want %v", tt.files, len(got), tt.want)
+		}
+		for _, c := range got {
+			if !c.revoked["5"] {
+				t.Errorf("loadCRLs(%v) serial 5 not revoked", tt.files)
+			}
+		}
+	}
+}
+
+func TestCheckCRLs(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	other := newTestCA(t, "ca")
+	leaf, _ := issue(t, ca, "leaf", 5, false, nil)
+	parse := func(der []byte) *crl {
+		l, err := x509.ParseRevocationList(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &crl{list: l, revoked: make(map[string]bool)}
+		for _, e := range l.RevokedCertificateEntries {
+			c.revoked[e.SerialNumber.String()] = true
+		}
+		return c
+	}
+	testdata := []struct {
+		name           string
+		crls           []*crl
+		requireCurrent bool
+		wantErr        error
+	}{
+		{
+			name: "not revoked",
+			crls: []*crl{parse(ca.crl(t, time.Now().Add(time.Hour), 6))},
+		},
+		{
+			name:    "revoked",
+			crls:    []*crl{parse(ca.crl(t, time.Now().Add(time.Hour), 5))},
+			wantErr: ErrRevoked,
+		},
+		{
+			name:    "revoked in stale crl",
+			crls:    []*crl{parse(ca.crl(t, time.Now().Add(-time.Minute), 5))},
+			wantErr: ErrRevoked,
+		},
+		{
+			name: "stale crl",
+			crls: []*crl{parse(ca.crl(t, time.Now().Add(-time.Minute)))},
+		},
+		{
+			name:           "stale crl, current required",
+			crls:           []*crl{parse(ca.crl(t, time.Now().Add(-time.Minute)))},
+			requireCurrent: true,
+			wantErr:        ErrRevocationUnknown,
+		},
+		{
+			name:           "stale and current crls, current required",
+			crls:           []*crl{parse(ca.crl(t, time.Now().Add(-time.Minute))), parse(ca.crl(t, time.Now().Add(time.Hour)))},
+			requireCurrent: true,
+		},
+		{
+			name:           "no crl from issuer",
+			crls:           []*crl{parse(newTestCA(t, "other").crl(t, time.Now().Add(time.Hour), 5))},
+			requireCurrent: true,
+		},
+		{
+			name: "no crl",
+		},
+		{
+			// Same issuer name, signed by a different key.
+			name:    "forged crl",
+			crls:    []*crl{parse(other.crl(t, time.Now().Add(time.Hour)))},
+			wantErr: ErrRevocationUnknown,
+		},
+	}
+	for _, tt := range testdata {
+		err := checkCRLs(tt.crls, leaf, ca.cert, time.Now(), tt.requireCurrent)
+		if tt.wantErr == nil {
+			if err != nil {
+				t.Errorf("checkCRLs(%v) error = %v", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("checkCRLs(%v) error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckChain(t *testing.T) {
+	root := newTestCA(t, "root")
+	icert, ikey := issue(t, root, "intermediate", 2, true, nil)
+	intermediate := &testCA{cert: icert, key: ikey}
+	good, _ := issue(t, intermediate, "good", 3, false, nil)
+	revoked, _ := issue(t, intermediate, "revoked", 4, false, nil)
+	parse := func(der []byte) []*crl {
+		l, err := x509.ParseRevocationList(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &crl{list: l, revoked: make(map[string]bool)}
+		for _, e := range l.RevokedCertificateEntries {
+			c.revoked[e.SerialNumber.String()] = true
+		}
+		return []*crl{c}
+	}
+	// Only the intermediate CA has a CRL, the intermediate itself isn't checked against the root.
+	crls := parse(intermediate.crl(t, time.Now().Add(time.Hour), 4))
+	if err := checkChain(crls, []*x509.Certificate{good, icert, root.cert}, time.Now(), true); err != nil {
+		t.Errorf("checkChain(good) error = %v", err)
+	}
+	if err := checkChain(crls, []*x509.Certificate{revoked, icert, root.cert}, time.Now(), true); !errors.Is(err, ErrRevoked) {
+		t.Errorf("checkChain(revoked) error = %v, want %v", err, ErrRevoked)
+	}
+	// A revoked intermediate CA is caught by the root's CRL.
+	crls = append(crls, parse(root.crl(t, time.Now().Add(time.Hour), 2))...)
+	if err := checkChain(crls, []*x509.Certificate{good, icert, root.cert}, time.Now(), true); !errors.Is(err, ErrRevoked) {
+		t.Errorf("checkChain(revoked intermediate) error = %v, want %v", err, ErrRevoked)
+	}
+}
+
+func TestCheckOCSP(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	leaf, _ := issue(t, ca, "leaf", 5, false, nil)
+	other, _ := issue(t, ca, "other", 6, false, nil)
+	responderCert, responderKey := issue(t, ca, "responder", 7, false, []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning})
+	responder := &testCA{cert: responderCert, key: responderKey}
+	rogueCert, rogueKey := issue(t, ca, "rogue", 8, false, nil)
+	rogue := &testCA{cert: rogueCert, key: rogueKey}
+	forger := newTestCA(t, "ca")
+	later := time.Now().Add(time.Hour)
+	testdata := []struct {
+		name    string
+		resp    []byte
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "good",
+			resp: ca.ocsp(t, leaf, ocsp.Good, later, nil, 0),
+			want: ocsp.Good,
+		},
+		{
+			name: "revoked",
+			resp: ca.ocsp(t, leaf, ocsp.Revoked, later, nil, 0),
+			want: ocsp.Revoked,
+		},
+		{
+			name: "unknown",
+			resp: ca.ocsp(t, leaf, ocsp.Unknown, later, nil, 0),
+			want: ocsp.Unknown,
+		},
+		{
+			name: "delegated responder",
+			resp: ca.ocsp(t, leaf, ocsp.Good, later, responder, 0),
+			want: ocsp.Good,
+		},
+		{
+			name:    "responder without ocsp signing",
+			resp:    ca.ocsp(t, leaf, ocsp.Good, later, rogue, 0),
+			wantErr: true,
+		},
+		{
+			name:    "forged signature",
+			resp:    forger.ocsp(t, leaf, ocsp.Good, later, nil, 0),
+			wantErr: true,
+		},
+		{
+			name:    "other serial",
+			resp:    ca.ocsp(t, other, ocsp.Good, later, nil, 0),
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			resp:    ca.ocsp(t, leaf, ocsp.Good, time.Now().Add(-time.Minute), nil, 0),
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			resp:    []byte("invalid"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range testdata {
+		got, err := checkOCSP(tt.resp, leaf, ca.cert, time.Now())
+		if err != nil {
+			if !tt.wantErr {
+				t.Errorf("checkOCSP(%v) error = %v", tt.name, err)
+			} else if !errors.Is(err, ErrOCSP) {
+				t.Errorf("checkOCSP(%v) error = %v, want %v", tt.name, err, ErrOCSP)
+			}
+			continue
+		}
+		if tt.wantErr {
+			t.Errorf("checkOCSP(%v) error = nil", tt.name)
+		}
+		if got != tt.want {
+			t.Errorf("checkOCSP(%v) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckOCSP_RSA(t *testing.T) {
+	ca := newRSATestCA(t, "ca")
+	leaf, _ := issue(t, ca, "leaf", 5, false, nil)
+	later := time.Now().Add(time.Hour)
+	for _, algo := range []x509.SignatureAlgorithm{x509.SHA1WithRSA, x509.SHA256WithRSA} {
+		got, err := checkOCSP(ca.ocsp(t, leaf, ocsp.Good, later, nil, algo), leaf, ca.cert, time.Now())
+		if err != nil {
+			t.Errorf("checkOCSP(%v) error = %v", algo, err)
+			continue
+		}
+		if got != ocsp.Good {
+			t.Errorf("checkOCSP(%v) = %v, want %v", algo, got, ocsp.Good)
+		}
+	}
+}
+
+func TestVerifyConnection(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	leaf, _ := issue(t, ca, "leaf", 5, false, nil)
+	chain := [][]*x509.Certificate{{leaf, ca.cert}}
+	later := time.Now().Add(time.Hour)
+	testdata := []struct {
+		name     string
+		resp     []byte
+		failOpen bool
+		wantErr  error
+	}{
+		{
+			name: "no staple",
+		},
+		{
+			name: "good",
+			resp: ca.ocsp(t, leaf, ocsp.Good, later, nil, 0),
+		},
+		{
+			name:     "revoked fail-open",
+			resp:     ca.ocsp(t, leaf, ocsp.Revoked, later, nil, 0),
+			failOpen: true,
+			wantErr:  ErrRevoked,
+		},
+		{
+			name:    "unknown",
+			resp:    ca.ocsp(t, leaf, ocsp.Unknown, later, nil, 0),
+			wantErr: ErrRevocationUnknown,
+		},
+		{
+			name:     "unknown fail-open",
+			resp:     ca.ocsp(t, leaf, ocsp.Unknown, later, nil, 0),
+			failOpen: true,
+		},
+		{
+			name:    "invalid",
+			resp:    []byte("invalid"),
+			wantErr: ErrRevocationUnknown,
+		},
+	}
+	for _, tt := range testdata {
+		r := &Reloader{failOpen: tt.failOpen}
+		err := r.verifyConnection(tls.ConnectionState{OCSPResponse: tt.resp, VerifiedChains: chain})
+		if tt.wantErr == nil {
+			if err != nil {
+				t.Errorf("verifyConnection(%v) error = %v", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("verifyConnection(%v) error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestConfig_OCSP(t *testing.T) {
+	for _, check := range []bool{false, true} {
+		c, err := Config(&tlspb.TlsConfig{CheckOcspStaples: check})
+		if err != nil {
+			t.Fatalf("Config(%v) error = %v", check, err)
+		}
+		// Peers are only checked if asked to.
+		if got := c.VerifyConnection != nil; got != check {
+			t.Errorf("Config(%v) VerifyConnection set = %v, want %v", check, got, check)
+		}
+		if c.VerifyPeerCertificate != nil {
+			t.Errorf("Config(%v) VerifyPeerCertificate set without crl_files", check)
+		}
+	}
+}
+
+func TestCertConfig_CRL(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "ca")
+	write := func(name, typ string, der []byte) string {
+		f := filepath.Join(dir, name)
+		if err := os.WriteFile(f, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	keyFile := func(name string, key crypto.Signer) string {
+		der, err := x509.MarshalECPrivateKey(key.(*ecdsa.PrivateKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return write(name, "EC PRIVATE KEY", der)
+	}
+	caFile := write("ca.pem", "CERTIFICATE", ca.cert.Raw)
+	serverCert, serverKey := issue(t, ca, "server", 2, false, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
+	goodCert, goodKey := issue(t, ca, "good", 3, false, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth})
+	revokedCert, revokedKey := issue(t, ca, "revoked", 4, false, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth})
+	crlFile := write("ca.crl", "X509 CRL", ca.crl(t, time.Now().Add(time.Hour), 4))
+
+	testdata := []struct {
+		name     string
+		cert     *x509.Certificate
+		key      crypto.Signer
+		crlFiles []string
+		mode     tlspb.TlsConfig_RevocationFailureMode
+		ok       bool
+	}{
+		{
+			name:     "good",
+			cert:     goodCert,
+			key:      goodKey,
+			crlFiles: []string{crlFile},
+			ok:       true,
+		},
+		{
+			name:     "revoked",
+			cert:     revokedCert,
+			key:      revokedKey,
+			crlFiles: []string{crlFile},
+		},
+		{
+			name:     "revoked fail-open",
+			cert:     revokedCert,
+			key:      revokedKey,
+			crlFiles: []string{crlFile},
+			mode:     tlspb.TlsConfig_FAIL_OPEN,
+		},
+		{
+			name: "no crls",
+			cert: revokedCert,
+			key:  revokedKey,
+			ok:   true,
+		},
+	}
+	for i, tt := range testdata {
+		srv, err := CertConfig(&tlspb.TlsConfig{
+			CertFile:              write("server.pem", "CERTIFICATE", serverCert.Raw),
+			KeyFile:               keyFile("server.key", serverKey),
+			ClientCaCerts:         []string{caFile},
+			CrlFiles:              tt.crlFiles,
+			RevocationFailureMode: tt.mode,
+		})
+		if err != nil {
+			t.Fatalf("CertConfig(%v) error = %v", tt.name, err)
+		}
+		client, err := CertConfig(&tlspb.TlsConfig{
+			CertFile:    write("client.pem", "CERTIFICATE", tt.cert.Raw),
+			KeyFile:     keyFile("client.key", tt.key),
+			RootCaCerts: []string{caFile},
+		})
+		if err != nil {
+			t.Fatalf("CertConfig(%v) error = %v", tt.name, err)
+		}
+		client.ServerName = "127.0.0.1"
+		l, err := tls.Listen("tcp", "127.0.0.1:0", srv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errc := make(chan error, 1)
+		go func() {
+			c, err := l.Accept()
+			if err != nil {
+				errc <- err
+				return
+			}
+			defer c.Close()
+			errc <- c.(*tls.Conn).Handshake()
+		}()
+		if c, err := tls.Dial("tcp", l.Addr().String(), client); err == nil {
+			c.Close()
+		}
+		err = <-errc
+		l.Close()
+		if err != nil {
+			if tt.ok {
+				t.Errorf("Handshake(%v, %v) error = %v", i, tt.name, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("Handshake(%v, %v) error = nil", i, tt.name)
+		}
+	}
+}
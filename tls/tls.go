@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
@@ -16,7 +17,7 @@ import (
 )
 
 const (
-	// TLSMinVersion is the minimum SSL/TLS version supported.
+	// TLSMinVersion is the minimum SSL/TLS version supported, unless min_version is set.
 	TLSMinVersion = tls.VersionTLS12
 )
 
@@ -46,56 +47,45 @@ func ClientAuthType(t tlspb.TlsConfig_ClientAuthType) (tls.ClientAuthType, error
 
 // Config creates a *tls.Config directive from a proto message.
 // Returns nil if TLS is disabled in the config.
+// Client CA pools are reloaded from disk as they change, see reload_interval; clients should use Reloader.ClientConfig.
 func Config(cfg *tlspb.TlsConfig) (*tls.Config, error) {
-	if cfg.GetTlsMode() == tlspb.TlsConfig_TLS_MODE_DISABLED {
-		return nil, nil
-	}
-	cat, err := ClientAuthType(cfg.GetClientAuthType())
-	if err != nil {
-		return nil, err
-	}
-	c := &tls.Config{
-		ClientAuth: cat,
-		MinVersion: TLSMinVersion,
-	}
-	clientCAs, err := loadCerts(cfg.GetClientCaCerts())
-	if err != nil {
+	r, err := newReloader(cfg, false)
+	if err != nil || r == nil {
 		return nil, err
 	}
-	c.ClientCAs = clientCAs
-	rootCAs, err := loadCerts(cfg.GetRootCaCerts())
-	if err != nil {
-		return nil, err
-	}
-	c.RootCAs = rootCAs
-	return c, nil
+	return r.Config(), nil
 }
 
 // CertConfig creates a *tls.Config directive from a proto message,
 // loading an X.509 certificate from the cert/key files specified.
+// The certificate and client CA pools are reloaded from disk as they change, see reload_interval;
+// clients should use Reloader.ClientConfig.
 func CertConfig(cfg *tlspb.TlsConfig) (*tls.Config, error) {
-	c, err := Config(cfg)
-	if err != nil {
+	r, err := newReloader(cfg, true)
+	if err != nil || r == nil {
 		return nil, err
 	}
-	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
-	if err != nil {
-		return nil, err
-	}
-	c.Certificates = []tls.Certificate{cert}
-	return c, nil
+	return r.Config(), nil
 }
 
 // TransportCreds returns the correct credentials for a gRPC connection.
+// The certificate and CA pools are reloaded from disk as they change, see reload_interval.
 func TransportCreds(cfg *tlspb.TlsConfig) (credentials.TransportCredentials, error) {
 	if cfg.GetTlsMode() == tlspb.TlsConfig_TLS_MODE_DISABLED {
 		return insecure.NewCredentials(), nil
 	}
-	tlsCfg, err := CertConfig(cfg)
+	r, err := newReloader(cfg, true)
 	if err != nil {
 		return nil, fmt.Errorf("CertConfig() error: %w", err)
 	}
-	return credentials.NewTLS(tlsCfg), nil
+	// Configs for reloaded client CA pools are derived from the base config, which must advertise HTTP/2 for gRPC.
+	if c := r.Config(); !slices.Contains(c.NextProtos, "h2") {
+		c.NextProtos = append(c.NextProtos, "h2")
+	}
+	return &reloadingCreds{
+		TransportCredentials: credentials.NewTLS(r.Config()),
+		r:                    r,
+	}, nil
 }
 
 // loadCerts loads all the public certificates into a CertPool.
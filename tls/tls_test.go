@@ -114,6 +114,7 @@ func TestConfig(t *testing.T) {
 
 		got.RootCAs = nil
 		got.ClientCAs = nil
+		got.GetConfigForClient = nil
 		if diff := pretty.Compare(got, tt.want); diff != "" {
 			t.Errorf("Config(%v) diff (-got +want):\n%v", tt.name, diff)
 		}
@@ -169,20 +170,24 @@ func TestCertConfig(t *testing.T) {
 			t.Errorf("CertConfig(%v) error = nil", tt.name)
 		}
 
+		tlsCert, err := got.GetCertificate(nil)
+		if err != nil {
+			t.Errorf("GetCertificate(%v) error = %v", tt.name, err)
+			continue
+		}
 		var subjects []string
-		for i, tlsCert := range got.Certificates {
-			for k, tc := range tlsCert.Certificate {
-				cert, err := x509.ParseCertificate(tc)
-				if err != nil {
-					t.Errorf("ParseCertificate(%v, %v, %v) error = %v", tt.name, i, k, err)
-				}
-				subjects = append(subjects, cert.Subject.String())
+		for k, tc := range tlsCert.Certificate {
+			cert, err := x509.ParseCertificate(tc)
+			if err != nil {
+				t.Errorf("ParseCertificate(%v, %v) error = %v", tt.name, k, err)
 			}
+			subjects = append(subjects, cert.Subject.String())
 		}
 		if diff := pretty.Compare(subjects, tt.subjects); diff != "" {
 			t.Errorf("subjects(%v) diff (-got +want):\n%v", tt.name, diff)
 		}
-		got.Certificates = nil
+		got.GetCertificate = nil
+		got.GetClientCertificate = nil
 
 		if diff := pretty.Compare(got, tt.want); diff != "" {
 			t.Errorf("CertConfig(%v) diff (-got +want):\n%v", tt.name, diff)
@@ -0,0 +1,136 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/tokenpb"
+)
+
+// minSecretLen is the minimum length for HMAC secrets.
+const minSecretLen = 32
+
+var (
+	// ErrBadKey is returned when a key is invalid.
+	ErrBadKey = errors.New("bad key")
+
+	// ErrNoPrivateKey is returned when signing with a key that can only verify tokens.
+	ErrNoPrivateKey = errors.New("no private key")
+)
+
+// A key signs and verifies tokens, exactly one of hmac, pub must be set.
+type key struct {
+	id   string
+	hmac []byte
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+}
+
+// sign returns the signature for b.
+func (k *key) sign(b []byte) ([]byte, error) {
+	if k.hmac != nil {
+		m := hmac.New(sha256.New, k.hmac)
+		m.Write(b)
+		return m.Sum(nil), nil
+	}
+	if k.priv == nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoPrivateKey, k.id)
+	}
+	return ed25519.Sign(k.priv, b), nil
+}
+
+// verify returns whether sig is a valid signature for b.
+func (k *key) verify(b, sig []byte) bool {
+	if k.hmac != nil {
+		want, err := k.sign(b)
+		return err == nil && hmac.Equal(sig, want)
+	}
+	return ed25519.Verify(k.pub, b, sig)
+}
+
+// readPEM reads a single PEM block of the given type from a file.
+func readPEM(name, typ string) ([]byte, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := pem.Decode(buf)
+	if b == nil || b.Type != typ {
+		return nil, fmt.Errorf("%w: %v: no %v PEM block", ErrBadKey, name, typ)
+	}
+	return b.Bytes, nil
+}
+
+// newKey loads a key from a Key proto message.
+func newKey(pb *tokenpb.TokenOptions_Key) (*key, error) {
+	k := &key{id: pb.GetId()}
+	if k.id == "" {
+		return nil, fmt.Errorf("%w: id must be set", ErrBadKey)
+	}
+	switch pb.GetKey().(type) {
+	case *tokenpb.TokenOptions_Key_HmacSecretFile:
+		b, err := os.ReadFile(pb.GetHmacSecretFile())
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < minSecretLen {
+			return nil, fmt.Errorf("%w: %v: HMAC secret must be at least %v bytes", ErrBadKey, k.id, minSecretLen)
+		}
+		k.hmac = b
+	case *tokenpb.TokenOptions_Key_Ed25519PrivateKeyFile:
+		b, err := readPEM(pb.GetEd25519PrivateKeyFile(), "PRIVATE KEY")
+		if err != nil {
+			return nil, err
+		}
+		priv, err := x509.ParsePKCS8PrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("x509.ParsePKCS8PrivateKey(%v) error: %w", k.id, err)
+		}
+		var ok bool
+		if k.priv, ok = priv.(ed25519.PrivateKey); !ok {
+			return nil, fmt.Errorf("%w: %v: not an Ed25519 key", ErrBadKey, k.id)
+		}
+		k.pub = k.priv.Public().(ed25519.PublicKey)
+	case *tokenpb.TokenOptions_Key_Ed25519PublicKeyFile:
+		b, err := readPEM(pb.GetEd25519PublicKeyFile(), "PUBLIC KEY")
+		if err != nil {
+			return nil, err
+		}
+		pub, err := x509.ParsePKIXPublicKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("x509.ParsePKIXPublicKey(%v) error: %w", k.id, err)
+		}
+		var ok bool
+		if k.pub, ok = pub.(ed25519.PublicKey); !ok {
+			return nil, fmt.Errorf("%w: %v: not an Ed25519 key", ErrBadKey, k.id)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %v: key must be set", ErrBadKey, k.id)
+	}
+	return k, nil
+}
+
+// loadKeys loads all keys from a TokenOptions proto message, indexed by ID.
+func loadKeys(opts *tokenpb.TokenOptions) (map[string]*key, error) {
+	if len(opts.GetKeys()) == 0 {
+		return nil, fmt.Errorf("%w: keys must be set", ErrBadKey)
+	}
+	keys := make(map[string]*key)
+	for _, pb := range opts.GetKeys() {
+		k, err := newKey(pb)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := keys[k.id]; ok {
+			return nil, fmt.Errorf("%w: duplicate key ID %v", ErrBadKey, k.id)
+		}
+		keys[k.id] = k
+	}
+	return keys, nil
+}
@@ -0,0 +1,198 @@
+// Package token implements signed authorization tokens,
+// minted by the Cookie Server and verified by the SSH relays.
+//
+// A token is made of three unpadded base64url-encoded parts separated by dots:
+// the key ID, the JSON-encoded Claims, and the signature over the first two (encoded) parts.
+package token
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hazaelsan/ssh-relay/duration"
+	"github.com/hazaelsan/ssh-relay/proto/v1/destinationpb"
+	"github.com/hazaelsan/ssh-relay/proto/v1/tokenpb"
+)
+
+var (
+	// ErrBadToken is returned when a token is malformed.
+	ErrBadToken = errors.New("bad token")
+
+	// ErrUnknownKey is returned when a token is signed with an unknown key.
+	ErrUnknownKey = errors.New("unknown key")
+
+	// ErrBadSignature is returned when a token signature is not valid.
+	ErrBadSignature = errors.New("bad signature")
+
+	// ErrExpired is returned when a token has expired.
+	ErrExpired = errors.New("token expired")
+)
+
+var enc = base64.RawURLEncoding
+
+// A PortRange is an inclusive range of TCP ports.
+type PortRange struct {
+	Start int32 `json:"start"`
+	End   int32 `json:"end,omitempty"`
+}
+
+// A Destination is a destination the token holder may connect to,
+// see destinationpb.DestinationMatcher.
+type Destination struct {
+	HostGlob  string      `json:"host_glob,omitempty"`
+	HostRegex string      `json:"host_regex,omitempty"`
+	Ports     []PortRange `json:"ports,omitempty"`
+}
+
+// DestinationFromProto creates a Destination from a DestinationMatcher proto message.
+// Origins are ignored, the token origin is used instead.
+func DestinationFromProto(pb *destinationpb.DestinationMatcher) Destination {
+	d := Destination{
+		HostGlob:  pb.GetHostGlob(),
+		HostRegex: pb.GetHostRegex(),
+	}
+	for _, p := range pb.GetPorts() {
+		d.Ports = append(d.Ports, PortRange{Start: p.GetStart(), End: p.GetEnd()})
+	}
+	return d
+}
+
+// Proto returns the DestinationMatcher proto message for a Destination.
+func (d Destination) Proto() *destinationpb.DestinationMatcher {
+	pb := new(destinationpb.DestinationMatcher)
+	switch {
+	case d.HostGlob != "":
+		pb.Host = &destinationpb.DestinationMatcher_HostGlob{HostGlob: d.HostGlob}
+	case d.HostRegex != "":
+		pb.Host = &destinationpb.DestinationMatcher_HostRegex{HostRegex: d.HostRegex}
+	}
+	for _, p := range d.Ports {
+		pb.Ports = append(pb.Ports, &destinationpb.PortRange{Start: p.Start, End: p.End})
+	}
+	return pb
+}
+
+// Claims are the contents of a token.
+type Claims struct {
+	// Identity is the client identity, as set by the Cookie Server backend.
+	Identity string `json:"sub,omitempty"`
+
+	// Origin is the client origin.
+	Origin string `json:"origin"`
+
+	// Expiry is when the token expires, in seconds since the Unix epoch.
+	Expiry int64 `json:"exp"`
+
+	// Destinations are the destinations the client may connect to, if empty all destinations are allowed.
+	Destinations []Destination `json:"dst,omitempty"`
+}
+
+// NewSigner creates a *Signer from a TokenOptions proto message.
+func NewSigner(opts *tokenpb.TokenOptions) (*Signer, error) {
+	keys, err := loadKeys(opts)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := keys[opts.GetSigningKeyId()]
+	if !ok {
+		return nil, fmt.Errorf("%w: signing_key_id %q", ErrUnknownKey, opts.GetSigningKeyId())
+	}
+	if k.hmac == nil && k.priv == nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoPrivateKey, k.id)
+	}
+	s := &Signer{
+		key: k,
+		now: time.Now,
+	}
+	if err := duration.FromProto(&s.maxAge, opts.GetCookie().GetMaxAge()); err != nil {
+		return nil, err
+	}
+	if s.maxAge <= 0 {
+		return nil, errors.New("cookie.max_age must be set")
+	}
+	return s, nil
+}
+
+// A Signer mints tokens.
+type Signer struct {
+	key    *key
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+// MaxAge returns the lifetime of tokens minted by the Signer.
+func (s *Signer) MaxAge() time.Duration {
+	return s.maxAge
+}
+
+// Sign returns a signed token, Expiry is set based on the token lifetime.
+func (s *Signer) Sign(c Claims) (string, error) {
+	c.Expiry = s.now().Add(s.maxAge).Unix()
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal() error: %w", err)
+	}
+	payload := enc.EncodeToString([]byte(s.key.id)) + "." + enc.EncodeToString(b)
+	sig, err := s.key.sign([]byte(payload))
+	if err != nil {
+		return "", err
+	}
+	return payload + "." + enc.EncodeToString(sig), nil
+}
+
+// NewVerifier creates a *Verifier from a TokenOptions proto message.
+func NewVerifier(opts *tokenpb.TokenOptions) (*Verifier, error) {
+	keys, err := loadKeys(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Verifier{
+		keys: keys,
+		now:  time.Now,
+	}, nil
+}
+
+// A Verifier verifies tokens minted by a Signer.
+type Verifier struct {
+	keys map[string]*key
+	now  func() time.Time
+}
+
+// Verify verifies a token, returns its Claims if the token is valid and has not expired.
+func (v *Verifier) Verify(tok string) (*Claims, error) {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return nil, ErrBadToken
+	}
+	id, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: key ID: %w", ErrBadToken, err)
+	}
+	k, ok := v.keys[string(id)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %w", ErrBadToken, err)
+	}
+	if !k.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrBadSignature
+	}
+	b, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: claims: %w", ErrBadToken, err)
+	}
+	c := new(Claims)
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("%w: claims: %w", ErrBadToken, err)
+	}
+	if !v.now().Before(time.Unix(c.Expiry, 0)) {
+		return nil, ErrExpired
+	}
+	return c, nil
+}
@@ -0,0 +1,296 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/hazaelsan/ssh-relay/proto/v1/cookiepb"
+	"github.com/hazaelsan/ssh-relay/proto/v1/destinationpb"
+	"github.com/hazaelsan/ssh-relay/proto/v1/tokenpb"
+)
+
+// writeFile writes a file to a temporary directory, returns its path.
+func writeFile(t *testing.T, name string, b []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// testKeys returns Key messages for an HMAC secret and an Ed25519 key pair.
+func testKeys(t *testing.T) (hmacKey, privKey, pubKey *tokenpb.TokenOptions_Key) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacKey = &tokenpb.TokenOptions_Key{
+		Id:  "hmac",
+		Key: &tokenpb.TokenOptions_Key_HmacSecretFile{HmacSecretFile: writeFile(t, "secret", []byte(strings.Repeat("s", minSecretLen)))},
+	}
+	privKey = &tokenpb.TokenOptions_Key{
+		Id: "ed25519",
+		Key: &tokenpb.TokenOptions_Key_Ed25519PrivateKeyFile{
+			Ed25519PrivateKeyFile: writeFile(t, "priv.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})),
+		},
+	}
+	pubKey = &tokenpb.TokenOptions_Key{
+		Id: "ed25519",
+		Key: &tokenpb.TokenOptions_Key_Ed25519PublicKeyFile{
+			Ed25519PublicKeyFile: writeFile(t, "pub.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})),
+		},
+	}
+	return hmacKey, privKey, pubKey
+}
+
+func cookie() *cookiepb.Cookie {
+	return &cookiepb.Cookie{
+		Name:   "token",
+		MaxAge: &durationpb.Duration{Seconds: 60},
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	hmacKey, privKey, pubKey := testKeys(t)
+	claims := Claims{
+		Identity: "foo@example.org",
+		Origin:   "chrome-extension://foo",
+		Destinations: []Destination{
+			{
+				HostGlob: "*.example.org",
+				Ports:    []PortRange{{Start: 22}},
+			},
+		},
+	}
+	testdata := []struct {
+		name   string
+		signer *tokenpb.TokenOptions
+		keys   []*tokenpb.TokenOptions_Key
+	}{
+		{
+			name: "hmac",
+			signer: &tokenpb.TokenOptions{
+				Cookie:       cookie(),
+				Keys:         []*tokenpb.TokenOptions_Key{hmacKey},
+				SigningKeyId: "hmac",
+			},
+			keys: []*tokenpb.TokenOptions_Key{hmacKey},
+		},
+		{
+			name: "ed25519",
+			signer: &tokenpb.TokenOptions{
+				Cookie:       cookie(),
+				Keys:         []*tokenpb.TokenOptions_Key{privKey},
+				SigningKeyId: "ed25519",
+			},
+			keys: []*tokenpb.TokenOptions_Key{pubKey},
+		},
+		{
+			name: "rotation",
+			signer: &tokenpb.TokenOptions{
+				Cookie:       cookie(),
+				Keys:         []*tokenpb.TokenOptions_Key{hmacKey, privKey},
+				SigningKeyId: "ed25519",
+			},
+			keys: []*tokenpb.TokenOptions_Key{hmacKey, pubKey},
+		},
+	}
+	now := time.Unix(1000, 0)
+	for _, tt := range testdata {
+		s, err := NewSigner(tt.signer)
+		if err != nil {
+			t.Errorf("NewSigner(%v) error = %v", tt.name, err)
+			continue
+		}
+		s.now = func() time.Time { return now }
+		tok, err := s.Sign(claims)
+		if err != nil {
+			t.Errorf("Sign(%v) error = %v", tt.name, err)
+			continue
+		}
+		v, err := NewVerifier(&tokenpb.TokenOptions{Cookie: cookie(), Keys: tt.keys})
+		if err != nil {
+			t.Errorf("NewVerifier(%v) error = %v", tt.name, err)
+			continue
+		}
+		v.now = func() time.Time { return now }
+		got, err := v.Verify(tok)
+		if err != nil {
+			t.Errorf("Verify(%v) error = %v", tt.name, err)
+			continue
+		}
+		want := claims
+		want.Expiry = now.Add(time.Minute).Unix()
+		if diff := pretty.Compare(got, &want); diff != "" {
+			t.Errorf("Verify(%v) diff (-got +want):\n%v", tt.name, diff)
+		}
+
+		// Tokens expire.
+		v.now = func() time.Time { return now.Add(time.Minute) }
+		if _, err := v.Verify(tok); !errors.Is(err, ErrExpired) {
+			t.Errorf("Verify(%v) expired error = %v, want %v", tt.name, err, ErrExpired)
+		}
+	}
+}
+
+func TestVerify_Failures(t *testing.T) {
+	hmacKey, privKey, _ := testKeys(t)
+	s, err := NewSigner(&tokenpb.TokenOptions{
+		Cookie:       cookie(),
+		Keys:         []*tokenpb.TokenOptions_Key{hmacKey},
+		SigningKeyId: "hmac",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := s.Sign(Claims{Origin: "chrome-extension://foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(tok, ".")
+	forged := enc.EncodeToString([]byte(`{"origin":"chrome-extension://bar","exp":99999999999}`))
+	testdata := []struct {
+		name string
+		keys []*tokenpb.TokenOptions_Key
+		tok  string
+		want error
+	}{
+		{
+			name: "malformed",
+			keys: []*tokenpb.TokenOptions_Key{hmacKey},
+			tok:  "foo",
+			want: ErrBadToken,
+		},
+		{
+			name: "unknown key",
+			keys: []*tokenpb.TokenOptions_Key{privKey},
+			tok:  tok,
+			want: ErrUnknownKey,
+		},
+		{
+			name: "forged claims",
+			keys: []*tokenpb.TokenOptions_Key{hmacKey},
+			tok:  strings.Join([]string{parts[0], forged, parts[2]}, "."),
+			want: ErrBadSignature,
+		},
+		{
+			name: "bad signature encoding",
+			keys: []*tokenpb.TokenOptions_Key{hmacKey},
+			tok:  strings.Join([]string{parts[0], parts[1], "!"}, "."),
+			want: ErrBadToken,
+		},
+	}
+	for _, tt := range testdata {
+		v, err := NewVerifier(&tokenpb.TokenOptions{Keys: tt.keys})
+		if err != nil {
+			t.Errorf("NewVerifier(%v) error = %v", tt.name, err)
+			continue
+		}
+		if _, err := v.Verify(tt.tok); !errors.Is(err, tt.want) {
+			t.Errorf("Verify(%v) error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestNewSigner_Failures(t *testing.T) {
+	hmacKey, _, pubKey := testKeys(t)
+	testdata := []struct {
+		name string
+		opts *tokenpb.TokenOptions
+	}{
+		{
+			name: "no keys",
+			opts: &tokenpb.TokenOptions{Cookie: cookie()},
+		},
+		{
+			name: "unknown signing key",
+			opts: &tokenpb.TokenOptions{
+				Cookie:       cookie(),
+				Keys:         []*tokenpb.TokenOptions_Key{hmacKey},
+				SigningKeyId: "foo",
+			},
+		},
+		{
+			name: "public key",
+			opts: &tokenpb.TokenOptions{
+				Cookie:       cookie(),
+				Keys:         []*tokenpb.TokenOptions_Key{pubKey},
+				SigningKeyId: "ed25519",
+			},
+		},
+		{
+			name: "duplicate key",
+			opts: &tokenpb.TokenOptions{
+				Cookie:       cookie(),
+				Keys:         []*tokenpb.TokenOptions_Key{hmacKey, hmacKey},
+				SigningKeyId: "hmac",
+			},
+		},
+		{
+			name: "short secret",
+			opts: &tokenpb.TokenOptions{
+				Cookie: cookie(),
+				Keys: []*tokenpb.TokenOptions_Key{
+					{
+						Id:  "short",
+						Key: &tokenpb.TokenOptions_Key_HmacSecretFile{HmacSecretFile: writeFile(t, "short", []byte("short"))},
+					},
+				},
+				SigningKeyId: "short",
+			},
+		},
+		{
+			name: "no max_age",
+			opts: &tokenpb.TokenOptions{
+				Cookie:       &cookiepb.Cookie{Name: "token"},
+				Keys:         []*tokenpb.TokenOptions_Key{hmacKey},
+				SigningKeyId: "hmac",
+			},
+		},
+	}
+	for _, tt := range testdata {
+		if _, err := NewSigner(tt.opts); err == nil {
+			t.Errorf("NewSigner(%v) error = nil", tt.name)
+		}
+	}
+}
+
+func TestDestination(t *testing.T) {
+	pb := &destinationpb.DestinationMatcher{
+		Host:    &destinationpb.DestinationMatcher_HostRegex{HostRegex: "ssh[0-9]+"},
+		Ports:   []*destinationpb.PortRange{{Start: 22}, {Start: 2200, End: 2299}},
+		Origins: []string{"chrome-extension://foo"},
+	}
+	want := Destination{
+		HostRegex: "ssh[0-9]+",
+		Ports:     []PortRange{{Start: 22}, {Start: 2200, End: 2299}},
+	}
+	got := DestinationFromProto(pb)
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("DestinationFromProto() diff (-got +want):\n%v", diff)
+	}
+	if diff := pretty.Compare(DestinationFromProto(got.Proto()), want); diff != "" {
+		t.Errorf("Proto() diff (-got +want):\n%v", diff)
+	}
+}